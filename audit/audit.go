@@ -0,0 +1,119 @@
+/*
+Package audit records security-relevant and content events in an append-only log kept in
+the schoogaudit collection. Events are only ever inserted; the retention job is the only
+thing that removes them. The one exception is erasure: purging an account strips the
+user's email, IP and personal details from its events, keeping them under the account's ID.
+*/
+package audit
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/Aman913k/database"
+	"github.com/Aman913k/middleware"
+	"github.com/Aman913k/utils"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Actions recorded in the audit log.
+const (
+	ActionLogin           = "auth.login"
+	ActionLoginFailed     = "auth.login_failed"
+	ActionProfileUpdate   = "user.profile_update"
+	ActionPasswordChange  = "user.password_change"
+	ActionMFAEnable       = "user.mfa_enable"
+	ActionMFADisable      = "user.mfa_disable"
+	ActionRecoveryCodes   = "user.recovery_codes_regenerate"
+	ActionAPIKeyCreate    = "user.api_key_create"
+	ActionAPIKeyRevoke    = "user.api_key_revoke"
+	ActionIdentityLink    = "user.identity_link"
+	ActionDeletionRequest = "user.deletion_request"
+	ActionDeletionCancel  = "user.deletion_cancel"
+	ActionPostCreate      = "post.create"
+	ActionPostUpdate      = "post.update"
+	ActionPostDelete      = "post.delete"
+
+	ActionUserSuspend       = "admin.user.suspend"
+	ActionUserUnsuspend     = "admin.user.unsuspend"
+	ActionUserForceReset    = "admin.user.force_password_reset"
+	ActionUserRoleChange    = "admin.user.role_change"
+	ActionUserDelete        = "admin.user.delete"
+	ActionUserPasswordReset = "user.password_reset"
+)
+
+// Target types of audit events.
+const (
+	TargetUser   = "user"
+	TargetPost   = "post"
+	TargetAPIKey = "api_key"
+)
+
+// ActorCLI identifies actions taken through the command line instead of the API.
+const ActorCLI = "cli"
+
+// Event is one entry of the audit log.
+// @Description Audit log entry
+// @Name AuditEvent
+type Event struct {
+	ID     primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	Time   time.Time          `json:"time" bson:"time"`
+	Action string             `json:"action" bson:"action"`
+	// ActorID is the user who acted, or ActorCLI
+	ActorID    string `json:"actor_id,omitempty" bson:"actor_id,omitempty"`
+	ActorEmail string `json:"actor_email,omitempty" bson:"actor_email,omitempty"`
+	IP         string `json:"ip,omitempty" bson:"ip,omitempty"`
+	RequestID  string `json:"request_id,omitempty" bson:"request_id,omitempty"`
+	// TargetType and TargetID name what the action was taken on, such as a user or a post
+	TargetType string `json:"target_type,omitempty" bson:"target_type,omitempty"`
+	TargetID   string `json:"target_id,omitempty" bson:"target_id,omitempty"`
+	// Before and After hold the changed fields of the target
+	Before  map[string]interface{} `json:"before,omitempty" bson:"before,omitempty"`
+	After   map[string]interface{} `json:"after,omitempty" bson:"after,omitempty"`
+	Details map[string]interface{} `json:"details,omitempty" bson:"details,omitempty"`
+}
+
+// FromRequest starts an event for action with the authenticated actor, client IP and request ID of r.
+func FromRequest(r *http.Request, action string) Event {
+	actorID, _ := r.Context().Value(middleware.UserIDContextKey).(string)
+	actorEmail, _ := r.Context().Value(middleware.EmailContextKey).(string)
+	return Event{
+		Action:     action,
+		ActorID:    actorID,
+		ActorEmail: actorEmail,
+		IP:         utils.ClientIP(r),
+		RequestID:  utils.RequestID(r),
+	}
+}
+
+// On sets the target of the event.
+func (e Event) On(targetType, targetID string) Event {
+	e.TargetType = targetType
+	e.TargetID = targetID
+	return e
+}
+
+// Record appends an event to the audit log. Recording is best effort: a failure is logged
+// but doesn't undo the audited action, which has already happened. The event is recorded
+// even if ctx is cancelled, such as when the client of the request goes away.
+func Record(ctx context.Context, event Event) {
+	ctx = context.WithoutCancel(ctx)
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+	if _, err := database.GetCollection("schoogaudit").InsertOne(ctx, event); err != nil {
+		middleware.Logger(ctx).Error("Failed to record audit event",
+			"action", event.Action, "target_type", event.TargetType, "target_id", event.TargetID, "error", err)
+	}
+}
+
+// Purge deletes the events recorded before the given time, returning how many were deleted.
+func Purge(ctx context.Context, before time.Time) (int64, error) {
+	result, err := database.GetCollection("schoogaudit").DeleteMany(ctx, bson.M{"time": bson.M{"$lt": before}})
+	if err != nil {
+		return 0, err
+	}
+	return result.DeletedCount, nil
+}
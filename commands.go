@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+
+	"github.com/Aman913k/audit"
+	"github.com/Aman913k/database"
+	"github.com/Aman913k/lockout"
+	"github.com/Aman913k/migrations"
+	"github.com/Aman913k/models"
+	"github.com/Aman913k/oidc/oidctest"
+	"github.com/Aman913k/utils"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const migrateUsage = "usage: migrate up | down [steps] | status"
+
+// runMigrate implements the migrate subcommand.
+func runMigrate(args []string) {
+	if len(args) == 0 {
+		log.Fatal(migrateUsage)
+	}
+
+	ctx := context.Background()
+	switch args[0] {
+	case "up":
+		applied, err := migrations.Up(ctx)
+		for _, m := range applied {
+			fmt.Printf("Applied %d: %s\n", m.Version, m.Description)
+		}
+		if err != nil {
+			log.Fatal("Error applying migrations: ", err)
+		}
+		if len(applied) == 0 {
+			fmt.Println("Database is up to date.")
+		}
+
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			n, err := strconv.Atoi(args[1])
+			if err != nil || n < 1 {
+				log.Fatal(migrateUsage)
+			}
+			steps = n
+		}
+
+		rolledBack, err := migrations.Down(ctx, steps)
+		for _, m := range rolledBack {
+			fmt.Printf("Rolled back %d: %s\n", m.Version, m.Description)
+		}
+		if err != nil {
+			log.Fatal("Error rolling back migrations: ", err)
+		}
+
+	case "status":
+		statuses, err := migrations.Statuses(ctx)
+		if err != nil {
+			log.Fatal("Error reading migration status: ", err)
+		}
+		for _, s := range statuses {
+			state := "pending"
+			if s.Applied {
+				state = "applied " + s.AppliedAt.Format("2006-01-02 15:04:05")
+			}
+			fmt.Printf("%3d  %-28s  %s\n", s.Version, state, s.Description)
+		}
+
+	default:
+		log.Fatal(migrateUsage)
+	}
+}
+
+// runUnlock implements the unlock subcommand, clearing an account's login lockout.
+func runUnlock(args []string) {
+	if len(args) != 1 {
+		log.Fatal("usage: unlock <email>")
+	}
+
+	guard := lockout.NewGuard(lockout.MongoStore{})
+	if err := guard.Unlock(context.Background(), utils.NormalizeEmail(args[0])); err != nil {
+		log.Fatal("Error unlocking account: ", err)
+	}
+	fmt.Println("Account unlocked.")
+}
+
+// runMockOIDC implements the mock-oidc subcommand, serving a mock identity provider for
+// trying out OIDC login locally.
+func runMockOIDC(args []string) {
+	addr := "localhost:5556"
+	if len(args) > 0 {
+		addr = args[0]
+	}
+
+	user := oidctest.User{
+		Subject:       "mock-user",
+		Email:         os.Getenv("MOCK_OIDC_EMAIL"),
+		EmailVerified: true,
+		Name:          os.Getenv("MOCK_OIDC_NAME"),
+	}
+	if user.Email == "" {
+		user.Email = "student@example.edu"
+	}
+	if user.Name == "" {
+		user.Name = "Mock Student"
+	}
+
+	issuer, err := oidctest.New("http://"+addr, "schooglink", "secret", user)
+	if err != nil {
+		log.Fatal("Error creating mock issuer: ", err)
+	}
+	fmt.Printf("Mock OIDC issuer at %s signing in %s\n", issuer.URL, user.Email)
+	log.Fatal(http.ListenAndServe(addr, issuer))
+}
+
+// runSetRole implements the set-role subcommand, used to appoint the first administrator.
+func runSetRole(args []string) {
+	if len(args) != 2 || (args[1] != models.RoleAdmin && args[1] != models.RoleUser) {
+		log.Fatal("usage: set-role <email> admin|user")
+	}
+
+	// Regular users have no role stored
+	update := bson.M{"$set": bson.M{"role": models.RoleAdmin}}
+	if args[1] == models.RoleUser {
+		update = bson.M{"$unset": bson.M{"role": ""}}
+	}
+
+	ctx := context.Background()
+	var user models.User
+	err := database.GetCollection("schooguser").FindOneAndUpdate(ctx,
+		bson.M{"email": utils.NormalizeEmail(args[0])}, update,
+	).Decode(&user)
+	if err == mongo.ErrNoDocuments {
+		log.Fatal("No user with email ", args[0])
+	} else if err != nil {
+		log.Fatal("Error changing role: ", err)
+	}
+
+	previous := user.Role
+	if previous == "" {
+		previous = models.RoleUser
+	}
+	audit.Record(ctx, audit.Event{
+		Action:     audit.ActionUserRoleChange,
+		ActorID:    audit.ActorCLI,
+		TargetType: audit.TargetUser,
+		TargetID:   user.ID.Hex(),
+		Before:     map[string]interface{}{"role": previous},
+		After:      map[string]interface{}{"role": args[1]},
+	})
+	fmt.Printf("%s is now %s.\n", user.Email, args[1])
+}
@@ -0,0 +1,302 @@
+package controller
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/Aman913k/audit"
+	"github.com/Aman913k/database"
+	"github.com/Aman913k/jobs"
+	"github.com/Aman913k/lockout"
+	"github.com/Aman913k/middleware"
+	"github.com/Aman913k/models"
+	"github.com/Aman913k/problem"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// exportSection is a collection holding documents about a user, selected by Filter.
+// Comments and reactions are not stored by the API yet; their collections are added here once they exist.
+// jobs.PurgeAccount must delete or anonymise every collection listed here.
+type exportSection struct {
+	Name       string
+	Collection string
+	Filter     func(user *models.User) bson.M
+	// Omit lists fields holding credentials rather than personal data
+	Omit []string
+}
+
+var exportSections = []exportSection{
+	{Name: "posts", Collection: "schoogpost", Filter: byUserField("author_id")},
+	{Name: "sessions", Collection: "schoogsession", Filter: byUserField("user_id"), Omit: []string{"csrf_token_hash"}},
+	{Name: "api_keys", Collection: "schoogapikey", Filter: byUserField("user_id"), Omit: []string{"hash"}},
+	{Name: "sign_in_links", Collection: "schoogmagiclink", Filter: byUserField("user_id"), Omit: []string{"_id"}},
+	{Name: "identity_provider_links", Collection: "schoogoidcstate", Filter: byUserField("link_user_id"), Omit: []string{"_id", "nonce", "code_verifier"}},
+	{Name: "unlock_links", Collection: "schoogunlocktoken", Filter: func(user *models.User) bson.M {
+		return bson.M{"email": user.Email}
+	}, Omit: []string{"_id"}},
+	{Name: "audit_events", Collection: "schoogaudit", Filter: func(user *models.User) bson.M {
+		return bson.M{"$or": bson.A{
+			bson.M{"actor_id": user.ID.Hex()},
+			bson.M{"target_type": audit.TargetUser, "target_id": user.ID.Hex()},
+		}}
+	}},
+}
+
+// byUserField selects the documents whose field references the user.
+func byUserField(field string) func(user *models.User) bson.M {
+	return func(user *models.User) bson.M {
+		return bson.M{field: user.ID}
+	}
+}
+
+// ExportAccount exports everything stored about the authenticated user.
+// @Summary Export account data
+// @Description Download all data stored about the logged-in user as a JSON document or, with format=zip, a ZIP archive with one JSON file per section.
+// @Tags Profile
+// @Produce json
+// @Produce application/zip
+// @Param format query string false "Archive format" Enums(json, zip)
+// @Success 200 {object} map[string]interface{} "Exported account data"
+// @Failure 400 {object} problem.Problem "Unsupported export format"
+// @Failure 401 {object} problem.Problem "Unauthorized"
+// @Failure 404 {object} problem.Problem "User not found"
+// @Failure 500 {object} problem.Problem "Failed to export account"
+// @Router /profile/export [get]
+func ExportAccount(w http.ResponseWriter, r *http.Request) {
+	r, span := startSpan(r, "ExportAccount")
+	defer span.End()
+
+	userID, ok := authenticatedUserID(r)
+	if !ok {
+		problem.Error(w, r, http.StatusUnauthorized, problem.CodeUnauthorized, "Unauthorized")
+		return
+	}
+
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = "json"
+	}
+	if format != "json" && format != "zip" {
+		problem.Error(w, r, http.StatusBadRequest, problem.CodeValidationFailed, "Unsupported export format")
+		return
+	}
+
+	var user models.User
+	err := database.GetCollection("schooguser").FindOne(r.Context(), bson.M{"_id": userID}).Decode(&user)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			problem.Error(w, r, http.StatusNotFound, problem.CodeNotFound, "User not found")
+		} else {
+			problem.ServerError(w, r, err, "Database error")
+		}
+		return
+	}
+
+	export := map[string]interface{}{
+		"exported_at": time.Now(),
+		"profile":     user,
+	}
+	for _, section := range exportSections {
+		documents, err := exportDocuments(r.Context(), section, &user)
+		if err != nil {
+			problem.ServerError(w, r, err, "Failed to export account")
+			return
+		}
+		export[section.Name] = documents
+	}
+
+	if user.OIDCSubject != "" {
+		export["identity_provider"] = map[string]string{"issuer": user.OIDCIssuer, "subject": user.OIDCSubject}
+	}
+
+	// Failed login and sign-in link attempts about the account and the clients it logged in from
+	ips, err := jobs.SessionIPs(r.Context(), userID)
+	if err != nil {
+		problem.ServerError(w, r, err, "Failed to export account")
+		return
+	}
+	attempts := map[string]lockout.Attempts{}
+	for _, guard := range lockout.Guards() {
+		records, err := guard.Records(r.Context(), user.Email, ips)
+		if err != nil {
+			problem.ServerError(w, r, err, "Failed to export account")
+			return
+		}
+		for key, record := range records {
+			attempts[key] = record
+		}
+	}
+	export["login_attempts"] = attempts
+
+	filename := fmt.Sprintf("account-%s-%s", userID.Hex(), time.Now().Format("20060102"))
+	if format == "json" {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.json"`, filename))
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(export)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.zip"`, filename))
+	w.WriteHeader(http.StatusOK)
+
+	archive := zip.NewWriter(w)
+	for name, data := range export {
+		file, err := archive.Create(name + ".json")
+		if err != nil {
+			return
+		}
+		encoder := json.NewEncoder(file)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(data); err != nil {
+			return
+		}
+	}
+	archive.Close()
+}
+
+func exportDocuments(ctx context.Context, section exportSection, user *models.User) ([]bson.M, error) {
+	opts := options.Find()
+	if len(section.Omit) > 0 {
+		projection := bson.M{}
+		for _, field := range section.Omit {
+			projection[field] = 0
+		}
+		opts.SetProjection(projection)
+	}
+
+	cursor, err := database.GetCollection(section.Collection).Find(ctx, section.Filter(user), opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	documents := []bson.M{}
+	if err := cursor.All(ctx, &documents); err != nil {
+		return nil, err
+	}
+	return documents, nil
+}
+
+// DeleteAccount schedules the authenticated user's account for deletion.
+// @Summary Delete account
+// @Description Schedule the logged-in user's account for deletion. The account and, depending on the server's policy, their posts are removed or anonymised once the grace period ends; until then the request can be cancelled.
+// @Tags Profile
+// @Produce json
+// @Success 202 {object} map[string]interface{} "Account deletion scheduled"
+// @Failure 401 {object} problem.Problem "Unauthorized"
+// @Failure 404 {object} problem.Problem "User not found"
+// @Failure 500 {object} problem.Problem "Failed to schedule account deletion"
+// @Router /profile [delete]
+func DeleteAccount(w http.ResponseWriter, r *http.Request) {
+	r, span := startSpan(r, "DeleteAccount")
+	defer span.End()
+
+	userID, ok := authenticatedUserID(r)
+	if !ok {
+		problem.Error(w, r, http.StatusUnauthorized, problem.CodeUnauthorized, "Unauthorized")
+		return
+	}
+
+	now := time.Now()
+	scheduledAt := now.Add(jobs.DeletionGracePeriod)
+
+	// Keeping the original schedule if deletion was already requested
+	collection := database.GetCollection("schooguser")
+	result, err := collection.UpdateOne(r.Context(),
+		bson.M{"_id": userID, "deletion_scheduled_at": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"deletion_requested_at": now, "deletion_scheduled_at": scheduledAt}},
+	)
+	if err != nil {
+		problem.ServerError(w, r, err, "Failed to schedule account deletion")
+		return
+	}
+
+	var user models.User
+	err = collection.FindOne(r.Context(), bson.M{"_id": userID}).Decode(&user)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			problem.Error(w, r, http.StatusNotFound, problem.CodeNotFound, "User not found")
+		} else {
+			problem.ServerError(w, r, err, "Database error")
+		}
+		return
+	}
+
+	message := "Account deletion scheduled"
+	if result.ModifiedCount == 0 {
+		message = "Account deletion already scheduled"
+	} else {
+		event := audit.FromRequest(r, audit.ActionDeletionRequest).On(audit.TargetUser, userID.Hex())
+		event.After = map[string]interface{}{"deletion_scheduled_at": scheduledAt}
+		audit.Record(r.Context(), event)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message":      message,
+		"scheduled_at": user.DeletionScheduledAt,
+		"post_policy":  jobs.DeletedPostPolicy,
+	})
+}
+
+// CancelAccountDeletion cancels a pending account deletion.
+// @Summary Cancel account deletion
+// @Description Cancel the logged-in user's pending account deletion during the grace period.
+// @Tags Profile
+// @Produce json
+// @Success 200 {string} string "Account deletion cancelled"
+// @Failure 401 {object} problem.Problem "Unauthorized"
+// @Failure 404 {object} problem.Problem "No pending account deletion"
+// @Failure 500 {object} problem.Problem "Failed to cancel account deletion"
+// @Router /profile/delete/cancel [post]
+func CancelAccountDeletion(w http.ResponseWriter, r *http.Request) {
+	r, span := startSpan(r, "CancelAccountDeletion")
+	defer span.End()
+
+	userID, ok := authenticatedUserID(r)
+	if !ok {
+		problem.Error(w, r, http.StatusUnauthorized, problem.CodeUnauthorized, "Unauthorized")
+		return
+	}
+
+	// Accounts already being purged can no longer be restored
+	result, err := database.GetCollection("schooguser").UpdateOne(r.Context(),
+		bson.M{"_id": userID, "deletion_scheduled_at": bson.M{"$exists": true}, "deletion_purging": bson.M{"$ne": true}},
+		bson.M{"$unset": bson.M{"deletion_requested_at": "", "deletion_scheduled_at": ""}},
+	)
+	if err != nil {
+		problem.ServerError(w, r, err, "Failed to cancel account deletion")
+		return
+	}
+	if result.MatchedCount == 0 {
+		problem.Error(w, r, http.StatusNotFound, problem.CodeNotFound, "No pending account deletion")
+		return
+	}
+	audit.Record(r.Context(), audit.FromRequest(r, audit.ActionDeletionCancel).On(audit.TargetUser, userID.Hex()))
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message": "Account deletion cancelled",
+	})
+}
+
+// authenticatedUserID returns the ID of the user the request was authenticated as.
+func authenticatedUserID(r *http.Request) (primitive.ObjectID, bool) {
+	userIDStr, _ := r.Context().Value(middleware.UserIDContextKey).(string)
+	userID, err := primitive.ObjectIDFromHex(userIDStr)
+	if err != nil {
+		return primitive.NilObjectID, false
+	}
+	return userID, true
+}
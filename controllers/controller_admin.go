@@ -0,0 +1,489 @@
+package controller
+
+import (
+	"encoding/json"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Aman913k/audit"
+	"github.com/Aman913k/database"
+	"github.com/Aman913k/jobs"
+	"github.com/Aman913k/middleware"
+	"github.com/Aman913k/models"
+	"github.com/Aman913k/problem"
+	"github.com/Aman913k/validate"
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Paging of admin listings
+const (
+	defaultPageSize = 20
+	maxPageSize     = 100
+	// maxPage keeps the number of skipped documents bounded, and (page-1)*limit from overflowing
+	maxPage = 10000
+)
+
+// activityLimit caps how many sessions and audit events the activity view returns
+const activityLimit = 50
+
+// ListUsers lists and searches users.
+// @Summary List users
+// @Description List users, newest first, optionally searching name and email and filtering by role and status.
+// @Tags Admin
+// @Produce json
+// @Param q query string false "Text to search for in name and email"
+// @Param role query string false "user or admin"
+// @Param status query string false "active, suspended or deletion_scheduled"
+// @Param page query int false "Page number, from 1"
+// @Param limit query int false "Users per page, up to 100"
+// @Success 200 {object} map[string]interface{} "users, total, page and limit"
+// @Failure 400 {object} problem.Problem "Invalid filter"
+// @Failure 401 {object} problem.Problem "Unauthorized"
+// @Failure 403 {object} problem.Problem "Forbidden"
+// @Failure 500 {object} problem.Problem "Failed to fetch users"
+// @Router /admin/users [get]
+func ListUsers(w http.ResponseWriter, r *http.Request) {
+	r, span := startSpan(r, "ListUsers")
+	defer span.End()
+
+	query := r.URL.Query()
+	filter := bson.M{}
+
+	if q := strings.TrimSpace(query.Get("q")); q != "" {
+		pattern := primitive.Regex{Pattern: regexp.QuoteMeta(q), Options: "i"}
+		filter["$or"] = bson.A{bson.M{"name": pattern}, bson.M{"email": pattern}}
+	}
+
+	switch role := query.Get("role"); role {
+	case "":
+	case models.RoleUser:
+		filter["role"] = bson.M{"$in": bson.A{nil, models.RoleUser}}
+	case models.RoleAdmin:
+		filter["role"] = models.RoleAdmin
+	default:
+		problem.Error(w, r, http.StatusBadRequest, problem.CodeValidationFailed, "Unknown role: "+role)
+		return
+	}
+
+	switch status := query.Get("status"); status {
+	case "":
+	case "active":
+		filter["suspended_at"] = bson.M{"$exists": false}
+		filter["deletion_scheduled_at"] = bson.M{"$exists": false}
+	case "suspended":
+		filter["suspended_at"] = bson.M{"$exists": true}
+	case "deletion_scheduled":
+		filter["deletion_scheduled_at"] = bson.M{"$exists": true}
+	default:
+		problem.Error(w, r, http.StatusBadRequest, problem.CodeValidationFailed, "Unknown status: "+status)
+		return
+	}
+
+	page, limit := pagination(query.Get("page"), query.Get("limit"))
+
+	collection := database.GetCollection("schooguser")
+	total, err := collection.CountDocuments(r.Context(), filter)
+	if err != nil {
+		problem.ServerError(w, r, err, "Failed to fetch users")
+		return
+	}
+
+	cursor, err := collection.Find(r.Context(), filter, options.Find().
+		SetSort(bson.D{{Key: "_id", Value: -1}}).
+		SetSkip(int64((page-1)*limit)).
+		SetLimit(int64(limit)),
+	)
+	if err != nil {
+		problem.ServerError(w, r, err, "Failed to fetch users")
+		return
+	}
+	defer cursor.Close(r.Context())
+
+	users := []models.User{}
+	if err := cursor.All(r.Context(), &users); err != nil {
+		problem.ServerError(w, r, err, "Failed to fetch users")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"users": users,
+		"total": total,
+		"page":  page,
+		"limit": limit,
+	})
+}
+
+// GetUserActivity shows a user with their recent activity.
+// @Summary View a user's activity
+// @Description View a user with their recent sessions, post and API key counts, and the audit events they took part in.
+// @Tags Admin
+// @Produce json
+// @Param id path string true "User ID"
+// @Success 200 {object} map[string]interface{} "user, sessions, posts, api_keys and audit"
+// @Failure 400 {object} problem.Problem "Invalid user ID format"
+// @Failure 401 {object} problem.Problem "Unauthorized"
+// @Failure 403 {object} problem.Problem "Forbidden"
+// @Failure 404 {object} problem.Problem "User not found"
+// @Failure 500 {object} problem.Problem "Failed to fetch activity"
+// @Router /admin/users/{id}/activity [get]
+func GetUserActivity(w http.ResponseWriter, r *http.Request) {
+	r, span := startSpan(r, "GetUserActivity")
+	defer span.End()
+
+	user, ok := loadTargetUser(w, r)
+	if !ok {
+		return
+	}
+	sessions := []models.Session{}
+	cursor, err := database.GetCollection("schoogsession").Find(r.Context(),
+		bson.M{"user_id": user.ID},
+		options.Find().SetSort(bson.D{{Key: "last_seen_at", Value: -1}}).SetLimit(activityLimit),
+	)
+	if err == nil {
+		err = cursor.All(r.Context(), &sessions)
+	}
+	if err != nil {
+		problem.ServerError(w, r, err, "Failed to fetch activity")
+		return
+	}
+
+	posts, err := database.GetCollection("schoogpost").CountDocuments(r.Context(), bson.M{"author_id": user.ID})
+	if err != nil {
+		problem.ServerError(w, r, err, "Failed to fetch activity")
+		return
+	}
+
+	apiKeys, err := database.GetCollection("schoogapikey").CountDocuments(r.Context(),
+		bson.M{"user_id": user.ID, "revoked_at": bson.M{"$exists": false}},
+	)
+	if err != nil {
+		problem.ServerError(w, r, err, "Failed to fetch activity")
+		return
+	}
+
+	events := []audit.Event{}
+	cursor, err = database.GetCollection("schoogaudit").Find(r.Context(),
+		bson.M{"$or": bson.A{bson.M{"actor_id": user.ID.Hex()}, bson.M{"target_id": user.ID.Hex()}}},
+		options.Find().SetSort(bson.D{{Key: "time", Value: -1}}).SetLimit(activityLimit),
+	)
+	if err == nil {
+		err = cursor.All(r.Context(), &events)
+	}
+	if err != nil {
+		problem.ServerError(w, r, err, "Failed to fetch activity")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"user":     user,
+		"sessions": sessions,
+		"posts":    posts,
+		"api_keys": apiKeys,
+		"audit":    events,
+	})
+}
+
+// SuspendUser suspends a user.
+// @Summary Suspend a user
+// @Description Suspend a user, logging them out everywhere. Suspended users can't log in and their API keys stop working until they are unsuspended.
+// @Tags Admin
+// @Accept json
+// @Produce json
+// @Param id path string true "User ID"
+// @Param request body models.SuspendUserRequest false "Reason for the suspension"
+// @Success 200 {string} string "User suspended"
+// @Failure 400 {object} problem.Problem "Invalid user ID format, or administrators can't suspend themselves"
+// @Failure 401 {object} problem.Problem "Unauthorized"
+// @Failure 403 {object} problem.Problem "Forbidden"
+// @Failure 404 {object} problem.Problem "User not found"
+// @Failure 409 {object} problem.Problem "User is already suspended"
+// @Failure 500 {object} problem.Problem "Failed to suspend user"
+// @Router /admin/users/{id}/suspend [post]
+func SuspendUser(w http.ResponseWriter, r *http.Request) {
+	r, span := startSpan(r, "SuspendUser")
+	defer span.End()
+
+	user, ok := loadTargetUser(w, r)
+	if !ok || refuseSelf(w, r, user, "Administrators can't suspend themselves") {
+		return
+	}
+
+	var request models.SuspendUserRequest
+	if r.ContentLength != 0 {
+		if !validate.DecodeJSON(w, r, &request) {
+			return
+		}
+	}
+
+	update := bson.M{"suspended_at": time.Now()}
+	if reason := strings.TrimSpace(request.Reason); reason != "" {
+		update["suspension_reason"] = reason
+	}
+	result, err := database.GetCollection("schooguser").UpdateOne(r.Context(),
+		bson.M{"_id": user.ID, "suspended_at": bson.M{"$exists": false}},
+		bson.M{"$set": update},
+	)
+	if err != nil {
+		problem.ServerError(w, r, err, "Failed to suspend user")
+		return
+	}
+	if result.MatchedCount == 0 {
+		problem.Error(w, r, http.StatusConflict, problem.CodeConflict, "User is already suspended")
+		return
+	}
+
+	if err := revokeSessions(r.Context(), user.ID, ""); err != nil {
+		middleware.Logger(r.Context()).Error("Failed to revoke sessions of suspended user", "error", err)
+	}
+
+	recordAdminAction(r, audit.ActionUserSuspend, user, nil, map[string]interface{}{"suspension_reason": request.Reason})
+	writeAdminMessage(w, "User suspended")
+}
+
+// UnsuspendUser lifts a user's suspension.
+// @Summary Unsuspend a user
+// @Description Lift a user's suspension so they can log in again.
+// @Tags Admin
+// @Produce json
+// @Param id path string true "User ID"
+// @Success 200 {string} string "User unsuspended"
+// @Failure 400 {object} problem.Problem "Invalid user ID format"
+// @Failure 401 {object} problem.Problem "Unauthorized"
+// @Failure 403 {object} problem.Problem "Forbidden"
+// @Failure 404 {object} problem.Problem "User not found"
+// @Failure 409 {object} problem.Problem "User is not suspended"
+// @Failure 500 {object} problem.Problem "Failed to unsuspend user"
+// @Router /admin/users/{id}/unsuspend [post]
+func UnsuspendUser(w http.ResponseWriter, r *http.Request) {
+	r, span := startSpan(r, "UnsuspendUser")
+	defer span.End()
+
+	user, ok := loadTargetUser(w, r)
+	if !ok {
+		return
+	}
+
+	result, err := database.GetCollection("schooguser").UpdateOne(r.Context(),
+		bson.M{"_id": user.ID, "suspended_at": bson.M{"$exists": true}},
+		bson.M{"$unset": bson.M{"suspended_at": "", "suspension_reason": ""}},
+	)
+	if err != nil {
+		problem.ServerError(w, r, err, "Failed to unsuspend user")
+		return
+	}
+	if result.MatchedCount == 0 {
+		problem.Error(w, r, http.StatusConflict, problem.CodeConflict, "User is not suspended")
+		return
+	}
+
+	recordAdminAction(r, audit.ActionUserUnsuspend, user, map[string]interface{}{"suspended_at": user.SuspendedAt, "suspension_reason": user.SuspensionReason}, nil)
+	writeAdminMessage(w, "User unsuspended")
+}
+
+// ForcePasswordReset makes a user choose a new password.
+// @Summary Force a password reset
+// @Description Log a user out everywhere and block password logins until they set a new password through the link emailed to them.
+// @Tags Admin
+// @Produce json
+// @Param id path string true "User ID"
+// @Success 200 {string} string "Password reset required"
+// @Failure 400 {object} problem.Problem "Invalid user ID format"
+// @Failure 401 {object} problem.Problem "Unauthorized"
+// @Failure 403 {object} problem.Problem "Forbidden"
+// @Failure 404 {object} problem.Problem "User not found"
+// @Failure 500 {object} problem.Problem "Failed to force password reset"
+// @Router /admin/users/{id}/force-password-reset [post]
+func ForcePasswordReset(w http.ResponseWriter, r *http.Request) {
+	r, span := startSpan(r, "ForcePasswordReset")
+	defer span.End()
+
+	user, ok := loadTargetUser(w, r)
+	if !ok {
+		return
+	}
+
+	_, err := database.GetCollection("schooguser").UpdateOne(r.Context(),
+		bson.M{"_id": user.ID},
+		bson.M{"$set": bson.M{"password_reset_required": true}},
+	)
+	if err != nil {
+		problem.ServerError(w, r, err, "Failed to force password reset")
+		return
+	}
+
+	if err := revokeSessions(r.Context(), user.ID, ""); err != nil {
+		middleware.Logger(r.Context()).Error("Failed to revoke sessions after forcing a password reset", "error", err)
+	}
+	if err := sendPasswordResetEmail(r.Context(), user); err != nil {
+		middleware.Logger(r.Context()).Error("Failed to send password reset email", "error", err)
+	}
+
+	recordAdminAction(r, audit.ActionUserForceReset, user, nil, map[string]interface{}{"password_reset_required": true})
+	writeAdminMessage(w, "Password reset required")
+}
+
+// ChangeUserRole changes a user's role.
+// @Summary Change a user's role
+// @Description Make a user an administrator or a regular user.
+// @Tags Admin
+// @Accept json
+// @Produce json
+// @Param id path string true "User ID"
+// @Param request body models.ChangeRoleRequest true "New role: user or admin"
+// @Success 200 {string} string "Role changed"
+// @Failure 400 {object} problem.Problem "Invalid input or unknown role, or administrators can't change their own role"
+// @Failure 401 {object} problem.Problem "Unauthorized"
+// @Failure 403 {object} problem.Problem "Forbidden"
+// @Failure 404 {object} problem.Problem "User not found"
+// @Failure 500 {object} problem.Problem "Failed to change role"
+// @Router /admin/users/{id}/role [put]
+func ChangeUserRole(w http.ResponseWriter, r *http.Request) {
+	r, span := startSpan(r, "ChangeUserRole")
+	defer span.End()
+
+	user, ok := loadTargetUser(w, r)
+	if !ok || refuseSelf(w, r, user, "Administrators can't change their own role") {
+		return
+	}
+
+	var request models.ChangeRoleRequest
+	if !validate.DecodeJSON(w, r, &request) {
+		return
+	}
+
+	// Regular users have no role stored
+	update := bson.M{"$set": bson.M{"role": models.RoleAdmin}}
+	if request.Role == models.RoleUser {
+		update = bson.M{"$unset": bson.M{"role": ""}}
+	}
+
+	_, err := database.GetCollection("schooguser").UpdateOne(r.Context(), bson.M{"_id": user.ID}, update)
+	if err != nil {
+		problem.ServerError(w, r, err, "Failed to change role")
+		return
+	}
+
+	previous := user.Role
+	if previous == "" {
+		previous = models.RoleUser
+	}
+	recordAdminAction(r, audit.ActionUserRoleChange, user, map[string]interface{}{"role": previous}, map[string]interface{}{"role": request.Role})
+	writeAdminMessage(w, "Role changed")
+}
+
+// DeleteUser deletes a user straight away.
+// @Summary Delete a user
+// @Description Delete a user immediately, without the grace period of self-service deletion. Their posts are handled by the configured deletion post policy.
+// @Tags Admin
+// @Produce json
+// @Param id path string true "User ID"
+// @Success 200 {string} string "User deleted"
+// @Failure 400 {object} problem.Problem "Invalid user ID format, or administrators can't delete themselves"
+// @Failure 401 {object} problem.Problem "Unauthorized"
+// @Failure 403 {object} problem.Problem "Forbidden"
+// @Failure 404 {object} problem.Problem "User not found"
+// @Failure 500 {object} problem.Problem "Failed to delete user"
+// @Router /admin/users/{id} [delete]
+func DeleteUser(w http.ResponseWriter, r *http.Request) {
+	r, span := startSpan(r, "DeleteUser")
+	defer span.End()
+
+	user, ok := loadTargetUser(w, r)
+	if !ok || refuseSelf(w, r, user, "Administrators can't delete themselves") {
+		return
+	}
+
+	// Scheduling the deletion for now, so the purge treats it like any due deletion
+	now := time.Now()
+	_, err := database.GetCollection("schooguser").UpdateOne(r.Context(),
+		bson.M{"_id": user.ID},
+		bson.M{"$set": bson.M{"deletion_requested_at": now, "deletion_scheduled_at": now}},
+	)
+	if err != nil {
+		problem.ServerError(w, r, err, "Failed to delete user")
+		return
+	}
+	if err := jobs.PurgeAccount(r.Context(), user.ID); err != nil {
+		problem.ServerError(w, r, err, "Failed to delete user")
+		return
+	}
+
+	recordAdminAction(r, audit.ActionUserDelete, user, map[string]interface{}{"email": user.Email, "name": user.Name}, nil)
+	writeAdminMessage(w, "User deleted")
+}
+
+// loadTargetUser loads the user named by the id path variable of an admin request.
+func loadTargetUser(w http.ResponseWriter, r *http.Request) (*models.User, bool) {
+	userID, err := primitive.ObjectIDFromHex(mux.Vars(r)["id"])
+	if err != nil {
+		problem.Error(w, r, http.StatusBadRequest, problem.CodeInvalidRequest, "Invalid user ID format")
+		return nil, false
+	}
+
+	var user models.User
+	err = database.GetCollection("schooguser").FindOne(r.Context(), bson.M{"_id": userID}).Decode(&user)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			problem.Error(w, r, http.StatusNotFound, problem.CodeNotFound, "User not found")
+		} else {
+			problem.ServerError(w, r, err, "Database error")
+		}
+		return nil, false
+	}
+	return &user, true
+}
+
+// refuseSelf rejects admin actions an administrator takes on their own account, which could
+// leave nobody able to administer the service. It reports whether the request was refused.
+func refuseSelf(w http.ResponseWriter, r *http.Request, user *models.User, message string) bool {
+	if actorID, _ := authenticatedUserID(r); actorID == user.ID {
+		problem.Error(w, r, http.StatusBadRequest, problem.CodeInvalidRequest, message)
+		return true
+	}
+	return false
+}
+
+// recordAdminAction records an administrator's action on a user in the audit log.
+func recordAdminAction(r *http.Request, action string, target *models.User, before, after map[string]interface{}) {
+	event := audit.FromRequest(r, action).On(audit.TargetUser, target.ID.Hex())
+	event.Before = before
+	event.After = after
+	audit.Record(r.Context(), event)
+}
+
+func writeAdminMessage(w http.ResponseWriter, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message": message,
+	})
+}
+
+// pagination parses page and limit query parameters, falling back to the first page of
+// defaultPageSize and capping the page at maxPage and the limit at maxPageSize.
+func pagination(pageParam, limitParam string) (page, limit int) {
+	page, err := strconv.Atoi(pageParam)
+	if err != nil || page < 1 {
+		page = 1
+	}
+	if page > maxPage {
+		page = maxPage
+	}
+	limit, err = strconv.Atoi(limitParam)
+	if err != nil || limit < 1 {
+		limit = defaultPageSize
+	}
+	if limit > maxPageSize {
+		limit = maxPageSize
+	}
+	return page, limit
+}
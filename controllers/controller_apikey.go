@@ -0,0 +1,187 @@
+package controller
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/Aman913k/audit"
+	"github.com/Aman913k/database"
+	"github.com/Aman913k/models"
+	"github.com/Aman913k/problem"
+	"github.com/Aman913k/utils"
+	"github.com/Aman913k/validate"
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// maxActiveAPIKeys caps how many unrevoked keys a user can hold
+const maxActiveAPIKeys = 20
+
+// CreateAPIKey creates a personal API key.
+// @Summary Create an API key
+// @Description Create a personal API key limited to the given scopes. The key is only returned in this response; send it as "Authorization: Bearer <key>".
+// @Tags API Keys
+// @Accept json
+// @Produce json
+// @Param key body models.CreateAPIKeyRequest true "Key name and scopes"
+// @Success 201 {object} map[string]interface{} "The key and its details"
+// @Failure 400 {object} problem.Problem "Invalid input or unknown scope"
+// @Failure 401 {object} problem.Problem "Unauthorized"
+// @Failure 403 {object} problem.Problem "API keys cannot be used here"
+// @Failure 409 {object} problem.Problem "Too many active API keys"
+// @Failure 500 {object} problem.Problem "Failed to create API key"
+// @Router /profile/api-keys [post]
+func CreateAPIKey(w http.ResponseWriter, r *http.Request) {
+	r, span := startSpan(r, "CreateAPIKey")
+	defer span.End()
+
+	userID, ok := authenticatedUserID(r)
+	if !ok {
+		problem.Error(w, r, http.StatusUnauthorized, problem.CodeUnauthorized, "Unauthorized")
+		return
+	}
+
+	var request models.CreateAPIKeyRequest
+	if !validate.DecodeJSON(w, r, &request) {
+		return
+	}
+
+	request.Name = strings.TrimSpace(request.Name)
+
+	collection := database.GetCollection("schoogapikey")
+	active, err := collection.CountDocuments(r.Context(), bson.M{"user_id": userID, "revoked_at": bson.M{"$exists": false}})
+	if err != nil {
+		problem.ServerError(w, r, err, "Database error")
+		return
+	}
+	if active >= maxActiveAPIKeys {
+		problem.Error(w, r, http.StatusConflict, problem.CodeConflict, "Too many active API keys")
+		return
+	}
+
+	key, prefix, err := utils.GenerateAPIKey()
+	if err != nil {
+		problem.ServerError(w, r, err, "Failed to create API key")
+		return
+	}
+
+	apiKey := models.APIKey{
+		UserID:    userID,
+		Name:      request.Name,
+		Prefix:    prefix,
+		Hash:      utils.HashAPIKey(key),
+		Scopes:    request.Scopes,
+		CreatedAt: time.Now(),
+	}
+	result, err := collection.InsertOne(r.Context(), apiKey)
+	if err != nil {
+		problem.ServerError(w, r, err, "Failed to create API key")
+		return
+	}
+	apiKey.ID = result.InsertedID.(primitive.ObjectID)
+
+	event := audit.FromRequest(r, audit.ActionAPIKeyCreate).On(audit.TargetAPIKey, apiKey.ID.Hex())
+	event.After = map[string]interface{}{"name": apiKey.Name, "prefix": apiKey.Prefix, "scopes": apiKey.Scopes}
+	audit.Record(r.Context(), event)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"key":     key,
+		"api_key": apiKey,
+	})
+}
+
+// ListAPIKeys lists the user's API keys.
+// @Summary List API keys
+// @Description List the logged-in user's API keys, including revoked ones, newest first.
+// @Tags API Keys
+// @Produce json
+// @Success 200 {array} models.APIKey "API keys"
+// @Failure 401 {object} problem.Problem "Unauthorized"
+// @Failure 403 {object} problem.Problem "API keys cannot be used here"
+// @Failure 500 {object} problem.Problem "Failed to fetch API keys"
+// @Router /profile/api-keys [get]
+func ListAPIKeys(w http.ResponseWriter, r *http.Request) {
+	r, span := startSpan(r, "ListAPIKeys")
+	defer span.End()
+
+	userID, ok := authenticatedUserID(r)
+	if !ok {
+		problem.Error(w, r, http.StatusUnauthorized, problem.CodeUnauthorized, "Unauthorized")
+		return
+	}
+
+	cursor, err := database.GetCollection("schoogapikey").Find(r.Context(),
+		bson.M{"user_id": userID},
+		options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}}),
+	)
+	if err != nil {
+		problem.ServerError(w, r, err, "Failed to fetch API keys")
+		return
+	}
+	defer cursor.Close(r.Context())
+
+	keys := []models.APIKey{}
+	if err := cursor.All(r.Context(), &keys); err != nil {
+		problem.ServerError(w, r, err, "Failed to fetch API keys")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(keys)
+}
+
+// RevokeAPIKey revokes one of the user's API keys.
+// @Summary Revoke an API key
+// @Description Revoke an API key so it can no longer be used.
+// @Tags API Keys
+// @Produce json
+// @Param key_id path string true "API key ID"
+// @Success 200 {string} string "API key revoked"
+// @Failure 400 {object} problem.Problem "Invalid API key ID format"
+// @Failure 401 {object} problem.Problem "Unauthorized"
+// @Failure 403 {object} problem.Problem "API keys cannot be used here"
+// @Failure 404 {object} problem.Problem "API key not found"
+// @Failure 500 {object} problem.Problem "Failed to revoke API key"
+// @Router /profile/api-keys/{key_id} [delete]
+func RevokeAPIKey(w http.ResponseWriter, r *http.Request) {
+	r, span := startSpan(r, "RevokeAPIKey")
+	defer span.End()
+
+	userID, ok := authenticatedUserID(r)
+	if !ok {
+		problem.Error(w, r, http.StatusUnauthorized, problem.CodeUnauthorized, "Unauthorized")
+		return
+	}
+
+	keyID, err := primitive.ObjectIDFromHex(mux.Vars(r)["key_id"])
+	if err != nil {
+		problem.Error(w, r, http.StatusBadRequest, problem.CodeInvalidRequest, "Invalid API key ID format")
+		return
+	}
+
+	result, err := database.GetCollection("schoogapikey").UpdateOne(r.Context(),
+		bson.M{"_id": keyID, "user_id": userID, "revoked_at": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"revoked_at": time.Now()}},
+	)
+	if err != nil {
+		problem.ServerError(w, r, err, "Failed to revoke API key")
+		return
+	}
+	if result.MatchedCount == 0 {
+		problem.Error(w, r, http.StatusNotFound, problem.CodeNotFound, "API key not found")
+		return
+	}
+	audit.Record(r.Context(), audit.FromRequest(r, audit.ActionAPIKeyRevoke).On(audit.TargetAPIKey, keyID.Hex()))
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message": "API key revoked",
+	})
+}
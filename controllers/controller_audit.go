@@ -0,0 +1,103 @@
+package controller
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/Aman913k/audit"
+	"github.com/Aman913k/database"
+	"github.com/Aman913k/problem"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// auditFilterParams maps the exact-match query parameters of the audit query to event fields
+var auditFilterParams = []string{"action", "actor_id", "actor_email", "target_type", "target_id", "ip", "request_id"}
+
+// ListAuditEvents queries the audit log.
+// @Summary Query the audit log
+// @Description List audit events, newest first, filtered by any combination of action, actor, target, IP, request ID and time range.
+// @Tags Admin
+// @Produce json
+// @Param action query string false "Action, such as auth.login_failed or post.update"
+// @Param actor_id query string false "ID of the user who acted, or cli"
+// @Param actor_email query string false "Email of the user who acted"
+// @Param target_type query string false "user, post or api_key"
+// @Param target_id query string false "ID of the target"
+// @Param ip query string false "Client IP"
+// @Param request_id query string false "Request ID"
+// @Param from query string false "Earliest time, RFC 3339"
+// @Param to query string false "Latest time, RFC 3339"
+// @Param page query int false "Page number, from 1"
+// @Param limit query int false "Events per page, up to 100"
+// @Success 200 {object} map[string]interface{} "events, total, page and limit"
+// @Failure 400 {object} problem.Problem "Invalid filter"
+// @Failure 401 {object} problem.Problem "Unauthorized"
+// @Failure 403 {object} problem.Problem "Forbidden"
+// @Failure 500 {object} problem.Problem "Failed to fetch audit events"
+// @Router /admin/audit [get]
+func ListAuditEvents(w http.ResponseWriter, r *http.Request) {
+	r, span := startSpan(r, "ListAuditEvents")
+	defer span.End()
+
+	query := r.URL.Query()
+	filter := bson.M{}
+
+	for _, param := range auditFilterParams {
+		if value := query.Get(param); value != "" {
+			filter[param] = value
+		}
+	}
+
+	timeRange := bson.M{}
+	for param, operator := range map[string]string{"from": "$gte", "to": "$lte"} {
+		value := query.Get(param)
+		if value == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			problem.Error(w, r, http.StatusBadRequest, problem.CodeValidationFailed, "Invalid "+param+" time, expected RFC 3339")
+			return
+		}
+		timeRange[operator] = t
+	}
+	if len(timeRange) > 0 {
+		filter["time"] = timeRange
+	}
+
+	page, limit := pagination(query.Get("page"), query.Get("limit"))
+
+	collection := database.GetCollection("schoogaudit")
+	total, err := collection.CountDocuments(r.Context(), filter)
+	if err != nil {
+		problem.ServerError(w, r, err, "Failed to fetch audit events")
+		return
+	}
+
+	cursor, err := collection.Find(r.Context(), filter, options.Find().
+		SetSort(bson.D{{Key: "time", Value: -1}}).
+		SetSkip(int64((page-1)*limit)).
+		SetLimit(int64(limit)),
+	)
+	if err != nil {
+		problem.ServerError(w, r, err, "Failed to fetch audit events")
+		return
+	}
+	defer cursor.Close(r.Context())
+
+	events := []audit.Event{}
+	if err := cursor.All(r.Context(), &events); err != nil {
+		problem.ServerError(w, r, err, "Failed to fetch audit events")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"events": events,
+		"total":  total,
+		"page":   page,
+		"limit":  limit,
+	})
+}
@@ -3,21 +3,29 @@ package controller
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"net/http"
+	"net/url"
+	"strings"
 
+	"github.com/Aman913k/audit"
 	"github.com/Aman913k/database"
+	"github.com/Aman913k/jobs"
+	"github.com/Aman913k/lockout"
+	"github.com/Aman913k/metrics"
 	"github.com/Aman913k/middleware"
 	"github.com/Aman913k/models"
+	"github.com/Aman913k/problem"
 	"github.com/Aman913k/utils"
+	"github.com/Aman913k/validate"
 	"github.com/gorilla/mux"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
-	"golang.org/x/crypto/bcrypt"
 )
 
 // EncryptUserPassword hashes the user's password and inserts the user record into the MongoDB database.
-func EncryptUserPassword(user *models.User) (*mongo.InsertOneResult, error) {
+func EncryptUserPassword(ctx context.Context, user *models.User) (*mongo.InsertOneResult, error) {
 	hashedPassword, err := models.HashPassword(user.Password)
 	if err != nil {
 		return nil, err
@@ -26,7 +34,13 @@ func EncryptUserPassword(user *models.User) (*mongo.InsertOneResult, error) {
 	const dbName = "schooguser"
 	collection := database.GetCollection(dbName)
 	user.Password = hashedPassword
-	return collection.InsertOne(context.TODO(), user)
+	result, err := collection.InsertOne(ctx, user)
+	if err != nil {
+		return nil, err
+	}
+
+	user.ID = result.InsertedID.(primitive.ObjectID)
+	return result, nil
 }
 
 // Register registers a new user.
@@ -35,96 +49,283 @@ func EncryptUserPassword(user *models.User) (*mongo.InsertOneResult, error) {
 // @Tags Auth
 // @Accept json
 // @Produce json
-// @Param user body models.User true "User details"
-// @Success 200 {object} map[string]interface{}
-// @Failure 400 {string} string "Email already in use"
-// @Failure 500 {string} string "Internal Server Error"
+// @Param user body models.RegisterRequest true "User details"
+// @Success 200 {object} map[string]interface{} "User ID of the registered user"
+// @Failure 400 {object} problem.Problem "Email already in use or rejected by the email policy, or password is weak"
+// @Failure 429 {object} problem.Problem "Too many requests"
+// @Failure 500 {object} problem.Problem "Internal Server Error"
 // @Router /register [post]
 func Register(w http.ResponseWriter, r *http.Request) {
-	var user models.User
-	err := json.NewDecoder(r.Body).Decode(&user)
-	if err != nil {
-		http.Error(w, "Invalid input", http.StatusBadRequest)
+	r, span := startSpan(r, "Register")
+	defer span.End()
+
+	var request models.RegisterRequest
+	if !validate.DecodeJSON(w, r, &request) {
 		return
 	}
 
+	// Only accepting the fields a user may choose, IDs are always assigned by the database
+	user := models.User{
+		Name:     strings.TrimSpace(request.Name),
+		Email:    utils.NormalizeEmail(request.Email),
+		Password: request.Password,
+	}
+
 	const colName = "schooguser"
 	collection := database.GetCollection(colName)
 
 	// Check if email is already in use
-	err = collection.FindOne(context.TODO(), bson.M{"email": user.Email}).Decode(&user)
+	err := collection.FindOne(r.Context(), bson.M{"email": user.Email}).Decode(&models.User{})
 	if err == nil {
-		http.Error(w, "Email already in use", http.StatusBadRequest)
+		problem.Error(w, r, http.StatusBadRequest, problem.CodeEmailInUse, "Email already in use")
 		return
 	} else if err != mongo.ErrNoDocuments {
-		http.Error(w, "Server error", http.StatusInternalServerError)
+		problem.ServerError(w, r, err, "Server error")
 		return
 	}
 
 	// Validate email and password
-	if !utils.IsValidGmail(user.Email) {
-		http.Error(w, "Invalid email address", http.StatusBadRequest)
+	err = utils.RegistrationEmailPolicy.Validate(r.Context(), user.Email)
+	if err != nil {
+		var policyErr *utils.EmailPolicyError
+		if errors.As(err, &policyErr) {
+			problem.Invalid(w, r, "Email address is not allowed", problem.FieldError{
+				Field: "email", Code: "invalid_email", Message: policyErr.Reason,
+			})
+		} else {
+			problem.ServerError(w, r, err, "Failed to verify email address")
+		}
 		return
 	}
-	if !utils.StrongPassword(user.Password) {
-		http.Error(w, "Password is weak", http.StatusForbidden)
+	if violations := utils.DefaultPasswordPolicy.Check(user.Password, user.Name, user.Email); len(violations) > 0 {
+		writeWeakPassword(w, r, "password", violations)
+		return
 	}
 
 	// Register user by hashing password and saving to the database
-	result, err := EncryptUserPassword(&user)
+	_, err = EncryptUserPassword(r.Context(), &user)
 	if err != nil {
-		http.Error(w, "Failed to register user", http.StatusInternalServerError)
+		// The unique email index catches registrations racing past the check above
+		if mongo.IsDuplicateKeyError(err) {
+			problem.Error(w, r, http.StatusBadRequest, problem.CodeEmailInUse, "Email already in use")
+			return
+		}
+		problem.ServerError(w, r, err, "Failed to register user")
 		return
 	}
 
+	metrics.Registrations.Inc()
+
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(result)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message": "User registered successfully",
+		"id":      user.ID,
+	})
 }
 
 // Login authenticates a user and generates a JWT token.
 // @Summary Login user
-// @Description Login with email and password
+// @Description Login with email and password. Users with two-factor authentication get an MFA challenge token instead, to be completed at /login/mfa. With a cookie session, the token is set as an HttpOnly cookie and the response carries the CSRF token instead.
 // @Tags Auth
 // @Accept json
 // @Produce json
-// @Param user body models.User true "Login details"
-// @Success 200 {object} map[string]string "token"
-// @Failure 400 {string} string "Invalid input"
-// @Failure 401 {string} string "Invalid email or password"
+// @Param user body models.LoginRequest true "Login details"
+// @Param X-Session-Mode header string false "cookie to log in with a cookie session"
+// @Success 200 {object} map[string]interface{} "token and user ID, or mfa_required and mfa_token"
+// @Failure 400 {object} problem.Problem "Invalid input"
+// @Failure 401 {object} problem.Problem "Invalid email or password"
+// @Failure 403 {object} problem.Problem "Account suspended or password reset required"
+// @Failure 423 {object} problem.Problem "Account temporarily locked"
+// @Failure 429 {object} problem.Problem "Too many failed login attempts"
 // @Router /login [post]
 func Login(w http.ResponseWriter, r *http.Request) {
-	var user models.User
-	err := json.NewDecoder(r.Body).Decode(&user)
-	if err != nil {
-		http.Error(w, "Invalid input", http.StatusBadRequest)
+	r, span := startSpan(r, "Login")
+	defer span.End()
+
+	var request models.LoginRequest
+	if !validate.DecodeJSON(w, r, &request) {
+		return
+	}
+
+	email := utils.NormalizeEmail(request.Email)
+	ip := utils.ClientIP(r)
+
+	// Refusing attempts while the account or client is backing off after failures
+	if !checkLoginAllowed(w, r, email, ip) {
 		return
 	}
 
 	const colName = "schooguser"
 	collection := database.GetCollection(colName)
 	var foundUser models.User
-	err = collection.FindOne(context.TODO(), bson.M{"email": user.Email}).Decode(&foundUser)
+	err := collection.FindOne(r.Context(), bson.M{"email": email}).Decode(&foundUser)
+	if err != nil && err != mongo.ErrNoDocuments {
+		problem.ServerError(w, r, err, "Server error")
+		return
+	}
+
+	// Comparing against a dummy hash for unknown emails so both cases take as long
+	hash := foundUser.Password
+	if err == mongo.ErrNoDocuments {
+		hash = dummyPasswordHash()
+	}
+	match, needsRehash, verifyErr := utils.Passwords.Verify(hash, request.Password)
+	if verifyErr != nil && hash != "" {
+		middleware.Logger(r.Context()).Error("Failed to verify password hash", "error", verifyErr)
+	}
+	if !match || err == mongo.ErrNoDocuments {
+		recordLoginFailure(r, email, err == nil, "invalid_password")
+		problem.Error(w, r, http.StatusUnauthorized, problem.CodeInvalidCredentials, "Invalid email or password")
+		return
+	}
+
+	// Refusing password logins after an administrator forced a reset, the password may be compromised
+	if foundUser.PasswordResetRequired {
+		writePasswordResetRequired(w, r)
+		return
+	}
+
+	// Upgrading hashes made with an older algorithm, parameters or pepper while the password is at hand
+	if needsRehash {
+		rehashPassword(r.Context(), &foundUser, request.Password)
+	}
+
+	beginLogin(w, r, &foundUser)
+}
+
+// rehashPassword replaces the user's password hash with one made by the current hasher.
+// The update only applies if the hash hasn't changed since it was verified.
+func rehashPassword(ctx context.Context, user *models.User, password string) {
+	hashedPassword, err := models.HashPassword(password)
+	if err != nil {
+		middleware.Logger(ctx).Error("Failed to rehash password", "error", err)
+		return
+	}
+
+	_, err = database.GetCollection("schooguser").UpdateOne(ctx,
+		bson.M{"_id": user.ID, "password": user.Password},
+		bson.M{"$set": bson.M{"password": hashedPassword}},
+	)
+	if err != nil {
+		middleware.Logger(ctx).Error("Failed to store rehashed password", "error", err)
+		return
+	}
+	user.Password = hashedPassword
+}
+
+// beginLogin continues a login once the user's first factor has been verified. Users with
+// two-factor authentication get an MFA challenge to complete through VerifyMFALogin,
+// everyone else is logged in straight away.
+func beginLogin(w http.ResponseWriter, r *http.Request, user *models.User) {
+	if user.SuspendedAt != nil {
+		problem.Error(w, r, http.StatusForbidden, problem.CodeAccountSuspended, "Account suspended")
+		return
+	}
+	if user.PasswordResetRequired {
+		writePasswordResetRequired(w, r)
+		return
+	}
+	if !user.TOTPEnabled {
+		completeLogin(w, r, user)
+		return
+	}
+
+	mfaToken, err := utils.GenerateActionToken(mfaPurpose, user.Email, mfaChallengeTTL)
 	if err != nil {
-		http.Error(w, "Invalid email or password", http.StatusUnauthorized)
+		problem.ServerError(w, r, err, "Failed to generate token")
+		return
+	}
+
+	// Handing the challenge to the frontend in the fragment, which browsers don't send to servers
+	if isBrowserLogin(r) {
+		http.Redirect(w, r, loginRedirectURL()+"#mfa_token="+url.QueryEscape(mfaToken), http.StatusSeeOther)
 		return
 	}
 
-	err = bcrypt.CompareHashAndPassword([]byte(foundUser.Password), []byte(user.Password))
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"mfa_required": true,
+		"mfa_token":    mfaToken,
+	})
+}
+
+// completeLogin issues the access token for a fully authenticated user.
+func completeLogin(w http.ResponseWriter, r *http.Request, user *models.User) {
+	// Checking again for logins that went through an MFA challenge while the account was suspended
+	if user.SuspendedAt != nil {
+		problem.Error(w, r, http.StatusForbidden, problem.CodeAccountSuspended, "Account suspended")
+		return
+	}
+	// A forced reset blocks every kind of login, not only passwords, until the account is secured again
+	if user.PasswordResetRequired {
+		writePasswordResetRequired(w, r)
+		return
+	}
+
+	if err := lockout.LoginGuard.Succeed(r.Context(), user.Email); err != nil {
+		middleware.Logger(r.Context()).Error("Failed to reset login failures", "error", err)
+	}
+
+	// Cookie sessions get a random CSRF token, stored hashed with the session
+	cookieSession := middleware.WantsCookieSession(r) || r.Context().Value(cookieSessionContextKey) == true || isBrowserLogin(r)
+	var csrfToken, csrfTokenHash string
+	if cookieSession {
+		var err error
+		csrfToken, csrfTokenHash, err = utils.GenerateCSRFToken()
+		if err != nil {
+			problem.ServerError(w, r, err, "Failed to create session")
+			return
+		}
+	}
+
+	session, err := createSession(r, user, csrfTokenHash)
 	if err != nil {
-		http.Error(w, "Invalid email or password", http.StatusUnauthorized)
+		problem.ServerError(w, r, err, "Failed to create session")
 		return
 	}
 
-	token, err := utils.GenerateJWT(foundUser.Email, foundUser.Name)
+	token, err := utils.GenerateJWT(user.ID.Hex(), user.Email, user.Name, session.ID.Hex())
 	if err != nil {
-		http.Error(w, "Failed to generate token", http.StatusInternalServerError)
+		problem.ServerError(w, r, err, "Failed to generate token")
+		return
+	}
+
+	metrics.Logins.Inc()
+	event := audit.FromRequest(r, audit.ActionLogin).On(audit.TargetUser, user.ID.Hex())
+	event.ActorID, event.ActorEmail = user.ID.Hex(), user.Email
+	event.Details = map[string]interface{}{"session_id": session.ID.Hex(), "user_agent": session.UserAgent}
+	audit.Record(r.Context(), event)
+
+	// Keeping the token away from JavaScript for browser clients that asked for a cookie session
+	if cookieSession {
+		middleware.SetSessionCookies(w, token, csrfToken, utils.AccessTokenTTL)
+		if isBrowserLogin(r) {
+			http.Redirect(w, r, loginRedirectURL(), http.StatusSeeOther)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(map[string]string{
+			"id":         user.ID.Hex(),
+			"csrf_token": csrfToken,
+		})
 		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(map[string]string{"token": token})
+	json.NewEncoder(w).Encode(map[string]string{
+		"token": token,
+		"id":    user.ID.Hex(),
+	})
+}
+
+// writePasswordResetRequired refuses a login to an account whose password an administrator
+// has forced to be reset.
+func writePasswordResetRequired(w http.ResponseWriter, r *http.Request) {
+	problem.Error(w, r, http.StatusForbidden, problem.CodePasswordResetRequired, "Password reset required, check your email for the reset link")
 }
 
 // ViewProfile retrieves and displays the profile of the authenticated user.
@@ -133,13 +334,16 @@ func Login(w http.ResponseWriter, r *http.Request) {
 // @Tags Profile
 // @Produce json
 // @Success 200 {object} models.User
-// @Failure 401 {string} string "Unauthorized"
-// @Failure 404 {string} string "User not found"
+// @Failure 401 {object} problem.Problem "Unauthorized"
+// @Failure 404 {object} problem.Problem "User not found"
 // @Router /profile/view [get]
 func ViewProfile(w http.ResponseWriter, r *http.Request) {
+	r, span := startSpan(r, "ViewProfile")
+	defer span.End()
+
 	userEmail, ok := r.Context().Value(middleware.EmailContextKey).(string)
 	if !ok || userEmail == "" {
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		problem.Error(w, r, http.StatusUnauthorized, problem.CodeUnauthorized, "Unauthorized")
 		return
 	}
 
@@ -147,9 +351,12 @@ func ViewProfile(w http.ResponseWriter, r *http.Request) {
 	collection := database.GetCollection(colName)
 
 	var user models.User
-	err := collection.FindOne(context.TODO(), bson.M{"email": userEmail}).Decode(&user)
-	if err != nil {
-		http.Error(w, "User not found", http.StatusNotFound)
+	err := collection.FindOne(r.Context(), bson.M{"email": userEmail}).Decode(&user)
+	if err == mongo.ErrNoDocuments {
+		problem.Error(w, r, http.StatusNotFound, problem.CodeNotFound, "User not found")
+		return
+	} else if err != nil {
+		problem.ServerError(w, r, err, "Database error")
 		return
 	}
 
@@ -158,10 +365,6 @@ func ViewProfile(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(user)
 }
 
-
-
-
-
 // UpdateProfile updates a user's profile.
 // @Summary Update user profile
 // @Description Update the profile details of a logged-in user.
@@ -169,61 +372,67 @@ func ViewProfile(w http.ResponseWriter, r *http.Request) {
 // @Accept json
 // @Produce json
 // @Param id path string true "User ID"
-// @Param user body models.User true "Updated user data"
+// @Param user body models.UpdateProfileRequest true "Updated user data"
 // @Success 200 {string} string "Profile updated successfully"
-// @Failure 400 {string} string "Invalid user data"
-// @Failure 401 {string} string "Unauthorized"
-// @Failure 404 {string} string "User not found"
-// @Failure 500 {string} string "Failed to update profile"
+// @Failure 400 {object} problem.Problem "Invalid user data"
+// @Failure 401 {object} problem.Problem "Unauthorized"
+// @Failure 404 {object} problem.Problem "User not found"
+// @Failure 500 {object} problem.Problem "Failed to update profile"
 // @Router /profile/{id} [put]
 func UpdateProfile(w http.ResponseWriter, r *http.Request) {
+	r, span := startSpan(r, "UpdateProfile")
+	defer span.End()
+
 	// Retrieve email from context
 	email, ok := r.Context().Value(middleware.EmailContextKey).(string)
 	if !ok || email == "" {
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		problem.Error(w, r, http.StatusUnauthorized, problem.CodeUnauthorized, "Unauthorized")
 		return
 	}
 
-
 	vars := mux.Vars(r)
 	userIDStr := vars["id"]
 
 	userID, err := primitive.ObjectIDFromHex(userIDStr)
 	if err != nil {
-		http.Error(w, "Invalid user ID format", http.StatusBadRequest)
+		problem.Error(w, r, http.StatusBadRequest, problem.CodeInvalidRequest, "Invalid user ID format")
 		return
 	}
 
 	collection := database.GetCollection("schooguser")
 	var user models.User
-	err = collection.FindOne(context.TODO(), bson.M{"_id": userID, "email": email}).Decode(&user)
+	err = collection.FindOne(r.Context(), bson.M{"_id": userID, "email": email}).Decode(&user)
 	if err != nil {
 		if err == mongo.ErrNoDocuments {
-			http.Error(w, "User not found or unauthorized", http.StatusNotFound)
+			problem.Error(w, r, http.StatusNotFound, problem.CodeNotFound, "User not found or unauthorized")
 		} else {
-			http.Error(w, "Database error", http.StatusInternalServerError)
+			problem.ServerError(w, r, err, "Database error")
 		}
 		return
 	}
 
-	var updatedUser models.User
-	err = json.NewDecoder(r.Body).Decode(&updatedUser)
-	if err != nil {
-		http.Error(w, "Invalid user data", http.StatusBadRequest)
+	var request models.UpdateProfileRequest
+	if !validate.DecodeJSON(w, r, &request) {
 		return
 	}
 
-	user.Name = updatedUser.Name
-	// You can also update other fields like Email if needed, e.g.:
-	// user.Email = updatedUser.Email
+	before := map[string]interface{}{"name": user.Name}
+	user.Name = strings.TrimSpace(request.Name)
 
 	// Update the user in the database
-	_, err = collection.UpdateOne(context.TODO(), bson.M{"_id": userID}, bson.M{"$set": user})
+	_, err = collection.UpdateOne(r.Context(), bson.M{"_id": userID}, bson.M{"$set": bson.M{"name": user.Name}})
 	if err != nil {
-		http.Error(w, "Failed to update user", http.StatusInternalServerError)
+		problem.ServerError(w, r, err, "Failed to update user")
 		return
 	}
 
+	event := audit.FromRequest(r, audit.ActionProfileUpdate).On(audit.TargetUser, userID.Hex())
+	event.Before, event.After = before, map[string]interface{}{"name": user.Name}
+	audit.Record(r.Context(), event)
+
+	// Refreshing the author name copied into the user's posts in the background
+	jobs.EnqueueAuthorUpdate(r.Context(), jobs.AuthorUpdate{UserID: userID, Name: user.Name, Email: user.Email})
+
 	// Send the response back
 	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(map[string]interface{}{
@@ -0,0 +1,37 @@
+package controller
+
+import (
+	"context"
+	"sync"
+)
+
+// background tracks work handlers leave running after responding
+var background sync.WaitGroup
+
+// runInBackground runs fn after the response without tying it to the request, so that work
+// such as sending email neither delays the response nor reveals through its timing that it
+// happened. fn's context keeps the request's values but isn't cancelled with it.
+func runInBackground(ctx context.Context, fn func(ctx context.Context)) {
+	ctx = context.WithoutCancel(ctx)
+	background.Add(1)
+	go func() {
+		defer background.Done()
+		fn(ctx)
+	}()
+}
+
+// WaitForBackground waits until work started by handlers has finished or ctx is done,
+// reporting whether it finished.
+func WaitForBackground(ctx context.Context) bool {
+	done := make(chan struct{})
+	go func() {
+		background.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
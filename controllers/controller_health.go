@@ -0,0 +1,55 @@
+package controller
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/Aman913k/health"
+)
+
+// Healthz reports that the process is alive.
+// @Summary Liveness probe
+// @Description Answer as long as the process is running, without checking its dependencies.
+// @Tags Health
+// @Produce json
+// @Success 200 {object} map[string]string "Process is alive"
+// @Router /healthz [get]
+func Healthz(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(map[string]string{"status": health.StatusOK})
+}
+
+// Readyz reports whether the process can serve traffic.
+// @Summary Readiness probe
+// @Description Check that MongoDB answers, that every migration has been applied and that the server isn't shutting down. Each check is reported with its outcome and duration.
+// @Tags Health
+// @Produce json
+// @Success 200 {object} health.Report "Ready to serve traffic"
+// @Failure 503 {object} health.Report "A check failed"
+// @Router /readyz [get]
+func Readyz(w http.ResponseWriter, r *http.Request) {
+	report := health.Ready(r.Context())
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	if report.Status != health.StatusOK {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(report)
+}
+
+// Version reports the running build.
+// @Summary Build information
+// @Description Report the version and commit of the running build and when the process started.
+// @Tags Health
+// @Produce json
+// @Success 200 {object} health.BuildInfo "Build information"
+// @Router /version [get]
+func Version(w http.ResponseWriter, r *http.Request) {
+	_, span := startSpan(r, "Version")
+	defer span.End()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(health.Build())
+}
@@ -0,0 +1,175 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/Aman913k/audit"
+	"github.com/Aman913k/database"
+	"github.com/Aman913k/lockout"
+	"github.com/Aman913k/mailer"
+	"github.com/Aman913k/metrics"
+	"github.com/Aman913k/middleware"
+	"github.com/Aman913k/problem"
+	"github.com/Aman913k/utils"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// unlockToken is an emailed unlock link. Only the hash of its token is stored, and the link
+// works once.
+type unlockToken struct {
+	TokenHash string    `bson:"_id"`
+	Email     string    `bson:"email"`
+	CreatedAt time.Time `bson:"created_at"`
+	ExpiresAt time.Time `bson:"expires_at"`
+}
+
+var (
+	dummyHashOnce sync.Once
+	dummyHash     string
+)
+
+// dummyPasswordHash returns a hash made with the current hasher, compared against when
+// the email is unknown so that response times don't reveal which accounts exist.
+func dummyPasswordHash() string {
+	dummyHashOnce.Do(func() {
+		dummyHash, _ = utils.Passwords.Hash("not-a-real-password")
+	})
+	return dummyHash
+}
+
+// checkLoginAllowed refuses the attempt with 423 or 429 while the account or client is
+// locked or backing off after failures. It reports whether the attempt may proceed.
+func checkLoginAllowed(w http.ResponseWriter, r *http.Request, email, ip string) bool {
+	decision, err := lockout.LoginGuard.Check(r.Context(), email, ip)
+	if err != nil {
+		problem.ServerError(w, r, err, "Server error")
+		return false
+	}
+	if decision.Allowed {
+		return true
+	}
+
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(decision.RetryAfter.Seconds()))))
+	if decision.Locked {
+		problem.Error(w, r, http.StatusLocked, problem.CodeAccountLocked, "Account temporarily locked")
+	} else {
+		problem.Error(w, r, http.StatusTooManyRequests, problem.CodeRateLimited, "Too many failed login attempts")
+	}
+	return false
+}
+
+// recordLoginFailure counts a failed login, records it in the audit log and, when it locks
+// an existing account, emails the owner a link to unlock it.
+func recordLoginFailure(r *http.Request, email string, accountExists bool, reason string) {
+	metrics.LoginFailures.Inc(reason)
+
+	// Counting the failure even if the client hangs up, so that guessing can't dodge the lockout
+	ctx := context.WithoutCancel(r.Context())
+	locked, err := lockout.LoginGuard.Fail(ctx, email, utils.ClientIP(r))
+	if err != nil {
+		middleware.Logger(r.Context()).Error("Failed to record login failure", "error", err)
+		return
+	}
+
+	event := audit.FromRequest(r, audit.ActionLoginFailed)
+	event.ActorEmail = email
+	event.Details = map[string]interface{}{"reason": reason, "account_exists": accountExists, "locked": locked}
+	audit.Record(ctx, event)
+
+	if !locked || !accountExists {
+		return
+	}
+
+	// Emailing in the background, so that locking a real account takes as long as any other failure
+	runInBackground(r.Context(), func(ctx context.Context) {
+		sendUnlockEmail(ctx, email)
+	})
+}
+
+// sendUnlockEmail stores a new unlock link for the locked account and emails it to the owner.
+func sendUnlockEmail(ctx context.Context, email string) {
+	token, err := newEmailToken()
+	if err != nil {
+		middleware.Logger(ctx).Error("Failed to generate unlock token", "error", err)
+		return
+	}
+	now := time.Now()
+	_, err = database.GetCollection("schoogunlocktoken").InsertOne(ctx, unlockToken{
+		TokenHash: hashEmailToken(token),
+		Email:     email,
+		CreatedAt: now,
+		ExpiresAt: now.Add(lockout.LoginGuard.Account.LockoutDuration),
+	})
+	if err != nil {
+		middleware.Logger(ctx).Error("Failed to store unlock token", "error", err)
+		return
+	}
+
+	link := fmt.Sprintf("%s/login/unlock?token=%s", utils.PublicURL, url.QueryEscape(token))
+	err = mailer.Default.Send(ctx, mailer.Message{
+		To:      email,
+		Subject: "Your account has been locked",
+		Body: fmt.Sprintf("Your account was locked after too many failed login attempts.\n\n"+
+			"If this was you, unlock it now by opening:\n%s\n\n"+
+			"Otherwise it unlocks by itself in %s.\n", link, lockout.LoginGuard.Account.LockoutDuration.Round(time.Minute)),
+	})
+	if err != nil {
+		middleware.Logger(ctx).Error("Failed to send unlock email", "error", err)
+	}
+}
+
+// UnlockAccount unlocks an account using the link emailed when it was locked.
+// @Summary Unlock account
+// @Description Unlock an account locked after too many failed logins, using the token from the unlock email. Each link works once.
+// @Tags Auth
+// @Produce json
+// @Param token query string true "Unlock token"
+// @Success 200 {string} string "Account unlocked"
+// @Failure 400 {object} problem.Problem "Invalid or expired unlock token"
+// @Failure 429 {object} problem.Problem "Too many requests"
+// @Failure 500 {object} problem.Problem "Failed to unlock account"
+// @Router /login/unlock [get]
+func UnlockAccount(w http.ResponseWriter, r *http.Request) {
+	r, span := startSpan(r, "UnlockAccount")
+	defer span.End()
+
+	token := r.URL.Query().Get("token")
+	if token == "" {
+		problem.Error(w, r, http.StatusBadRequest, problem.CodeInvalidToken, "Invalid or expired unlock token")
+		return
+	}
+
+	// Deleting the token as it is read, so that the link can't be replayed
+	var unlock unlockToken
+	err := database.GetCollection("schoogunlocktoken").FindOneAndDelete(r.Context(), bson.M{
+		"_id":        hashEmailToken(token),
+		"expires_at": bson.M{"$gt": time.Now()},
+	}).Decode(&unlock)
+	if err == mongo.ErrNoDocuments {
+		problem.Error(w, r, http.StatusBadRequest, problem.CodeInvalidToken, "Invalid or expired unlock token")
+		return
+	} else if err != nil {
+		problem.ServerError(w, r, err, "Failed to unlock account")
+		return
+	}
+
+	if err := lockout.LoginGuard.Unlock(r.Context(), unlock.Email); err != nil {
+		problem.ServerError(w, r, err, "Failed to unlock account")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message": "Account unlocked",
+	})
+}
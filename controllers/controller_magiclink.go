@@ -0,0 +1,298 @@
+package controller
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"math"
+	"mime"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/Aman913k/database"
+	"github.com/Aman913k/lockout"
+	"github.com/Aman913k/mailer"
+	"github.com/Aman913k/middleware"
+	"github.com/Aman913k/models"
+	"github.com/Aman913k/problem"
+	"github.com/Aman913k/utils"
+	"github.com/Aman913k/validate"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// magicLinkTTL is how long an emailed sign-in link stays valid
+const magicLinkTTL = 15 * time.Minute
+
+// MagicLinkURL is where sign-in links point, with the token appended as a query parameter.
+// When empty, links open the confirmation page of the verify endpoint, which needs cookie sessions.
+var MagicLinkURL = ""
+
+// LoginRedirectURL is where the browser goes after signing in on the confirmation page.
+// When empty, it goes to PublicURL.
+var LoginRedirectURL = ""
+
+// browserLoginContextKey marks a login posted by the confirmation page's form, which ends
+// with a cookie session and a redirect instead of a JSON response
+const browserLoginContextKey = contextKey("browser_login")
+
+// magicLink is an emailed sign-in link. Only the hash of its token is stored.
+type magicLink struct {
+	TokenHash string             `bson:"_id"`
+	UserID    primitive.ObjectID `bson:"user_id"`
+	CreatedAt time.Time          `bson:"created_at"`
+	ExpiresAt time.Time          `bson:"expires_at"`
+}
+
+// RequestMagicLink emails a sign-in link.
+// @Summary Request a sign-in link
+// @Description Email a single-use sign-in link, valid for 15 minutes, to the given address. The response is the same whether or not an account exists.
+// @Tags Auth
+// @Accept json
+// @Produce json
+// @Param request body models.MagicLinkRequest true "Email address"
+// @Success 202 {string} string "Sign-in link sent if the account exists"
+// @Failure 400 {object} problem.Problem "Invalid input"
+// @Failure 429 {object} problem.Problem "Too many sign-in link requests"
+// @Router /login/magic [post]
+func RequestMagicLink(w http.ResponseWriter, r *http.Request) {
+	r, span := startSpan(r, "RequestMagicLink")
+	defer span.End()
+
+	var request models.MagicLinkRequest
+	if !validate.DecodeJSON(w, r, &request) {
+		return
+	}
+
+	email := utils.NormalizeEmail(request.Email)
+	ip := utils.ClientIP(r)
+
+	// Throttling by address whether or not it has an account, so that throttling reveals nothing
+	decision, err := lockout.MagicLinkGuard.Check(r.Context(), email, ip)
+	if err != nil {
+		problem.ServerError(w, r, err, "Server error")
+		return
+	}
+	if !decision.Allowed {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(decision.RetryAfter.Seconds()))))
+		problem.Error(w, r, http.StatusTooManyRequests, problem.CodeRateLimited, "Too many sign-in link requests")
+		return
+	}
+	// Counting the request even if the client hangs up, so that the throttle can't be dodged
+	if _, err := lockout.MagicLinkGuard.Fail(context.WithoutCancel(r.Context()), email, ip); err != nil {
+		middleware.Logger(r.Context()).Error("Failed to record sign-in link request", "error", err)
+	}
+
+	var user models.User
+	err = database.GetCollection("schooguser").FindOne(r.Context(), bson.M{"email": email}).Decode(&user)
+	if err == nil {
+		// Sending after responding, so that the response time doesn't reveal that the account exists
+		runInBackground(r.Context(), func(ctx context.Context) { sendMagicLink(ctx, &user) })
+	} else if err != mongo.ErrNoDocuments {
+		problem.ServerError(w, r, err, "Server error")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message": "If an account exists for this email, a sign-in link has been sent",
+	})
+}
+
+// sendMagicLink stores a new sign-in link for the user and emails it. Failures are only
+// logged, the response must not differ from the one for unknown addresses.
+func sendMagicLink(ctx context.Context, user *models.User) {
+	token, err := newEmailToken()
+	if err != nil {
+		middleware.Logger(ctx).Error("Failed to generate sign-in token", "error", err)
+		return
+	}
+
+	now := time.Now()
+	_, err = database.GetCollection("schoogmagiclink").InsertOne(ctx, magicLink{
+		TokenHash: hashEmailToken(token),
+		UserID:    user.ID,
+		CreatedAt: now,
+		ExpiresAt: now.Add(magicLinkTTL),
+	})
+	if err != nil {
+		middleware.Logger(ctx).Error("Failed to store sign-in link", "error", err)
+		return
+	}
+
+	base := MagicLinkURL
+	if base == "" {
+		base = utils.PublicURL + "/login/magic/verify"
+	}
+	link := fmt.Sprintf("%s?token=%s", base, url.QueryEscape(token))
+	err = mailer.Default.Send(ctx, mailer.Message{
+		To:      user.Email,
+		Subject: "Your sign-in link",
+		Body: fmt.Sprintf("Open this link to sign in:\n%s\n\n"+
+			"It can be used once and expires in %s. If you didn't ask for it, you can ignore this email.\n",
+			link, magicLinkTTL),
+	})
+	if err != nil {
+		middleware.Logger(ctx).Error("Failed to send sign-in link", "error", err)
+	}
+}
+
+// confirmMagicLinkPage asks the user to confirm the sign-in. Opening the link must not use it
+// up, as mail scanners and link previews open links before the user does.
+var confirmMagicLinkPage = template.Must(template.New("confirm").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>Sign in</title></head>
+<body>
+<form method="post" action="">
+<input type="hidden" name="token" value="{{.}}">
+<button type="submit">Sign in</button>
+</form>
+</body>
+</html>
+`))
+
+// ConfirmMagicLink shows the page that completes a sign-in link.
+// @Summary Confirm a sign-in link
+// @Description Page opened from a sign-in link. It doesn't use up the link; the user confirms, which posts the token to /login/magic/verify, logs them in with a cookie session and redirects them to the frontend. Needs cookie sessions.
+// @Tags Auth
+// @Produce html
+// @Param token query string true "Sign-in token"
+// @Success 200 {string} string "Confirmation page"
+// @Failure 400 {object} problem.Problem "Invalid or expired sign-in link"
+// @Failure 404 {object} problem.Problem "Cookie sessions are disabled"
+// @Failure 429 {object} problem.Problem "Too many requests"
+// @Router /login/magic/verify [get]
+func ConfirmMagicLink(w http.ResponseWriter, r *http.Request) {
+	r, span := startSpan(r, "ConfirmMagicLink")
+	defer span.End()
+
+	if !middleware.CookieSessions {
+		writeBrowserSignInDisabled(w, r)
+		return
+	}
+	token := r.URL.Query().Get("token")
+	if token == "" {
+		problem.Error(w, r, http.StatusBadRequest, problem.CodeInvalidToken, "Invalid or expired sign-in link")
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Referrer-Policy", "no-referrer")
+	w.WriteHeader(http.StatusOK)
+	confirmMagicLinkPage.Execute(w, token)
+}
+
+// VerifyMagicLink logs in with the token from a sign-in link.
+// @Summary Log in with a sign-in link
+// @Description Exchange the token from a sign-in link for a login token. Each link works once. The token is read from a JSON body, or from the form posted by the confirmation page; the form logs in with a cookie session and redirects to LOGIN_REDIRECT_URL, with the MFA challenge token in the fragment as mfa_token when a second factor is needed.
+// @Tags Auth
+// @Accept json
+// @Accept x-www-form-urlencoded
+// @Produce json
+// @Param request body models.VerifyMagicLinkRequest true "Sign-in token"
+// @Param X-Session-Mode header string false "cookie to log in with a cookie session"
+// @Success 200 {object} map[string]interface{} "token and user ID, or mfa_required and mfa_token"
+// @Success 303 {string} string "Logged in from the confirmation page, redirecting to the frontend"
+// @Failure 400 {object} problem.Problem "Invalid or expired sign-in link"
+// @Failure 404 {object} problem.Problem "Form posted while cookie sessions are disabled"
+// @Failure 429 {object} problem.Problem "Too many requests"
+// @Failure 500 {object} problem.Problem "Server error"
+// @Router /login/magic/verify [post]
+func VerifyMagicLink(w http.ResponseWriter, r *http.Request) {
+	r, span := startSpan(r, "VerifyMagicLink")
+	defer span.End()
+
+	var token string
+	if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType == "application/x-www-form-urlencoded" {
+		// The browser shows whatever is returned, so the form logs in with a cookie and redirects
+		// rather than showing the token
+		if !middleware.CookieSessions {
+			writeBrowserSignInDisabled(w, r)
+			return
+		}
+		r.Body = http.MaxBytesReader(w, r.Body, validate.MaxBodyBytes)
+		token = r.PostFormValue("token")
+		r = r.WithContext(context.WithValue(r.Context(), browserLoginContextKey, true))
+	} else {
+		var request models.VerifyMagicLinkRequest
+		if !validate.DecodeJSON(w, r, &request) {
+			return
+		}
+		token = request.Token
+	}
+	if token == "" {
+		problem.Error(w, r, http.StatusBadRequest, problem.CodeInvalidToken, "Invalid or expired sign-in link")
+		return
+	}
+
+	// Deleting the link as it is read, so that it can't be replayed even by concurrent requests
+	var link magicLink
+	err := database.GetCollection("schoogmagiclink").FindOneAndDelete(r.Context(), bson.M{
+		"_id":        hashEmailToken(token),
+		"expires_at": bson.M{"$gt": time.Now()},
+	}).Decode(&link)
+	if err == mongo.ErrNoDocuments {
+		problem.Error(w, r, http.StatusBadRequest, problem.CodeInvalidToken, "Invalid or expired sign-in link")
+		return
+	} else if err != nil {
+		problem.ServerError(w, r, err, "Server error")
+		return
+	}
+
+	var user models.User
+	err = database.GetCollection("schooguser").FindOne(r.Context(), bson.M{"_id": link.UserID}).Decode(&user)
+	if err == mongo.ErrNoDocuments {
+		problem.Error(w, r, http.StatusBadRequest, problem.CodeInvalidToken, "Invalid or expired sign-in link")
+		return
+	} else if err != nil {
+		problem.ServerError(w, r, err, "Server error")
+		return
+	}
+
+	beginLogin(w, r, &user)
+}
+
+// writeBrowserSignInDisabled refuses the confirmation page when there is no cookie session
+// for it to log in with. Sign-in links then have to open a frontend page set in MagicLinkURL.
+func writeBrowserSignInDisabled(w http.ResponseWriter, r *http.Request) {
+	problem.Error(w, r, http.StatusNotFound, problem.CodeNotFound, "Sign-in links can't be completed here, open them in the app")
+}
+
+// isBrowserLogin reports whether a login was posted by the confirmation page's form.
+func isBrowserLogin(r *http.Request) bool {
+	return r.Context().Value(browserLoginContextKey) == true
+}
+
+// loginRedirectURL returns where the browser goes after signing in on the confirmation page.
+func loginRedirectURL() string {
+	if LoginRedirectURL != "" {
+		return LoginRedirectURL
+	}
+	return utils.PublicURL + "/"
+}
+
+// newEmailToken generates a random token for a single-use link sent by email.
+func newEmailToken() (string, error) {
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(raw), nil
+}
+
+// hashEmailToken hashes a token from newEmailToken for storage. Tokens are random, so a plain
+// SHA-256 lets them be looked up directly.
+func hashEmailToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
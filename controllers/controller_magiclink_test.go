@@ -0,0 +1,85 @@
+package controller
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/Aman913k/middleware"
+	"github.com/Aman913k/models"
+	"github.com/Aman913k/utils"
+)
+
+func withCookieSessions(t *testing.T, enabled bool) {
+	t.Helper()
+	previous := middleware.CookieSessions
+	middleware.CookieSessions = enabled
+	t.Cleanup(func() { middleware.CookieSessions = previous })
+}
+
+func TestConfirmMagicLinkPage(t *testing.T) {
+	withCookieSessions(t, true)
+
+	w := httptest.NewRecorder()
+	ConfirmMagicLink(w, httptest.NewRequest(http.MethodGet, "/login/magic/verify?token=a%22b%3Cc", nil))
+
+	if w.Code != http.StatusOK || !strings.HasPrefix(w.Header().Get("Content-Type"), "text/html") {
+		t.Fatalf("response %d %s, want the HTML page", w.Code, w.Header().Get("Content-Type"))
+	}
+	if got := w.Header().Get("Cache-Control"); got != "no-store" {
+		t.Errorf("Cache-Control = %q, want no-store", got)
+	}
+	body := w.Body.String()
+	if !strings.Contains(body, `<form method="post"`) || !strings.Contains(body, `value="a&#34;b&lt;c"`) {
+		t.Errorf("page doesn't post the escaped token:\n%s", body)
+	}
+}
+
+func TestBrowserSignInNeedsCookieSessions(t *testing.T) {
+	withCookieSessions(t, false)
+
+	w := httptest.NewRecorder()
+	ConfirmMagicLink(w, httptest.NewRequest(http.MethodGet, "/login/magic/verify?token=t", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("confirmation page status = %d, want %d", w.Code, http.StatusNotFound)
+	}
+
+	// The form is refused before the link is looked up, so it isn't used up
+	r := httptest.NewRequest(http.MethodPost, "/login/magic/verify", strings.NewReader("token=t"))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w = httptest.NewRecorder()
+	VerifyMagicLink(w, r)
+	if w.Code != http.StatusNotFound || strings.Contains(w.Body.String(), "token=t") {
+		t.Errorf("form status = %d, want %d: %s", w.Code, http.StatusNotFound, w.Body)
+	}
+}
+
+func TestBrowserLoginRedirectsToMFAChallenge(t *testing.T) {
+	defer func(key []byte) { utils.SigningKey = key }(utils.SigningKey)
+	utils.SigningKey = []byte("test signing key")
+	defer func(url string) { LoginRedirectURL = url }(LoginRedirectURL)
+	LoginRedirectURL = "https://app.test/signed-in"
+
+	r := httptest.NewRequest(http.MethodPost, "/login/magic/verify", nil)
+	r = r.WithContext(context.WithValue(r.Context(), browserLoginContextKey, true))
+	w := httptest.NewRecorder()
+	beginLogin(w, r, &models.User{Email: "student@example.edu", TOTPEnabled: true})
+
+	if w.Code != http.StatusSeeOther {
+		t.Fatalf("status = %d, want %d: %s", w.Code, http.StatusSeeOther, w.Body)
+	}
+	location, err := url.Parse(w.Header().Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	fragment, _ := url.ParseQuery(location.Fragment)
+	if location.Scheme+"://"+location.Host+location.Path != LoginRedirectURL || location.RawQuery != "" {
+		t.Errorf("redirected to %s, want %s", location, LoginRedirectURL)
+	}
+	if claims, err := utils.ValidateActionToken(fragment.Get("mfa_token"), mfaPurpose); err != nil || claims.Email != "student@example.edu" {
+		t.Errorf("fragment %q doesn't carry the MFA challenge: %v", location.Fragment, err)
+	}
+}
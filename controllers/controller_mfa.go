@@ -0,0 +1,394 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/Aman913k/audit"
+	"github.com/Aman913k/database"
+	"github.com/Aman913k/models"
+	"github.com/Aman913k/problem"
+	"github.com/Aman913k/utils"
+	"github.com/Aman913k/validate"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const (
+	mfaPurpose        = "mfa"
+	mfaChallengeTTL   = 5 * time.Minute
+	recoveryCodeCount = 10
+)
+
+// EnrollMFA starts two-factor enrolment for the authenticated user.
+// @Summary Start TOTP enrolment
+// @Description Generate a new TOTP secret for the logged-in user. Add it to an authenticator app, usually by rendering provisioning_uri as a QR code, then confirm with a code at /profile/mfa/confirm.
+// @Tags MFA
+// @Produce json
+// @Success 200 {object} map[string]string "secret and provisioning_uri"
+// @Failure 401 {object} problem.Problem "Unauthorized"
+// @Failure 409 {object} problem.Problem "Two-factor authentication is already enabled"
+// @Failure 500 {object} problem.Problem "Failed to start enrolment"
+// @Router /profile/mfa/enroll [post]
+func EnrollMFA(w http.ResponseWriter, r *http.Request) {
+	r, span := startSpan(r, "EnrollMFA")
+	defer span.End()
+
+	user, ok := loadAuthenticatedUser(w, r)
+	if !ok {
+		return
+	}
+	if user.TOTPEnabled {
+		problem.Error(w, r, http.StatusConflict, problem.CodeConflict, "Two-factor authentication is already enabled")
+		return
+	}
+
+	secret, err := utils.GenerateTOTPSecret()
+	if err != nil {
+		problem.ServerError(w, r, err, "Failed to start enrolment")
+		return
+	}
+
+	_, err = database.GetCollection("schooguser").UpdateOne(r.Context(),
+		bson.M{"_id": user.ID, "totp_enabled": bson.M{"$ne": true}},
+		bson.M{"$set": bson.M{"totp_secret": secret}, "$unset": bson.M{"totp_last_step": ""}},
+	)
+	if err != nil {
+		problem.ServerError(w, r, err, "Failed to start enrolment")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]string{
+		"secret":           secret,
+		"provisioning_uri": utils.TOTPProvisioningURI(secret, user.Email),
+	})
+}
+
+// ConfirmMFA finishes enrolment by checking a code from the authenticator app.
+// @Summary Confirm TOTP enrolment
+// @Description Enable two-factor authentication by submitting a code generated from the secret returned by /profile/mfa/enroll. The response contains single-use recovery codes that are shown only once.
+// @Tags MFA
+// @Accept json
+// @Produce json
+// @Param code body models.MFACodeRequest true "TOTP code"
+// @Success 200 {object} map[string]interface{} "recovery_codes"
+// @Failure 400 {object} problem.Problem "Invalid code or no enrolment in progress"
+// @Failure 401 {object} problem.Problem "Unauthorized"
+// @Failure 409 {object} problem.Problem "Two-factor authentication is already enabled"
+// @Failure 500 {object} problem.Problem "Failed to enable two-factor authentication"
+// @Router /profile/mfa/confirm [post]
+func ConfirmMFA(w http.ResponseWriter, r *http.Request) {
+	r, span := startSpan(r, "ConfirmMFA")
+	defer span.End()
+
+	user, ok := loadAuthenticatedUser(w, r)
+	if !ok {
+		return
+	}
+
+	var request models.MFACodeRequest
+	if !validate.DecodeJSON(w, r, &request) {
+		return
+	}
+
+	if user.TOTPEnabled {
+		problem.Error(w, r, http.StatusConflict, problem.CodeConflict, "Two-factor authentication is already enabled")
+		return
+	}
+	if user.TOTPSecret == "" {
+		problem.Error(w, r, http.StatusBadRequest, problem.CodeInvalidRequest, "No enrolment in progress")
+		return
+	}
+
+	step, valid := utils.ValidateTOTP(user.TOTPSecret, request.Code, time.Now(), user.TOTPLastStep)
+	if !valid {
+		problem.Error(w, r, http.StatusBadRequest, problem.CodeInvalidMFACode, "Invalid code")
+		return
+	}
+
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		problem.ServerError(w, r, err, "Failed to enable two-factor authentication")
+		return
+	}
+
+	_, err = database.GetCollection("schooguser").UpdateOne(r.Context(),
+		bson.M{"_id": user.ID, "totp_secret": user.TOTPSecret},
+		bson.M{"$set": bson.M{"totp_enabled": true, "totp_last_step": step, "recovery_codes": hashes}},
+	)
+	if err != nil {
+		problem.ServerError(w, r, err, "Failed to enable two-factor authentication")
+		return
+	}
+	audit.Record(r.Context(), audit.FromRequest(r, audit.ActionMFAEnable).On(audit.TargetUser, user.ID.Hex()))
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message":        "Two-factor authentication enabled",
+		"recovery_codes": codes,
+	})
+}
+
+// DisableMFA turns off two-factor authentication.
+// @Summary Disable TOTP
+// @Description Disable two-factor authentication. Requires the account password and a current TOTP code or a recovery code.
+// @Tags MFA
+// @Accept json
+// @Produce json
+// @Param confirmation body models.DisableMFARequest true "Password and second factor"
+// @Success 200 {string} string "Two-factor authentication disabled"
+// @Failure 400 {object} problem.Problem "Two-factor authentication is not enabled"
+// @Failure 401 {object} problem.Problem "Unauthorized, wrong password or invalid code"
+// @Failure 500 {object} problem.Problem "Failed to disable two-factor authentication"
+// @Router /profile/mfa/disable [post]
+func DisableMFA(w http.ResponseWriter, r *http.Request) {
+	r, span := startSpan(r, "DisableMFA")
+	defer span.End()
+
+	user, ok := loadAuthenticatedUser(w, r)
+	if !ok {
+		return
+	}
+
+	var request models.DisableMFARequest
+	if !validate.DecodeJSON(w, r, &request) {
+		return
+	}
+
+	if !user.TOTPEnabled {
+		problem.Error(w, r, http.StatusBadRequest, problem.CodeInvalidRequest, "Two-factor authentication is not enabled")
+		return
+	}
+	if match, _, _ := utils.Passwords.Verify(user.Password, request.Password); !match {
+		problem.Error(w, r, http.StatusUnauthorized, problem.CodeInvalidCredentials, "Wrong password")
+		return
+	}
+
+	valid, err := verifySecondFactor(r.Context(), user, request.Code, request.RecoveryCode)
+	if err != nil {
+		problem.ServerError(w, r, err, "Database error")
+		return
+	}
+	if !valid {
+		problem.Error(w, r, http.StatusUnauthorized, problem.CodeInvalidMFACode, "Invalid code")
+		return
+	}
+
+	_, err = database.GetCollection("schooguser").UpdateOne(r.Context(),
+		bson.M{"_id": user.ID},
+		bson.M{"$unset": bson.M{"totp_enabled": "", "totp_secret": "", "totp_last_step": "", "recovery_codes": ""}},
+	)
+	if err != nil {
+		problem.ServerError(w, r, err, "Failed to disable two-factor authentication")
+		return
+	}
+	audit.Record(r.Context(), audit.FromRequest(r, audit.ActionMFADisable).On(audit.TargetUser, user.ID.Hex()))
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message": "Two-factor authentication disabled",
+	})
+}
+
+// RegenerateRecoveryCodes replaces the user's recovery codes.
+// @Summary Regenerate recovery codes
+// @Description Replace all recovery codes with new ones after confirming a current TOTP code. The old codes stop working.
+// @Tags MFA
+// @Accept json
+// @Produce json
+// @Param code body models.MFACodeRequest true "TOTP code"
+// @Success 200 {object} map[string]interface{} "recovery_codes"
+// @Failure 400 {object} problem.Problem "Two-factor authentication is not enabled"
+// @Failure 401 {object} problem.Problem "Unauthorized or invalid code"
+// @Failure 500 {object} problem.Problem "Failed to regenerate recovery codes"
+// @Router /profile/mfa/recovery-codes [post]
+func RegenerateRecoveryCodes(w http.ResponseWriter, r *http.Request) {
+	r, span := startSpan(r, "RegenerateRecoveryCodes")
+	defer span.End()
+
+	user, ok := loadAuthenticatedUser(w, r)
+	if !ok {
+		return
+	}
+
+	var request models.MFACodeRequest
+	if !validate.DecodeJSON(w, r, &request) {
+		return
+	}
+
+	if !user.TOTPEnabled {
+		problem.Error(w, r, http.StatusBadRequest, problem.CodeInvalidRequest, "Two-factor authentication is not enabled")
+		return
+	}
+
+	// Only an authenticator code may mint new recovery codes
+	valid, err := verifySecondFactor(r.Context(), user, request.Code, "")
+	if err != nil {
+		problem.ServerError(w, r, err, "Database error")
+		return
+	}
+	if !valid {
+		problem.Error(w, r, http.StatusUnauthorized, problem.CodeInvalidMFACode, "Invalid code")
+		return
+	}
+
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		problem.ServerError(w, r, err, "Failed to regenerate recovery codes")
+		return
+	}
+
+	_, err = database.GetCollection("schooguser").UpdateOne(r.Context(),
+		bson.M{"_id": user.ID},
+		bson.M{"$set": bson.M{"recovery_codes": hashes}},
+	)
+	if err != nil {
+		problem.ServerError(w, r, err, "Failed to regenerate recovery codes")
+		return
+	}
+	audit.Record(r.Context(), audit.FromRequest(r, audit.ActionRecoveryCodes).On(audit.TargetUser, user.ID.Hex()))
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"recovery_codes": codes,
+	})
+}
+
+// VerifyMFALogin completes a login that returned an MFA challenge.
+// @Summary Complete MFA login
+// @Description Exchange the mfa_token returned by /login and a TOTP code, or a recovery code, for an access token.
+// @Tags Auth
+// @Accept json
+// @Produce json
+// @Param challenge body models.MFALoginRequest true "MFA token and code"
+// @Param X-Session-Mode header string false "cookie to log in with a cookie session"
+// @Success 200 {object} map[string]string "token and user ID"
+// @Failure 400 {object} problem.Problem "Invalid input"
+// @Failure 401 {object} problem.Problem "Invalid or expired MFA token, or invalid code"
+// @Failure 423 {object} problem.Problem "Account temporarily locked"
+// @Failure 429 {object} problem.Problem "Too many failed login attempts"
+// @Router /login/mfa [post]
+func VerifyMFALogin(w http.ResponseWriter, r *http.Request) {
+	r, span := startSpan(r, "VerifyMFALogin")
+	defer span.End()
+
+	var request models.MFALoginRequest
+	if !validate.DecodeJSON(w, r, &request) {
+		return
+	}
+
+	claims, err := utils.ValidateActionToken(request.MFAToken, mfaPurpose)
+	if err != nil {
+		problem.Error(w, r, http.StatusUnauthorized, problem.CodeInvalidToken, "Invalid or expired MFA token")
+		return
+	}
+
+	// Wrong codes count as failed logins, so guessing codes backs off like guessing passwords
+	ip := utils.ClientIP(r)
+	if !checkLoginAllowed(w, r, claims.Email, ip) {
+		return
+	}
+
+	var user models.User
+	err = database.GetCollection("schooguser").FindOne(r.Context(), bson.M{"email": claims.Email}).Decode(&user)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			problem.Error(w, r, http.StatusUnauthorized, problem.CodeInvalidToken, "Invalid or expired MFA token")
+		} else {
+			problem.ServerError(w, r, err, "Database error")
+		}
+		return
+	}
+
+	valid, err := verifySecondFactor(r.Context(), &user, request.Code, request.RecoveryCode)
+	if err != nil {
+		problem.ServerError(w, r, err, "Database error")
+		return
+	}
+	if !valid {
+		recordLoginFailure(r, claims.Email, true, "invalid_mfa_code")
+		problem.Error(w, r, http.StatusUnauthorized, problem.CodeInvalidMFACode, "Invalid code")
+		return
+	}
+
+	completeLogin(w, r, &user)
+}
+
+// verifySecondFactor checks a TOTP code or, if given instead, a recovery code, and consumes it
+// so it can't be used again. The updates are conditional, so concurrent requests can't both use
+// the same code.
+func verifySecondFactor(ctx context.Context, user *models.User, code, recoveryCode string) (bool, error) {
+	collection := database.GetCollection("schooguser")
+
+	if recoveryCode != "" {
+		hash := utils.HashRecoveryCode(recoveryCode)
+		result, err := collection.UpdateOne(ctx,
+			bson.M{"_id": user.ID, "recovery_codes": hash},
+			bson.M{"$pull": bson.M{"recovery_codes": hash}},
+		)
+		if err != nil {
+			return false, err
+		}
+		return result.ModifiedCount == 1, nil
+	}
+
+	step, valid := utils.ValidateTOTP(user.TOTPSecret, code, time.Now(), user.TOTPLastStep)
+	if !valid {
+		return false, nil
+	}
+
+	result, err := collection.UpdateOne(ctx,
+		bson.M{"_id": user.ID, "$or": bson.A{
+			bson.M{"totp_last_step": bson.M{"$exists": false}},
+			bson.M{"totp_last_step": bson.M{"$lt": step}},
+		}},
+		bson.M{"$set": bson.M{"totp_last_step": step}},
+	)
+	if err != nil {
+		return false, err
+	}
+	return result.ModifiedCount == 1, nil
+}
+
+// newRecoveryCodes generates a fresh set of recovery codes along with the hashes to store.
+func newRecoveryCodes() ([]string, []string, error) {
+	codes, err := utils.GenerateRecoveryCodes(recoveryCodeCount)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	hashes := make([]string, len(codes))
+	for i, code := range codes {
+		hashes[i] = utils.HashRecoveryCode(code)
+	}
+	return codes, hashes, nil
+}
+
+// loadAuthenticatedUser fetches the record of the user the request was authenticated as,
+// writing an error response and returning false if that isn't possible.
+func loadAuthenticatedUser(w http.ResponseWriter, r *http.Request) (*models.User, bool) {
+	userID, ok := authenticatedUserID(r)
+	if !ok {
+		problem.Error(w, r, http.StatusUnauthorized, problem.CodeUnauthorized, "Unauthorized")
+		return nil, false
+	}
+
+	var user models.User
+	err := database.GetCollection("schooguser").FindOne(r.Context(), bson.M{"_id": userID}).Decode(&user)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			problem.Error(w, r, http.StatusUnauthorized, problem.CodeUnauthorized, "Unauthorized")
+		} else {
+			problem.ServerError(w, r, err, "Database error")
+		}
+		return nil, false
+	}
+	return &user, true
+}
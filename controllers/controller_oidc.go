@@ -0,0 +1,322 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/Aman913k/audit"
+	"github.com/Aman913k/database"
+	"github.com/Aman913k/middleware"
+	"github.com/Aman913k/models"
+	"github.com/Aman913k/oidc"
+	"github.com/Aman913k/problem"
+	"github.com/Aman913k/utils"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// oidcStateCookie binds a started OIDC login to the browser that started it
+const oidcStateCookie = "oidc_state"
+
+// oidcLoginTTL is how long the user has to complete the login at the provider
+const oidcLoginTTL = 10 * time.Minute
+
+// oidcLogin is a started OIDC login, stored until the provider redirects back.
+type oidcLogin struct {
+	State        string    `bson:"_id"`
+	Nonce        string    `bson:"nonce"`
+	CodeVerifier string    `bson:"code_verifier"`
+	CreatedAt    time.Time `bson:"created_at"`
+	// CookieSession remembers that the login was started asking for a cookie session
+	CookieSession bool `bson:"cookie_session,omitempty"`
+	// LinkUserID is set when a logged-in user started linking the provider account to theirs
+	LinkUserID *primitive.ObjectID `bson:"link_user_id,omitempty"`
+}
+
+type contextKey string
+
+// cookieSessionContextKey marks a login request that asked for a cookie session in an earlier
+// request, such as an OIDC login whose callback comes from the provider's redirect
+const cookieSessionContextKey = contextKey("cookie_session")
+
+// StartOIDCLogin redirects to the identity provider.
+// @Summary Start OIDC login
+// @Description Redirect to the school's identity provider to log in. The provider redirects back to /login/oidc/callback. To log in with a cookie session, request it with the X-Session-Mode: cookie header; the response then carries the provider's URL for the frontend to navigate to instead of redirecting.
+// @Tags Auth
+// @Produce json
+// @Param X-Session-Mode header string false "cookie to log in with a cookie session"
+// @Success 200 {object} map[string]string "authorization_url, for cookie sessions"
+// @Success 302 {string} string "Redirect to the identity provider"
+// @Failure 404 {object} problem.Problem "OIDC login is not configured"
+// @Failure 429 {object} problem.Problem "Too many requests"
+// @Failure 502 {object} problem.Problem "Identity provider unavailable"
+// @Router /login/oidc [get]
+func StartOIDCLogin(w http.ResponseWriter, r *http.Request) {
+	r, span := startSpan(r, "StartOIDCLogin")
+	defer span.End()
+
+	cookieSession := middleware.WantsCookieSession(r)
+	authURL, ok := startOIDC(w, r, oidcLogin{CookieSession: cookieSession})
+	if !ok {
+		return
+	}
+
+	// A request carrying the session mode header comes from script, which can't follow a
+	// redirect to the provider, so the frontend navigates there itself
+	if cookieSession {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(map[string]string{"authorization_url": authURL})
+		return
+	}
+	http.Redirect(w, r, authURL, http.StatusFound)
+}
+
+// StartOIDCLink starts linking an identity provider account to the authenticated user.
+// @Summary Link an identity provider account
+// @Description Start linking the school's identity provider to the logged-in user's account, so that they can log in through it. Navigate to the returned URL; the provider redirects back to /login/oidc/callback, which completes the link.
+// @Tags Profile
+// @Produce json
+// @Success 200 {object} map[string]string "authorization_url"
+// @Failure 401 {object} problem.Problem "Unauthorized"
+// @Failure 403 {object} problem.Problem "API keys cannot be used here"
+// @Failure 404 {object} problem.Problem "OIDC login is not configured"
+// @Failure 502 {object} problem.Problem "Identity provider unavailable"
+// @Router /profile/oidc/link [post]
+func StartOIDCLink(w http.ResponseWriter, r *http.Request) {
+	r, span := startSpan(r, "StartOIDCLink")
+	defer span.End()
+
+	userID, ok := authenticatedUserID(r)
+	if !ok {
+		problem.Error(w, r, http.StatusUnauthorized, problem.CodeUnauthorized, "Unauthorized")
+		return
+	}
+
+	authURL, ok := startOIDC(w, r, oidcLogin{LinkUserID: &userID})
+	if !ok {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]string{"authorization_url": authURL})
+}
+
+// startOIDC stores a new OIDC login based on login, binds it to the browser and returns the
+// provider's authorization URL. When the login can't be started it responds with a problem
+// and returns false.
+func startOIDC(w http.ResponseWriter, r *http.Request, login oidcLogin) (string, bool) {
+	if oidc.Default == nil {
+		problem.Error(w, r, http.StatusNotFound, problem.CodeNotFound, "OIDC login is not configured")
+		return "", false
+	}
+
+	state, errState := oidc.RandomString(32)
+	nonce, errNonce := oidc.RandomString(32)
+	verifier, challenge, errPKCE := oidc.NewPKCE()
+	if errState != nil || errNonce != nil || errPKCE != nil {
+		problem.Error(w, r, http.StatusInternalServerError, problem.CodeInternal, "Failed to start login")
+		return "", false
+	}
+
+	authURL, err := oidc.Default.AuthCodeURL(r.Context(), state, nonce, challenge)
+	if err != nil {
+		middleware.Logger(r.Context()).Error("OIDC discovery failed", "error", err)
+		problem.Error(w, r, http.StatusBadGateway, problem.CodeIdentityProvider, "Identity provider unavailable")
+		return "", false
+	}
+
+	login.State, login.Nonce, login.CodeVerifier, login.CreatedAt = state, nonce, verifier, time.Now()
+	if _, err := database.GetCollection("schoogoidcstate").InsertOne(r.Context(), login); err != nil {
+		problem.ServerError(w, r, err, "Failed to start login")
+		return "", false
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcStateCookie,
+		Value:    state,
+		Path:     "/login/oidc",
+		MaxAge:   int(oidcLoginTTL.Seconds()),
+		HttpOnly: true,
+		Secure:   strings.HasPrefix(utils.PublicURL, "https://"),
+		SameSite: http.SameSiteLaxMode,
+	})
+	return authURL, true
+}
+
+// OIDCCallback completes a login at the identity provider.
+// @Summary Complete OIDC login
+// @Description Called by the identity provider after login. Logs in the user linked to the provider account, or creates a new user, and returns the same response as /login. Accounts that already exist with the same email are not linked automatically; their owner links the provider from /profile/oidc/link, which completes here instead of logging in.
+// @Tags Auth
+// @Produce json
+// @Param code query string true "Authorization code"
+// @Param state query string true "Login state"
+// @Success 200 {object} map[string]interface{} "token and user ID, or mfa_required and mfa_token"
+// @Failure 400 {object} problem.Problem "Invalid or expired login state"
+// @Failure 401 {object} problem.Problem "Identity provider login failed"
+// @Failure 403 {object} problem.Problem "Email is not verified or not allowed"
+// @Failure 404 {object} problem.Problem "OIDC login is not configured"
+// @Failure 409 {object} problem.Problem "An account with this email exists, or the identity is linked to another account"
+// @Failure 429 {object} problem.Problem "Too many requests"
+// @Router /login/oidc/callback [get]
+func OIDCCallback(w http.ResponseWriter, r *http.Request) {
+	r, span := startSpan(r, "OIDCCallback")
+	defer span.End()
+
+	if oidc.Default == nil {
+		problem.Error(w, r, http.StatusNotFound, problem.CodeNotFound, "OIDC login is not configured")
+		return
+	}
+
+	// Clearing the state cookie whatever the outcome, a state can only be used once
+	http.SetCookie(w, &http.Cookie{Name: oidcStateCookie, Value: "", Path: "/login/oidc", MaxAge: -1})
+
+	query := r.URL.Query()
+	if providerErr := query.Get("error"); providerErr != "" {
+		problem.Error(w, r, http.StatusUnauthorized, problem.CodeIdentityProvider, "Identity provider login failed: "+providerErr)
+		return
+	}
+
+	state := query.Get("state")
+	cookie, err := r.Cookie(oidcStateCookie)
+	if err != nil || state == "" || cookie.Value != state {
+		problem.Error(w, r, http.StatusBadRequest, problem.CodeInvalidToken, "Invalid or expired login state")
+		return
+	}
+
+	var login oidcLogin
+	err = database.GetCollection("schoogoidcstate").FindOneAndDelete(r.Context(), bson.M{"_id": state}).Decode(&login)
+	if err == mongo.ErrNoDocuments || (err == nil && time.Since(login.CreatedAt) > oidcLoginTTL) {
+		problem.Error(w, r, http.StatusBadRequest, problem.CodeInvalidToken, "Invalid or expired login state")
+		return
+	} else if err != nil {
+		problem.ServerError(w, r, err, "Server error")
+		return
+	}
+
+	rawIDToken, err := oidc.Default.Exchange(r.Context(), query.Get("code"), login.CodeVerifier)
+	if err != nil {
+		middleware.Logger(r.Context()).Error("OIDC code exchange failed", "error", err)
+		problem.Error(w, r, http.StatusUnauthorized, problem.CodeIdentityProvider, "Identity provider login failed")
+		return
+	}
+	claims, err := oidc.Default.VerifyIDToken(r.Context(), rawIDToken, login.Nonce)
+	if err != nil {
+		middleware.Logger(r.Context()).Error("OIDC ID token rejected", "error", err)
+		problem.Error(w, r, http.StatusUnauthorized, problem.CodeIdentityProvider, "Identity provider login failed")
+		return
+	}
+
+	if login.LinkUserID != nil {
+		linkOIDCIdentity(w, r, *login.LinkUserID, oidc.Default.Issuer(), claims)
+		return
+	}
+
+	user, refusal := oidcUser(r.Context(), oidc.Default.Issuer(), claims)
+	if refusal != nil {
+		problem.Write(w, r, refusal)
+		return
+	}
+
+	if login.CookieSession {
+		r = r.WithContext(context.WithValue(r.Context(), cookieSessionContextKey, true))
+	}
+	beginLogin(w, r, user)
+}
+
+// linkOIDCIdentity links the provider account to the user who started linking it.
+func linkOIDCIdentity(w http.ResponseWriter, r *http.Request, userID primitive.ObjectID, issuer string, claims *oidc.IDTokenClaims) {
+	result, err := database.GetCollection("schooguser").UpdateOne(r.Context(),
+		bson.M{"_id": userID, "oidc_subject": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"oidc_issuer": issuer, "oidc_subject": claims.Subject}},
+	)
+	if mongo.IsDuplicateKeyError(err) {
+		problem.Error(w, r, http.StatusConflict, problem.CodeConflict, "Identity is linked to another account")
+		return
+	}
+	if err != nil {
+		problem.ServerError(w, r, err, "Failed to link account")
+		return
+	}
+	if result.MatchedCount == 0 {
+		problem.Error(w, r, http.StatusConflict, problem.CodeConflict, "Account is already linked to an identity provider")
+		return
+	}
+
+	event := audit.FromRequest(r, audit.ActionIdentityLink).On(audit.TargetUser, userID.Hex())
+	event.ActorID = userID.Hex()
+	event.Details = map[string]interface{}{"issuer": issuer, "subject": claims.Subject}
+	audit.Record(r.Context(), event)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]string{
+		"message": "Identity provider account linked",
+	})
+}
+
+// oidcUser finds the user linked to the provider account, or provisions a new user for an
+// unlinked one. An existing account with the same email is never linked here, as the email
+// alone doesn't prove the provider account belongs to its owner. When no user can be used
+// it returns the problem to respond with.
+func oidcUser(ctx context.Context, issuer string, claims *oidc.IDTokenClaims) (*models.User, *problem.Problem) {
+	collection := database.GetCollection("schooguser")
+
+	var user models.User
+	err := collection.FindOne(ctx, bson.M{"oidc_issuer": issuer, "oidc_subject": claims.Subject}).Decode(&user)
+	if err == nil {
+		return &user, nil
+	} else if err != mongo.ErrNoDocuments {
+		return nil, problem.FromError(err, "Server error")
+	}
+
+	// Only trusting emails the provider has verified, otherwise anyone could take over an account
+	email := utils.NormalizeEmail(claims.Email)
+	if email == "" || !claims.EmailVerified {
+		return nil, problem.New(http.StatusForbidden, problem.CodeForbidden, "Email is not verified")
+	}
+
+	err = collection.FindOne(ctx, bson.M{"email": email}).Decode(&user)
+	if err == nil {
+		return nil, problem.New(http.StatusConflict, problem.CodeConflict,
+			"An account with this email already exists; log in and link the identity provider from your profile")
+	} else if err != mongo.ErrNoDocuments {
+		return nil, problem.FromError(err, "Server error")
+	}
+
+	// Provisioning a new user, subject to the same email policy as Register
+	if err := utils.RegistrationEmailPolicy.Validate(ctx, email); err != nil {
+		var policyErr *utils.EmailPolicyError
+		if errors.As(err, &policyErr) {
+			return nil, problem.New(http.StatusForbidden, problem.CodeForbidden, policyErr.Reason)
+		}
+		return nil, problem.FromError(err, "Failed to verify email address")
+	}
+
+	name := strings.TrimSpace(claims.Name)
+	if name == "" {
+		name = email[:strings.Index(email, "@")]
+	}
+	// Provisioned users have no password and can only log in through the provider
+	user = models.User{
+		Name:        name,
+		Email:       email,
+		OIDCIssuer:  issuer,
+		OIDCSubject: claims.Subject,
+	}
+	result, err := collection.InsertOne(ctx, user)
+	if err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return nil, problem.New(http.StatusConflict, problem.CodeConflict, "Account is being created by another login, try again")
+		}
+		return nil, problem.FromError(err, "Failed to create user")
+	}
+	user.ID = result.InsertedID.(primitive.ObjectID)
+	return &user, nil
+}
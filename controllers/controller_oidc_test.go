@@ -0,0 +1,65 @@
+package controller
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/Aman913k/oidc"
+	"github.com/Aman913k/oidc/oidctest"
+)
+
+func TestOIDCCallbackRejectsStateMismatch(t *testing.T) {
+	issuer, server, err := oidctest.NewServer("schooglink", "secret", oidctest.User{Subject: "student-1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+	oidc.Default = oidc.NewProvider(oidc.Config{Issuer: issuer.URL, ClientID: "schooglink", ClientSecret: "secret"})
+	defer func() { oidc.Default = nil }()
+
+	tests := []struct {
+		name   string
+		query  string
+		cookie string
+		want   int
+	}{
+		{"no state cookie", "?code=c&state=s1", "", http.StatusBadRequest},
+		{"state from another browser", "?code=c&state=s1", "s2", http.StatusBadRequest},
+		{"no state", "?code=c", "s1", http.StatusBadRequest},
+		{"provider error", "?error=access_denied&state=s1", "s1", http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/login/oidc/callback"+tt.query, nil)
+			if tt.cookie != "" {
+				r.AddCookie(&http.Cookie{Name: oidcStateCookie, Value: tt.cookie})
+			}
+			w := httptest.NewRecorder()
+			OIDCCallback(w, r)
+
+			if w.Code != tt.want {
+				t.Errorf("status = %d, want %d: %s", w.Code, tt.want, w.Body)
+			}
+			// The state cookie is cleared whatever the outcome
+			if cookies := w.Result().Cookies(); len(cookies) == 0 || cookies[0].Name != oidcStateCookie || cookies[0].MaxAge >= 0 {
+				t.Errorf("state cookie not cleared: %v", cookies)
+			}
+		})
+	}
+}
+
+func TestOIDCNotConfigured(t *testing.T) {
+	for name, handler := range map[string]http.HandlerFunc{
+		"login":    StartOIDCLogin,
+		"callback": OIDCCallback,
+	} {
+		t.Run(name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			handler(w, httptest.NewRequest(http.MethodGet, "/login/oidc", nil))
+			if w.Code != http.StatusNotFound {
+				t.Errorf("status = %d, want %d", w.Code, http.StatusNotFound)
+			}
+		})
+	}
+}
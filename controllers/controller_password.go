@@ -0,0 +1,213 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/Aman913k/audit"
+	"github.com/Aman913k/database"
+	"github.com/Aman913k/mailer"
+	"github.com/Aman913k/middleware"
+	"github.com/Aman913k/models"
+	"github.com/Aman913k/problem"
+	"github.com/Aman913k/utils"
+	"github.com/Aman913k/validate"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const passwordResetPurpose = "password_reset"
+
+// passwordResetTTL is how long a password reset link works
+const passwordResetTTL = 24 * time.Hour
+
+// PasswordResetURL is the frontend page where reset links point, with the token appended as a
+// query parameter. It should post the token and the new password to /password/reset.
+var PasswordResetURL = ""
+
+// ChangePassword changes the authenticated user's password.
+// @Summary Change password
+// @Description Change the logged-in user's password and log out every other session. The new password has to satisfy the password policy; every violated rule is listed in the response.
+// @Tags Profile
+// @Accept json
+// @Produce json
+// @Param passwords body models.ChangePasswordRequest true "Current and new password"
+// @Success 200 {string} string "Password changed successfully"
+// @Failure 400 {object} problem.Problem "Invalid input or password is weak"
+// @Failure 401 {object} problem.Problem "Unauthorized or current password is wrong"
+// @Failure 500 {object} problem.Problem "Failed to change password"
+// @Router /profile/password [put]
+func ChangePassword(w http.ResponseWriter, r *http.Request) {
+	r, span := startSpan(r, "ChangePassword")
+	defer span.End()
+
+	userID, ok := authenticatedUserID(r)
+	if !ok {
+		problem.Error(w, r, http.StatusUnauthorized, problem.CodeUnauthorized, "Unauthorized")
+		return
+	}
+
+	var request models.ChangePasswordRequest
+	if !validate.DecodeJSON(w, r, &request) {
+		return
+	}
+
+	collection := database.GetCollection("schooguser")
+	var user models.User
+	err := collection.FindOne(r.Context(), bson.M{"_id": userID}).Decode(&user)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			problem.Error(w, r, http.StatusUnauthorized, problem.CodeUnauthorized, "Unauthorized")
+		} else {
+			problem.ServerError(w, r, err, "Database error")
+		}
+		return
+	}
+
+	if match, _, _ := utils.Passwords.Verify(user.Password, request.CurrentPassword); !match {
+		problem.Error(w, r, http.StatusUnauthorized, problem.CodeInvalidCredentials, "Current password is wrong")
+		return
+	}
+
+	if violations := utils.DefaultPasswordPolicy.Check(request.NewPassword, user.Name, user.Email); len(violations) > 0 {
+		writeWeakPassword(w, r, "new_password", violations)
+		return
+	}
+
+	hashedPassword, err := models.HashPassword(request.NewPassword)
+	if err != nil {
+		problem.ServerError(w, r, err, "Failed to change password")
+		return
+	}
+
+	_, err = collection.UpdateOne(r.Context(), bson.M{"_id": userID}, bson.M{"$set": bson.M{"password": hashedPassword}})
+	if err != nil {
+		problem.ServerError(w, r, err, "Failed to change password")
+		return
+	}
+
+	// Logging out other devices, which may be where the old password leaked
+	if err := revokeOtherSessions(r, userID); err != nil {
+		middleware.Logger(r.Context()).Error("Failed to revoke sessions after password change", "error", err)
+	}
+	audit.Record(r.Context(), audit.FromRequest(r, audit.ActionPasswordChange).On(audit.TargetUser, userID.Hex()))
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message": "Password changed successfully",
+	})
+}
+
+// writeWeakPassword rejects the password in field, listing every rule of the password policy it breaks.
+func writeWeakPassword(w http.ResponseWriter, r *http.Request, field string, violations []utils.PasswordViolation) {
+	errors := make([]problem.FieldError, len(violations))
+	for i, violation := range violations {
+		errors[i] = problem.FieldError{Field: field, Code: violation.Code, Message: violation.Message}
+	}
+	problem.Invalid(w, r, "Password is weak", errors...)
+}
+
+// sendPasswordResetEmail emails the user a link to set a new password. The user may not be able
+// to log in without it, so it is sent even if ctx is cancelled.
+func sendPasswordResetEmail(ctx context.Context, user *models.User) error {
+	ctx = context.WithoutCancel(ctx)
+	token, err := utils.GenerateActionToken(passwordResetPurpose, user.Email, passwordResetTTL)
+	if err != nil {
+		return err
+	}
+
+	base := PasswordResetURL
+	if base == "" {
+		base = utils.PublicURL + "/password/reset"
+	}
+	link := fmt.Sprintf("%s?token=%s", base, url.QueryEscape(token))
+	return mailer.Default.Send(ctx, mailer.Message{
+		To:      user.Email,
+		Subject: "Reset your password",
+		Body: fmt.Sprintf("An administrator has asked you to choose a new password. "+
+			"Until you do, you can't log in with your current one.\n\n"+
+			"Choose a new password here:\n%s\n\nThe link expires in %s.\n", link, passwordResetTTL),
+	})
+}
+
+// ResetPassword sets a new password using the link from a password reset email.
+// @Summary Reset password
+// @Description Set a new password with the token from a password reset email. The token works until the password has been reset.
+// @Tags Auth
+// @Accept json
+// @Produce json
+// @Param request body models.ResetPasswordRequest true "Reset token and new password"
+// @Success 200 {string} string "Password reset successfully"
+// @Failure 400 {object} problem.Problem "Invalid input, invalid or expired reset token, or password is weak"
+// @Failure 429 {object} problem.Problem "Too many requests"
+// @Failure 500 {object} problem.Problem "Failed to reset password"
+// @Router /password/reset [post]
+func ResetPassword(w http.ResponseWriter, r *http.Request) {
+	r, span := startSpan(r, "ResetPassword")
+	defer span.End()
+
+	var request models.ResetPasswordRequest
+	if !validate.DecodeJSON(w, r, &request) {
+		return
+	}
+
+	claims, err := utils.ValidateActionToken(request.Token, passwordResetPurpose)
+	if err != nil {
+		problem.Error(w, r, http.StatusBadRequest, problem.CodeInvalidToken, "Invalid or expired reset token")
+		return
+	}
+
+	// The token is only good while a reset is pending, which makes it single-use
+	collection := database.GetCollection("schooguser")
+	var user models.User
+	err = collection.FindOne(r.Context(), bson.M{"email": claims.Email, "password_reset_required": true}).Decode(&user)
+	if err == mongo.ErrNoDocuments {
+		problem.Error(w, r, http.StatusBadRequest, problem.CodeInvalidToken, "Invalid or expired reset token")
+		return
+	} else if err != nil {
+		problem.ServerError(w, r, err, "Database error")
+		return
+	}
+
+	if violations := utils.DefaultPasswordPolicy.Check(request.NewPassword, user.Name, user.Email); len(violations) > 0 {
+		writeWeakPassword(w, r, "new_password", violations)
+		return
+	}
+
+	hashedPassword, err := models.HashPassword(request.NewPassword)
+	if err != nil {
+		problem.ServerError(w, r, err, "Failed to reset password")
+		return
+	}
+
+	result, err := collection.UpdateOne(r.Context(),
+		bson.M{"_id": user.ID, "password_reset_required": true},
+		bson.M{
+			"$set":   bson.M{"password": hashedPassword},
+			"$unset": bson.M{"password_reset_required": ""},
+		},
+	)
+	if err != nil {
+		problem.ServerError(w, r, err, "Failed to reset password")
+		return
+	}
+	if result.MatchedCount == 0 {
+		problem.Error(w, r, http.StatusBadRequest, problem.CodeInvalidToken, "Invalid or expired reset token")
+		return
+	}
+
+	event := audit.FromRequest(r, audit.ActionUserPasswordReset).On(audit.TargetUser, user.ID.Hex())
+	event.ActorID, event.ActorEmail = user.ID.Hex(), user.Email
+	audit.Record(r.Context(), event)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message": "Password reset successfully",
+	})
+}
@@ -1,66 +1,87 @@
 package controller
 
 import (
-	"context"
 	"encoding/json"
 	"net/http"
 	"time"
 
+	"github.com/Aman913k/audit"
 	"github.com/Aman913k/database"
+	"github.com/Aman913k/metrics"
 	"github.com/Aman913k/middleware"
 	"github.com/Aman913k/models"
+	"github.com/Aman913k/problem"
+	"github.com/Aman913k/validate"
 	"github.com/gorilla/mux"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 )
 
-
-
 // CreatePost godoc
 // @Summary Create a new post
 // @Description Allows a logged-in user to create a new blog post. The user's email and name are retrieved from the request context.
 // @Tags Posts
 // @Accept json
 // @Produce json
-// @Param post body models.Post true "Post content"
+// @Param post body models.PostRequest true "Post content"
 // @Success 201 {object} map[string]interface{} "Post created successfully"
-// @Failure 400 {string} string "Invalid post data"
-// @Failure 401 {string} string "Unauthorized"
-// @Failure 500 {string} string "Failed to create post"
+// @Failure 400 {object} problem.Problem "Invalid post data"
+// @Failure 401 {object} problem.Problem "Unauthorized"
+// @Failure 429 {object} problem.Problem "Too many requests"
+// @Failure 500 {object} problem.Problem "Failed to create post"
 // @Router /posts/create [post]
 func CreatePost(w http.ResponseWriter, r *http.Request) {
-	// Retrieving user email and name from context
+	r, span := startSpan(r, "CreatePost")
+	defer span.End()
+
+	// Retrieving user ID, email and name from context
+	userID, ok := authenticatedUserID(r)
+	if !ok {
+		problem.Error(w, r, http.StatusUnauthorized, problem.CodeUnauthorized, "Unauthorized")
+		return
+	}
+
 	email, ok := r.Context().Value(middleware.EmailContextKey).(string)
 	if !ok || email == "" {
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		problem.Error(w, r, http.StatusUnauthorized, problem.CodeUnauthorized, "Unauthorized")
 		return
 	}
 
 	name, ok := r.Context().Value(middleware.NameContextKey).(string)
 	if !ok || name == "" {
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		problem.Error(w, r, http.StatusUnauthorized, problem.CodeUnauthorized, "Unauthorized")
 		return
 	}
 
-	var post models.Post
-	err := json.NewDecoder(r.Body).Decode(&post)
-	if err != nil {
-		http.Error(w, "Invalid post data", http.StatusBadRequest)
+	var request models.PostRequest
+	if !validate.DecodeJSON(w, r, &request) {
 		return
 	}
 
-	post.Email = email
-	post.Author = name
-	post.Created_AT = time.Now()
+	// Referencing the author by ID so the post follows later profile changes
+	post := models.Post{
+		AuthorID:   userID,
+		Email:      email,
+		Title:      request.Title,
+		Content:    request.Content,
+		Author:     name,
+		Created_AT: time.Now(),
+	}
 
 	collection := database.GetCollection("schoogpost")
-	insertResult, err := collection.InsertOne(context.TODO(), post)
+	insertResult, err := collection.InsertOne(r.Context(), post)
 	if err != nil {
-		http.Error(w, "Failed to create post", http.StatusInternalServerError)
+		problem.ServerError(w, r, err, "Failed to create post")
 		return
 	}
 
+	metrics.PostsCreated.Inc()
+
+	event := audit.FromRequest(r, audit.ActionPostCreate).On(audit.TargetPost, insertResult.InsertedID.(primitive.ObjectID).Hex())
+	event.After = postSnapshot(&post)
+	audit.Record(r.Context(), event)
+
 	w.WriteHeader(http.StatusCreated)
 	json.NewEncoder(w).Encode(map[string]interface{}{
 		"message": "Post created successfully",
@@ -76,54 +97,62 @@ func CreatePost(w http.ResponseWriter, r *http.Request) {
 // @Produce json
 // @Param post_id query string true "Post ID"
 // @Success 200 {string} string "Post deleted successfully"
-// @Failure 400 {string} string "Post ID is required"
-// @Failure 401 {string} string "Unauthorized"
-// @Failure 404 {string} string "Post not found"
-// @Failure 500 {string} string "Failed to delete post"
+// @Failure 400 {object} problem.Problem "Post ID is required"
+// @Failure 401 {object} problem.Problem "Unauthorized"
+// @Failure 404 {object} problem.Problem "Post not found"
+// @Failure 429 {object} problem.Problem "Too many requests"
+// @Failure 500 {object} problem.Problem "Failed to delete post"
 // @Router /posts [delete]
 func DeletePost(w http.ResponseWriter, r *http.Request) {
-	email, ok := r.Context().Value(middleware.EmailContextKey).(string)
+	r, span := startSpan(r, "DeletePost")
+	defer span.End()
 
-	if !ok || email == "" {
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+	userID, ok := authenticatedUserID(r)
+	if !ok {
+		problem.Error(w, r, http.StatusUnauthorized, problem.CodeUnauthorized, "Unauthorized")
 		return
 	}
 
 	postIDStr := r.URL.Query().Get("post_id")
 
 	if postIDStr == "" {
-		http.Error(w, "Post ID is required", http.StatusBadRequest)
+		problem.Error(w, r, http.StatusBadRequest, problem.CodeInvalidRequest, "Post ID is required")
 		return
 	}
 
 	postID, err := primitive.ObjectIDFromHex(postIDStr)
 	if err != nil {
-		http.Error(w, "Invalid Post ID format", http.StatusBadRequest)
+		problem.Error(w, r, http.StatusBadRequest, problem.CodeInvalidRequest, "Invalid Post ID format")
 		return
 	}
 
 	collection := database.GetCollection("schoogpost")
 
-	filter := bson.M{"_id": postID, "email": email}
+	// Checking ownership by author ID, which unlike the copied email never goes stale
+	filter := bson.M{"_id": postID, "author_id": userID}
 	var post models.Post
 
-	err = collection.FindOne(context.TODO(), filter).Decode(&post)
+	err = collection.FindOne(r.Context(), filter).Decode(&post)
 	if err != nil {
 		if err == mongo.ErrNoDocuments {
-			http.Error(w, "Post not found", http.StatusNotFound)
+			problem.Error(w, r, http.StatusNotFound, problem.CodeNotFound, "Post not found")
 		} else {
-			http.Error(w, "Database error", http.StatusInternalServerError)
+			problem.ServerError(w, r, err, "Database error")
 		}
 		return
 	}
 
 	// Deleting post if found
-	_, err = collection.DeleteOne(context.TODO(), filter)
+	_, err = collection.DeleteOne(r.Context(), filter)
 	if err != nil {
-		http.Error(w, "Failed to delete post", http.StatusInternalServerError)
+		problem.ServerError(w, r, err, "Failed to delete post")
 		return
 	}
 
+	event := audit.FromRequest(r, audit.ActionPostDelete).On(audit.TargetPost, postID.Hex())
+	event.Before = postSnapshot(&post)
+	audit.Record(r.Context(), event)
+
 	w.WriteHeader(http.StatusOK)
 	w.Write([]byte(`{"message": "Post deleted successfully"}`))
 }
@@ -135,30 +164,33 @@ func DeletePost(w http.ResponseWriter, r *http.Request) {
 // @Accept json
 // @Produce json
 // @Success 200 {array} models.Post "List of posts"
-// @Failure 500 {string} string "Failed to fetch posts"
+// @Failure 500 {object} problem.Problem "Failed to fetch posts"
 // @Router /posts [get]
 func GetAllPosts(w http.ResponseWriter, r *http.Request) {
+	r, span := startSpan(r, "GetAllPosts")
+	defer span.End()
+
 	collection := database.GetCollection("schoogpost")
 
-	cursor, err := collection.Find(context.TODO(), bson.D{})
+	cursor, err := collection.Find(r.Context(), bson.D{})
 	if err != nil {
-		http.Error(w, "Failed to fetch posts", http.StatusInternalServerError)
+		problem.ServerError(w, r, err, "Failed to fetch posts")
 		return
 	}
-	defer cursor.Close(context.TODO())
+	defer cursor.Close(r.Context())
 
 	var posts []primitive.M
-	for cursor.Next(context.TODO()) {
+	for cursor.Next(r.Context()) {
 		var post bson.M
 		if err := cursor.Decode(&post); err != nil {
-			http.Error(w, "Failed to decode post", http.StatusInternalServerError)
+			problem.ServerError(w, r, err, "Failed to decode post")
 			return
 		}
 		posts = append(posts, post)
 	}
 
 	if err := cursor.Err(); err != nil {
-		http.Error(w, "Error while reading posts", http.StatusInternalServerError)
+		problem.ServerError(w, r, err, "Error while reading posts")
 		return
 	}
 
@@ -174,29 +206,32 @@ func GetAllPosts(w http.ResponseWriter, r *http.Request) {
 // @Produce json
 // @Param post_id path string true "Post ID"
 // @Success 200 {object} models.Post "Post details"
-// @Failure 400 {string} string "Invalid Post ID format"
-// @Failure 404 {string} string "Post not found"
-// @Failure 500 {string} string "Database error"
+// @Failure 400 {object} problem.Problem "Invalid Post ID format"
+// @Failure 404 {object} problem.Problem "Post not found"
+// @Failure 500 {object} problem.Problem "Database error"
 // @Router /posts/{post_id} [get]
 func GetPostByID(w http.ResponseWriter, r *http.Request) {
+	r, span := startSpan(r, "GetPostByID")
+	defer span.End()
+
 	vars := mux.Vars(r)
 	postIDStr := vars["post_id"]
 
 	// Converting post ID to ObjectID
 	postID, err := primitive.ObjectIDFromHex(postIDStr)
 	if err != nil {
-		http.Error(w, "Invalid Post ID format", http.StatusBadRequest)
+		problem.Error(w, r, http.StatusBadRequest, problem.CodeInvalidRequest, "Invalid Post ID format")
 		return
 	}
 
 	collection := database.GetCollection("schoogpost")
 	var post models.Post
-	err = collection.FindOne(context.TODO(), bson.M{"_id": postID}).Decode(&post)
+	err = collection.FindOne(r.Context(), bson.M{"_id": postID}).Decode(&post)
 	if err != nil {
 		if err == mongo.ErrNoDocuments {
-			http.Error(w, "Post not found", http.StatusNotFound)
+			problem.Error(w, r, http.StatusNotFound, problem.CodeNotFound, "Post not found")
 		} else {
-			http.Error(w, "Database error", http.StatusInternalServerError)
+			problem.ServerError(w, r, err, "Database error")
 		}
 		return
 	}
@@ -212,17 +247,21 @@ func GetPostByID(w http.ResponseWriter, r *http.Request) {
 // @Accept json
 // @Produce json
 // @Param id path string true "Post ID"
-// @Param user body models.Post true "Updated post data"
+// @Param post body models.PostRequest true "Updated post data"
 // @Success 200 {string} string "Post updated successfully"
-// @Failure 400 {string} string "Invalid post data"
-// @Failure 401 {string} string "Unauthorized"
-// @Failure 404 {string} string "Post not found"
-// @Failure 500 {string} string "Failed to update post"
+// @Failure 400 {object} problem.Problem "Invalid post data"
+// @Failure 401 {object} problem.Problem "Unauthorized"
+// @Failure 404 {object} problem.Problem "Post not found"
+// @Failure 429 {object} problem.Problem "Too many requests"
+// @Failure 500 {object} problem.Problem "Failed to update post"
 // @Router /posts/{post_id} [put]
 func UpdatePost(w http.ResponseWriter, r *http.Request) {
-	email, ok := r.Context().Value(middleware.EmailContextKey).(string)
-	if !ok || email == "" {
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+	r, span := startSpan(r, "UpdatePost")
+	defer span.End()
+
+	userID, ok := authenticatedUserID(r)
+	if !ok {
+		problem.Error(w, r, http.StatusUnauthorized, problem.CodeUnauthorized, "Unauthorized")
 		return
 	}
 
@@ -232,42 +271,58 @@ func UpdatePost(w http.ResponseWriter, r *http.Request) {
 	// Converting post ID to ObjectID
 	postID, err := primitive.ObjectIDFromHex(postIDStr)
 	if err != nil {
-		http.Error(w, "Invalid Post ID format", http.StatusBadRequest)
+		problem.Error(w, r, http.StatusBadRequest, problem.CodeInvalidRequest, "Invalid Post ID format")
 		return
 	}
 
 	collection := database.GetCollection("schoogpost")
 	var post models.Post
-	err = collection.FindOne(context.TODO(), bson.M{"_id": postID, "email": email}).Decode(&post)
+	err = collection.FindOne(r.Context(), bson.M{"_id": postID, "author_id": userID}).Decode(&post)
 	if err != nil {
 		if err == mongo.ErrNoDocuments {
-			http.Error(w, "Post not found or unauthorized", http.StatusNotFound)
+			problem.Error(w, r, http.StatusNotFound, problem.CodeNotFound, "Post not found or unauthorized")
 		} else {
-			http.Error(w, "Database error", http.StatusInternalServerError)
+			problem.ServerError(w, r, err, "Database error")
 		}
 		return
 	}
 
-	var updatedPost models.Post
-	err = json.NewDecoder(r.Body).Decode(&updatedPost)
-	if err != nil {
-		http.Error(w, "Invalid post data", http.StatusBadRequest)
+	var request models.PostRequest
+	if !validate.DecodeJSON(w, r, &request) {
 		return
 	}
 
-	post.Title = updatedPost.Title
-	post.Content = updatedPost.Content
+	before := postSnapshot(&post)
+	post.Title = request.Title
+	post.Content = request.Content
 	post.Updated_AT = time.Now()
 
-	_, err = collection.UpdateOne(context.TODO(), bson.M{"_id": postID}, bson.M{"$set": post})
+	// Only setting the edited fields, the author fields are kept up to date by jobs.PropagateAuthor
+	_, err = collection.UpdateOne(r.Context(), bson.M{"_id": postID}, bson.M{"$set": bson.M{
+		"title":      post.Title,
+		"content":    post.Content,
+		"updated_at": post.Updated_AT,
+	}})
 	if err != nil {
-		http.Error(w, "Failed to update post", http.StatusInternalServerError)
+		problem.ServerError(w, r, err, "Failed to update post")
 		return
 	}
 
+	event := audit.FromRequest(r, audit.ActionPostUpdate).On(audit.TargetPost, postID.Hex())
+	event.Before, event.After = before, postSnapshot(&post)
+	audit.Record(r.Context(), event)
+
 	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(map[string]interface{}{
 		"message": "Post updated successfully",
 		"post":    post,
 	})
 }
+
+// postSnapshot returns the user-editable fields of a post for the audit log.
+func postSnapshot(post *models.Post) map[string]interface{} {
+	return map[string]interface{}{
+		"title":   post.Title,
+		"content": post.Content,
+	}
+}
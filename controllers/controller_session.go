@@ -0,0 +1,211 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/Aman913k/database"
+	"github.com/Aman913k/middleware"
+	"github.com/Aman913k/models"
+	"github.com/Aman913k/problem"
+	"github.com/Aman913k/utils"
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// maxUserAgentLength caps how much of the User-Agent header is stored with a session
+const maxUserAgentLength = 512
+
+// createSession records a new login of the user from the requesting device. Cookie sessions
+// store the hash of their CSRF token, which is empty otherwise.
+func createSession(r *http.Request, user *models.User, csrfTokenHash string) (*models.Session, error) {
+	userAgent := r.UserAgent()
+	if len(userAgent) > maxUserAgentLength {
+		userAgent = userAgent[:maxUserAgentLength]
+	}
+
+	now := time.Now()
+	session := &models.Session{
+		UserID:     user.ID,
+		UserAgent:  userAgent,
+		IP:         utils.ClientIP(r),
+		CreatedAt:  now,
+		LastSeenAt: now,
+		ExpiresAt:  now.Add(utils.AccessTokenTTL),
+
+		CSRFTokenHash: csrfTokenHash,
+	}
+	result, err := database.GetCollection("schoogsession").InsertOne(r.Context(), session)
+	if err != nil {
+		return nil, err
+	}
+	session.ID = result.InsertedID.(primitive.ObjectID)
+	return session, nil
+}
+
+// revokeOtherSessions revokes every active session of the user except the one in the request,
+// logging out other devices after a security-relevant change.
+func revokeOtherSessions(r *http.Request, userID primitive.ObjectID) error {
+	return revokeSessions(r.Context(), userID, currentSessionID(r))
+}
+
+// revokeSessions revokes every active session of the user except the given one, if any.
+// It follows changes that have already been made, so it isn't cut short when ctx is cancelled.
+func revokeSessions(ctx context.Context, userID primitive.ObjectID, exceptSessionID string) error {
+	ctx = context.WithoutCancel(ctx)
+	filter := bson.M{"user_id": userID, "revoked_at": bson.M{"$exists": false}}
+	if except, err := primitive.ObjectIDFromHex(exceptSessionID); err == nil {
+		filter["_id"] = bson.M{"$ne": except}
+	}
+	_, err := database.GetCollection("schoogsession").UpdateMany(ctx, filter,
+		bson.M{"$set": bson.M{"revoked_at": time.Now()}},
+	)
+	return err
+}
+
+// currentSessionID returns the session the request was authenticated with, if any.
+func currentSessionID(r *http.Request) string {
+	sessionID, _ := r.Context().Value(middleware.SessionIDContextKey).(string)
+	return sessionID
+}
+
+// ListSessions lists the user's active sessions.
+// @Summary List sessions
+// @Description List the devices the logged-in user is logged in on, most recently active first. The session of the request is marked as current.
+// @Tags Sessions
+// @Produce json
+// @Success 200 {array} models.Session "Active sessions"
+// @Failure 401 {object} problem.Problem "Unauthorized"
+// @Failure 403 {object} problem.Problem "API keys cannot be used here"
+// @Failure 500 {object} problem.Problem "Failed to fetch sessions"
+// @Router /profile/sessions [get]
+func ListSessions(w http.ResponseWriter, r *http.Request) {
+	r, span := startSpan(r, "ListSessions")
+	defer span.End()
+
+	userID, ok := authenticatedUserID(r)
+	if !ok {
+		problem.Error(w, r, http.StatusUnauthorized, problem.CodeUnauthorized, "Unauthorized")
+		return
+	}
+
+	cursor, err := database.GetCollection("schoogsession").Find(r.Context(),
+		bson.M{
+			"user_id":    userID,
+			"revoked_at": bson.M{"$exists": false},
+			"expires_at": bson.M{"$gt": time.Now()},
+		},
+		options.Find().SetSort(bson.D{{Key: "last_seen_at", Value: -1}}),
+	)
+	if err != nil {
+		problem.ServerError(w, r, err, "Failed to fetch sessions")
+		return
+	}
+	defer cursor.Close(r.Context())
+
+	sessions := []models.Session{}
+	if err := cursor.All(r.Context(), &sessions); err != nil {
+		problem.ServerError(w, r, err, "Failed to fetch sessions")
+		return
+	}
+
+	current := currentSessionID(r)
+	for i := range sessions {
+		sessions[i].Current = sessions[i].ID.Hex() == current
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(sessions)
+}
+
+// RevokeSession logs out one of the user's sessions.
+// @Summary Revoke a session
+// @Description Log out the device of the given session. Tokens issued to it stop working immediately.
+// @Tags Sessions
+// @Produce json
+// @Param session_id path string true "Session ID"
+// @Success 200 {string} string "Session revoked"
+// @Failure 400 {object} problem.Problem "Invalid session ID format"
+// @Failure 401 {object} problem.Problem "Unauthorized"
+// @Failure 403 {object} problem.Problem "API keys cannot be used here"
+// @Failure 404 {object} problem.Problem "Session not found"
+// @Failure 500 {object} problem.Problem "Failed to revoke session"
+// @Router /profile/sessions/{session_id} [delete]
+func RevokeSession(w http.ResponseWriter, r *http.Request) {
+	r, span := startSpan(r, "RevokeSession")
+	defer span.End()
+
+	userID, ok := authenticatedUserID(r)
+	if !ok {
+		problem.Error(w, r, http.StatusUnauthorized, problem.CodeUnauthorized, "Unauthorized")
+		return
+	}
+
+	sessionID, err := primitive.ObjectIDFromHex(mux.Vars(r)["session_id"])
+	if err != nil {
+		problem.Error(w, r, http.StatusBadRequest, problem.CodeInvalidRequest, "Invalid session ID format")
+		return
+	}
+
+	result, err := database.GetCollection("schoogsession").UpdateOne(r.Context(),
+		bson.M{"_id": sessionID, "user_id": userID, "revoked_at": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"revoked_at": time.Now()}},
+	)
+	if err != nil {
+		problem.ServerError(w, r, err, "Failed to revoke session")
+		return
+	}
+	if result.MatchedCount == 0 {
+		problem.Error(w, r, http.StatusNotFound, problem.CodeNotFound, "Session not found")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message": "Session revoked",
+	})
+}
+
+// Logout ends the session the request was made with.
+// @Summary Log out
+// @Description Revoke the current session and clear the session cookies.
+// @Tags Sessions
+// @Produce json
+// @Success 200 {string} string "Logged out"
+// @Failure 401 {object} problem.Problem "Unauthorized"
+// @Failure 403 {object} problem.Problem "API keys cannot be used here, or invalid CSRF token"
+// @Failure 500 {object} problem.Problem "Failed to log out"
+// @Router /logout [post]
+func Logout(w http.ResponseWriter, r *http.Request) {
+	r, span := startSpan(r, "Logout")
+	defer span.End()
+
+	userID, ok := authenticatedUserID(r)
+	if !ok {
+		problem.Error(w, r, http.StatusUnauthorized, problem.CodeUnauthorized, "Unauthorized")
+		return
+	}
+
+	if sessionID, err := primitive.ObjectIDFromHex(currentSessionID(r)); err == nil {
+		_, err := database.GetCollection("schoogsession").UpdateOne(r.Context(),
+			bson.M{"_id": sessionID, "user_id": userID, "revoked_at": bson.M{"$exists": false}},
+			bson.M{"$set": bson.M{"revoked_at": time.Now()}},
+		)
+		if err != nil {
+			problem.ServerError(w, r, err, "Failed to log out")
+			return
+		}
+	}
+	middleware.ClearSessionCookies(w)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message": "Logged out",
+	})
+}
@@ -0,0 +1,15 @@
+package controller
+
+import (
+	"net/http"
+
+	"github.com/Aman913k/tracing"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// startSpan starts the span of the handler called name, as a child of the route's span, and
+// returns the request carrying it so that database calls and background work nest under it.
+func startSpan(r *http.Request, name string) (*http.Request, trace.Span) {
+	ctx, span := tracing.Tracer().Start(r.Context(), "controller."+name)
+	return r.WithContext(ctx), span
+}
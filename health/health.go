@@ -0,0 +1,165 @@
+/*
+Package health reports whether the process can serve traffic: readiness checks for
+MongoDB and migrations, the draining flag set during shutdown, and build information.
+*/
+package health
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+	"runtime/debug"
+	"sync/atomic"
+	"time"
+
+	"github.com/Aman913k/database"
+	"github.com/Aman913k/migrations"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+)
+
+// Statuses of checks and reports
+const (
+	StatusOK   = "ok"
+	StatusFail = "fail"
+)
+
+// CheckTimeout bounds how long a single readiness check may take.
+var CheckTimeout = 2 * time.Second
+
+// Version and Commit identify the build. They are set at link time with
+// -ldflags "-X github.com/Aman913k/health.Version=... -X github.com/Aman913k/health.Commit=...";
+// Commit otherwise falls back to the revision Go stamps into binaries built from a checkout.
+var (
+	Version = "dev"
+	Commit  = ""
+)
+
+// startTime is when the process started serving
+var startTime = time.Now()
+
+var draining atomic.Bool
+
+// SetDraining marks the process as shutting down, so that readiness fails and traffic moves elsewhere.
+func SetDraining() {
+	draining.Store(true)
+}
+
+// Draining reports whether the process is shutting down.
+func Draining() bool {
+	return draining.Load()
+}
+
+// Check is the outcome of one readiness check.
+type Check struct {
+	Status     string  `json:"status" example:"ok"`
+	Detail     string  `json:"detail,omitempty" example:"2 migrations pending"`
+	DurationMS float64 `json:"duration_ms"`
+}
+
+// Report is the outcome of every readiness check.
+type Report struct {
+	Status string           `json:"status" example:"ok"`
+	Checks map[string]Check `json:"checks"`
+}
+
+// Ready runs every readiness check. The report's status is ok only when all of them pass.
+func Ready(ctx context.Context) Report {
+	report := Report{Status: StatusOK, Checks: map[string]Check{
+		"mongo":      run(ctx, pingMongo),
+		"migrations": run(ctx, checkMigrations),
+		"draining":   run(ctx, checkDraining),
+	}}
+	for _, check := range report.Checks {
+		if check.Status != StatusOK {
+			report.Status = StatusFail
+		}
+	}
+	return report
+}
+
+// run times a check, giving it at most CheckTimeout.
+func run(ctx context.Context, check func(context.Context) error) Check {
+	ctx, cancel := context.WithTimeout(ctx, CheckTimeout)
+	defer cancel()
+
+	start := time.Now()
+	err := check(ctx)
+	result := Check{Status: StatusOK, DurationMS: float64(time.Since(start).Microseconds()) / 1000}
+	if err != nil {
+		result.Status = StatusFail
+		result.Detail = err.Error()
+	}
+	return result
+}
+
+func pingMongo(ctx context.Context) error {
+	return database.Client().Ping(ctx, readpref.Primary())
+}
+
+func checkMigrations(ctx context.Context) error {
+	statuses, err := migrations.Statuses(ctx)
+	if err != nil {
+		return err
+	}
+	pending := 0
+	for _, status := range statuses {
+		if !status.Applied {
+			pending++
+		}
+	}
+	if pending > 0 {
+		return fmt.Errorf("%d migrations pending", pending)
+	}
+	return nil
+}
+
+func checkDraining(context.Context) error {
+	if Draining() {
+		return fmt.Errorf("shutting down")
+	}
+	return nil
+}
+
+// BuildInfo describes the running build.
+type BuildInfo struct {
+	Version   string    `json:"version" example:"1.4.0"`
+	Commit    string    `json:"commit,omitempty" example:"c67e560"`
+	GoVersion string    `json:"go_version" example:"go1.23.2"`
+	StartTime time.Time `json:"start_time"`
+	Uptime    string    `json:"uptime" example:"3h2m1s"`
+}
+
+// Build returns information about the running build.
+func Build() BuildInfo {
+	return BuildInfo{
+		Version:   Version,
+		Commit:    commit(),
+		GoVersion: runtime.Version(),
+		StartTime: startTime,
+		Uptime:    time.Since(startTime).Round(time.Second).String(),
+	}
+}
+
+// commit returns Commit, or the VCS revision recorded by the Go toolchain, marking uncommitted changes.
+func commit() string {
+	if Commit != "" {
+		return Commit
+	}
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return ""
+	}
+	var revision, modified string
+	for _, setting := range info.Settings {
+		switch setting.Key {
+		case "vcs.revision":
+			revision = setting.Value
+		case "vcs.modified":
+			modified = setting.Value
+		}
+	}
+	if revision != "" && modified == "true" {
+		revision += "-dirty"
+	}
+	return revision
+}
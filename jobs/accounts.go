@@ -0,0 +1,201 @@
+package jobs
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/Aman913k/audit"
+	"github.com/Aman913k/database"
+	"github.com/Aman913k/lockout"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// PostPolicy decides what happens to a user's posts when their account is deleted.
+type PostPolicy string
+
+const (
+	// PostPolicyAnonymise keeps the posts but strips every reference to the author.
+	PostPolicyAnonymise PostPolicy = "anonymise"
+	// PostPolicyDelete deletes the posts along with the account.
+	PostPolicyDelete PostPolicy = "delete"
+)
+
+// DeletedAuthorName replaces the author name on anonymised posts.
+const DeletedAuthorName = "Deleted user"
+
+var (
+	// DeletionGracePeriod is how long a deletion request can be cancelled before the account is purged.
+	DeletionGracePeriod = 30 * 24 * time.Hour
+	// DeletedPostPolicy is applied to the posts of purged accounts.
+	DeletedPostPolicy = PostPolicyAnonymise
+)
+
+// RunAccountPurge deletes accounts whose deletion grace period has ended, checking every interval until ctx is cancelled.
+func RunAccountPurge(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if err := PurgeDeletedAccounts(ctx); err != nil {
+			slog.Error("Failed to purge deleted accounts", "job", "account_purge", "error", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// PurgeDeletedAccounts deletes every account scheduled for deletion before now,
+// applying DeletedPostPolicy to their posts first.
+func PurgeDeletedAccounts(ctx context.Context) (err error) {
+	ctx, span := startSpan(ctx, "PurgeDeletedAccounts")
+	defer func() { endSpan(span, err) }()
+
+	users := database.GetCollection("schooguser")
+
+	cursor, err := users.Find(ctx, bson.M{"deletion_scheduled_at": bson.M{"$lte": time.Now()}})
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		var user struct {
+			ID primitive.ObjectID `bson:"_id"`
+		}
+		if err := cursor.Decode(&user); err != nil {
+			return err
+		}
+
+		if err := PurgeAccount(ctx, user.ID); err != nil {
+			return err
+		}
+	}
+
+	return cursor.Err()
+}
+
+// PurgeAccount deletes an account whose deletion is due with everything stored about it,
+// applying DeletedPostPolicy to its posts. Accounts not due for deletion are left alone.
+// The collections covered here must match the sections of the account export.
+func PurgeAccount(ctx context.Context, userID primitive.ObjectID) (err error) {
+	ctx, span := startSpan(ctx, "PurgeAccount", trace.WithAttributes(attribute.String("user.id", userID.Hex())))
+	defer func() { endSpan(span, err) }()
+
+	users := database.GetCollection("schooguser")
+	posts := database.GetCollection("schoogpost")
+
+	// Marking the account as being purged so the deletion can no longer be cancelled
+	var user struct {
+		Email string `bson:"email"`
+	}
+	err = users.FindOneAndUpdate(ctx,
+		bson.M{"_id": userID, "deletion_scheduled_at": bson.M{"$lte": time.Now()}},
+		bson.M{"$set": bson.M{"deletion_purging": true}},
+		options.FindOneAndUpdate().SetProjection(bson.M{"email": 1}),
+	).Decode(&user)
+	if err == mongo.ErrNoDocuments {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	switch DeletedPostPolicy {
+	case PostPolicyDelete:
+		_, err = posts.DeleteMany(ctx, bson.M{"author_id": userID})
+	default:
+		_, err = posts.UpdateMany(ctx, bson.M{"author_id": userID}, bson.M{
+			"$set":   bson.M{"author": DeletedAuthorName},
+			"$unset": bson.M{"author_id": "", "email": ""},
+		})
+	}
+	if err != nil {
+		return err
+	}
+
+	_, err = database.GetCollection("schoogapikey").DeleteMany(ctx, bson.M{"user_id": userID})
+	if err != nil {
+		return err
+	}
+
+	// Reading the client IPs before the sessions recording them are gone
+	ips, err := SessionIPs(ctx, userID)
+	if err != nil {
+		return err
+	}
+	for _, guard := range lockout.Guards() {
+		if err := guard.Forget(ctx, user.Email, ips); err != nil {
+			return err
+		}
+	}
+
+	_, err = database.GetCollection("schoogsession").DeleteMany(ctx, bson.M{"user_id": userID})
+	if err != nil {
+		return err
+	}
+
+	_, err = database.GetCollection("schoogmagiclink").DeleteMany(ctx, bson.M{"user_id": userID})
+	if err != nil {
+		return err
+	}
+
+	_, err = database.GetCollection("schoogoidcstate").DeleteMany(ctx, bson.M{"link_user_id": userID})
+	if err != nil {
+		return err
+	}
+
+	_, err = database.GetCollection("schoogunlocktoken").DeleteMany(ctx, bson.M{"email": user.Email})
+	if err != nil {
+		return err
+	}
+
+	// Pseudonymising the audit log rather than deleting from it: events keep the account's ID,
+	// which no longer leads to anyone, but lose the email, IP and details that identify the
+	// user. Administrators' actions on the account keep who took them and what they changed.
+	events := database.GetCollection("schoogaudit")
+	_, err = events.UpdateMany(ctx, bson.M{
+		"actor_id":    userID.Hex(),
+		"target_type": audit.TargetUser,
+		"target_id":   userID.Hex(),
+	}, bson.M{"$unset": bson.M{"before": "", "after": "", "details": ""}})
+	if err != nil {
+		return err
+	}
+	_, err = events.UpdateMany(ctx, bson.M{"$or": bson.A{
+		bson.M{"actor_id": userID.Hex()},
+		bson.M{"actor_email": user.Email},
+	}}, bson.M{"$unset": bson.M{"actor_email": "", "ip": ""}})
+	if err != nil {
+		return err
+	}
+
+	// The OIDC link is stored on the user and goes with it
+	_, err = users.DeleteOne(ctx, bson.M{"_id": userID})
+	return err
+}
+
+// SessionIPs returns the distinct client IPs the user's sessions were created from.
+func SessionIPs(ctx context.Context, userID primitive.ObjectID) ([]string, error) {
+	values, err := database.GetCollection("schoogsession").Distinct(ctx, "ip", bson.M{"user_id": userID})
+	if err != nil {
+		return nil, err
+	}
+
+	ips := make([]string, 0, len(values))
+	for _, value := range values {
+		if ip, ok := value.(string); ok && ip != "" {
+			ips = append(ips, ip)
+		}
+	}
+	return ips, nil
+}
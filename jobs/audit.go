@@ -0,0 +1,39 @@
+package jobs
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/Aman913k/audit"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// AuditRetention is how long audit events are kept. Zero keeps them forever.
+var AuditRetention = 365 * 24 * time.Hour
+
+// RunAuditRetention deletes audit events older than AuditRetention, checking every interval until ctx is cancelled.
+func RunAuditRetention(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if AuditRetention > 0 {
+			spanCtx, span := startSpan(ctx, "AuditRetention")
+			deleted, err := audit.Purge(spanCtx, time.Now().Add(-AuditRetention))
+			span.SetAttributes(attribute.Int64("audit.deleted", deleted))
+			endSpan(span, err)
+			if err != nil {
+				slog.Error("Failed to purge audit events", "job", "audit_retention", "error", err)
+			} else if deleted > 0 {
+				slog.Info("Purged old audit events", "job", "audit_retention", "deleted", deleted, "retention", AuditRetention.String())
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
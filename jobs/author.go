@@ -0,0 +1,178 @@
+/*
+Package jobs provides background jobs that run alongside the API server.
+*/
+package jobs
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/Aman913k/database"
+	"github.com/Aman913k/middleware"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// AuthorUpdate describes a change to a user's profile that has to be copied
+// onto the author fields stored in each of their posts.
+type AuthorUpdate struct {
+	UserID primitive.ObjectID
+	Name   string
+	Email  string
+
+	// origin is the span of the request that made the change
+	origin trace.SpanContext
+}
+
+var authorUpdates = make(chan AuthorUpdate, 100)
+
+// EnqueueAuthorUpdate schedules the denormalised author fields of a user's posts to be refreshed.
+// If the queue is full the update is applied synchronously so that it is never lost, even if
+// ctx is cancelled.
+func EnqueueAuthorUpdate(ctx context.Context, update AuthorUpdate) {
+	update.origin = trace.SpanContextFromContext(ctx)
+	select {
+	case authorUpdates <- update:
+	default:
+		if err := PropagateAuthor(context.WithoutCancel(ctx), update); err != nil {
+			middleware.Logger(ctx).Error("Failed to propagate author update", "user_id", update.UserID.Hex(), "error", err)
+		}
+	}
+}
+
+// RunAuthorPropagation applies queued author updates until ctx is cancelled, then applies
+// whatever is still queued so that no update is dropped on shutdown.
+func RunAuthorPropagation(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			drainAuthorUpdates(context.WithoutCancel(ctx))
+			return
+		case update := <-authorUpdates:
+			if err := PropagateAuthor(ctx, update); err != nil {
+				slog.Error("Failed to propagate author update", "job", "author_propagation", "user_id", update.UserID.Hex(), "error", err)
+			}
+		}
+	}
+}
+
+// drainAuthorUpdates applies the updates left in the queue without waiting for new ones.
+func drainAuthorUpdates(ctx context.Context) {
+	for {
+		select {
+		case update := <-authorUpdates:
+			if err := PropagateAuthor(ctx, update); err != nil {
+				slog.Error("Failed to propagate author update", "job", "author_propagation", "user_id", update.UserID.Hex(), "error", err)
+			}
+		default:
+			return
+		}
+	}
+}
+
+// RunAuthorReconcile repairs stale author fields every interval until ctx is cancelled,
+// catching updates that were lost when the process crashed before applying them.
+func RunAuthorReconcile(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		repaired, err := ReconcileAuthors(ctx)
+		if err != nil {
+			slog.Error("Failed to reconcile post authors", "job", "author_reconcile", "error", err)
+		} else if repaired > 0 {
+			slog.Info("Repaired stale author fields", "job", "author_reconcile", "users", repaired)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// ReconcileAuthors finds every user whose posts carry a stale name or email and propagates
+// their current profile, returning how many users needed repairing.
+func ReconcileAuthors(ctx context.Context) (repaired int, err error) {
+	ctx, span := startSpan(ctx, "ReconcileAuthors")
+	defer func() {
+		span.SetAttributes(attribute.Int("authors.repaired", repaired))
+		endSpan(span, err)
+	}()
+
+	cursor, err := database.GetCollection("schoogpost").Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"author_id": bson.M{"$exists": true}}}},
+		{{Key: "$lookup", Value: bson.M{
+			"from":         "schooguser",
+			"localField":   "author_id",
+			"foreignField": "_id",
+			"as":           "user",
+		}}},
+		{{Key: "$unwind", Value: "$user"}},
+		// Matching the same staleness condition PropagateAuthor filters on
+		{{Key: "$match", Value: bson.M{"$expr": bson.M{"$or": bson.A{
+			bson.M{"$ne": bson.A{"$author", "$user.name"}},
+			bson.M{"$ne": bson.A{"$email", "$user.email"}},
+		}}}}},
+		{{Key: "$group", Value: bson.M{
+			"_id":   "$author_id",
+			"name":  bson.M{"$first": "$user.name"},
+			"email": bson.M{"$first": "$user.email"},
+		}}},
+	})
+	if err != nil {
+		return 0, err
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		var stale struct {
+			UserID primitive.ObjectID `bson:"_id"`
+			Name   string             `bson:"name"`
+			Email  string             `bson:"email"`
+		}
+		if err := cursor.Decode(&stale); err != nil {
+			return repaired, err
+		}
+
+		update := AuthorUpdate{UserID: stale.UserID, Name: stale.Name, Email: stale.Email}
+		if err := PropagateAuthor(ctx, update); err != nil {
+			return repaired, err
+		}
+		repaired++
+	}
+
+	return repaired, cursor.Err()
+}
+
+// PropagateAuthor copies the user's current name and email onto every post referencing them.
+// Queued updates run in a trace of their own, linked to the request that made the change.
+func PropagateAuthor(ctx context.Context, update AuthorUpdate) (err error) {
+	opts := []trace.SpanStartOption{trace.WithAttributes(attribute.String("user.id", update.UserID.Hex()))}
+	if update.origin.IsValid() && !trace.SpanContextFromContext(ctx).IsValid() {
+		opts = append(opts, trace.WithLinks(trace.Link{SpanContext: update.origin}))
+	}
+	ctx, span := startSpan(ctx, "PropagateAuthor", opts...)
+	defer func() { endSpan(span, err) }()
+
+	collection := database.GetCollection("schoogpost")
+
+	// Only touching posts whose copy is actually stale keeps repeated updates cheap
+	filter := bson.M{
+		"author_id": update.UserID,
+		"$or": bson.A{
+			bson.M{"author": bson.M{"$ne": update.Name}},
+			bson.M{"email": bson.M{"$ne": update.Email}},
+		},
+	}
+	_, err = collection.UpdateMany(ctx, filter, bson.M{"$set": bson.M{
+		"author": update.Name,
+		"email":  update.Email,
+	}})
+	return err
+}
@@ -0,0 +1,24 @@
+package jobs
+
+import (
+	"context"
+
+	"github.com/Aman913k/tracing"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// startSpan starts the span of a job run or one of its steps. Runs started by a ticker have
+// no parent, so each of them is a trace of its own.
+func startSpan(ctx context.Context, name string, opts ...trace.SpanStartOption) (context.Context, trace.Span) {
+	return tracing.Tracer().Start(ctx, "jobs."+name, opts...)
+}
+
+// endSpan ends span, marking it failed with err unless err is nil.
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
@@ -0,0 +1,245 @@
+/*
+Package lockout tracks failed login attempts per account and per client IP, slowing
+down repeated failures with exponential backoff and locking accounts that keep failing.
+*/
+package lockout
+
+import (
+	"context"
+	"time"
+)
+
+// Attempts is the failure history stored for a key.
+type Attempts struct {
+	Failures    int       `json:"failures" bson:"failures"`
+	LastFailure time.Time `json:"last_failure" bson:"last_failure"`
+}
+
+// Store persists failed attempts. Implementations must make AddFailure atomic
+// so that concurrent failures are all counted.
+type Store interface {
+	// Get returns the attempts recorded for key, or zero Attempts if there are none.
+	Get(ctx context.Context, key string) (Attempts, error)
+	// AddFailure records a failed attempt at the given time and returns the updated attempts.
+	AddFailure(ctx context.Context, key string, at time.Time) (Attempts, error)
+	// Reset forgets every attempt recorded for key.
+	Reset(ctx context.Context, key string) error
+}
+
+// Policy describes how failures for one kind of key are throttled.
+type Policy struct {
+	// FreeAttempts are allowed before any backoff applies
+	FreeAttempts int
+	// BaseDelay is the wait after the first failure beyond the free ones, doubling with each further failure up to MaxDelay
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+	// LockoutThreshold failures lock the key for LockoutDuration; zero disables lockout
+	LockoutThreshold int
+	LockoutDuration  time.Duration
+	// ResetAfter is how long without failures before the count starts over
+	ResetAfter time.Duration
+}
+
+// Decision is the outcome of checking whether an attempt may proceed.
+type Decision struct {
+	Allowed bool
+	// Locked is set when the account is locked rather than merely backing off
+	Locked     bool
+	RetryAfter time.Duration
+}
+
+// Guard applies the account and IP policies on top of a Store.
+type Guard struct {
+	Store   Store
+	Account Policy
+	IP      Policy
+	// Namespace prefixes the stored keys so that several guards can share a Store
+	Namespace string
+}
+
+// NewGuard creates a Guard with the default policies: accounts back off after 3
+// failures and lock for 30 minutes after 10, IPs back off after 20 failures.
+func NewGuard(store Store) *Guard {
+	return &Guard{
+		Store: store,
+		Account: Policy{
+			FreeAttempts:     3,
+			BaseDelay:        time.Second,
+			MaxDelay:         5 * time.Minute,
+			LockoutThreshold: 10,
+			LockoutDuration:  30 * time.Minute,
+			ResetAfter:       24 * time.Hour,
+		},
+		IP: Policy{
+			FreeAttempts: 20,
+			BaseDelay:    time.Second,
+			MaxDelay:     15 * time.Minute,
+			ResetAfter:   time.Hour,
+		},
+	}
+}
+
+// LoginGuard protects Login. It keeps attempts in memory unless main configures a shared store.
+var LoginGuard = NewGuard(NewMemoryStore())
+
+// MagicLinkGuard throttles requests for sign-in links, where every request counts as an
+// attempt: an address gets 3 links before having to wait, a client 10.
+var MagicLinkGuard = &Guard{
+	Store:     NewMemoryStore(),
+	Namespace: "magic:",
+	Account: Policy{
+		FreeAttempts: 3,
+		BaseDelay:    time.Minute,
+		MaxDelay:     15 * time.Minute,
+		ResetAfter:   time.Hour,
+	},
+	IP: Policy{
+		FreeAttempts: 10,
+		BaseDelay:    time.Minute,
+		MaxDelay:     time.Hour,
+		ResetAfter:   time.Hour,
+	},
+}
+
+// Guards returns every guard that stores attempts about accounts.
+func Guards() []*Guard {
+	return []*Guard{LoginGuard, MagicLinkGuard}
+}
+
+func (g *Guard) accountKey(email string) string { return g.Namespace + "account:" + email }
+func (g *Guard) ipKey(ip string) string         { return g.Namespace + "ip:" + ip }
+
+// Check decides whether a login attempt for email from ip may proceed.
+func (g *Guard) Check(ctx context.Context, email, ip string) (Decision, error) {
+	now := time.Now()
+
+	account, err := g.Store.Get(ctx, g.accountKey(email))
+	if err != nil {
+		return Decision{}, err
+	}
+	if d := g.Account.decide(account, now); !d.Allowed {
+		return d, nil
+	}
+
+	addr, err := g.Store.Get(ctx, g.ipKey(ip))
+	if err != nil {
+		return Decision{}, err
+	}
+	return g.IP.decide(addr, now), nil
+}
+
+// Fail records a failed login attempt. It reports whether this failure locked the account,
+// so that the owner can be told how to unlock it.
+func (g *Guard) Fail(ctx context.Context, email, ip string) (bool, error) {
+	now := time.Now()
+
+	account, err := g.addFailure(ctx, g.accountKey(email), g.Account, now)
+	if err != nil {
+		return false, err
+	}
+	if _, err := g.addFailure(ctx, g.ipKey(ip), g.IP, now); err != nil {
+		return false, err
+	}
+
+	return g.Account.LockoutThreshold > 0 && account.Failures == g.Account.LockoutThreshold, nil
+}
+
+// Succeed clears the account's failures after a successful login.
+// IP failures are kept so that logging into one account doesn't reset guessing at others.
+func (g *Guard) Succeed(ctx context.Context, email string) error {
+	return g.Store.Reset(ctx, g.accountKey(email))
+}
+
+// Unlock clears an account's failures and lockout.
+func (g *Guard) Unlock(ctx context.Context, email string) error {
+	return g.Store.Reset(ctx, g.accountKey(email))
+}
+
+// Records returns the attempts stored about the account with email and about the given
+// client IPs, by key, for exporting what is stored about an account.
+func (g *Guard) Records(ctx context.Context, email string, ips []string) (map[string]Attempts, error) {
+	records := make(map[string]Attempts)
+	for _, key := range g.keys(email, ips) {
+		attempts, err := g.Store.Get(ctx, key)
+		if err != nil {
+			return nil, err
+		}
+		if attempts.Failures > 0 {
+			records[key] = attempts
+		}
+	}
+	return records, nil
+}
+
+// Forget deletes the attempts stored about the account with email and about the given client IPs.
+func (g *Guard) Forget(ctx context.Context, email string, ips []string) error {
+	for _, key := range g.keys(email, ips) {
+		if err := g.Store.Reset(ctx, key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (g *Guard) keys(email string, ips []string) []string {
+	keys := []string{g.accountKey(email)}
+	for _, ip := range ips {
+		keys = append(keys, g.ipKey(ip))
+	}
+	return keys
+}
+
+func (g *Guard) addFailure(ctx context.Context, key string, policy Policy, now time.Time) (Attempts, error) {
+	current, err := g.Store.Get(ctx, key)
+	if err != nil {
+		return Attempts{}, err
+	}
+	if current.Failures > 0 && policy.expired(current, now) {
+		if err := g.Store.Reset(ctx, key); err != nil {
+			return Attempts{}, err
+		}
+	}
+	return g.Store.AddFailure(ctx, key, now)
+}
+
+func (p Policy) decide(attempts Attempts, now time.Time) Decision {
+	if attempts.Failures == 0 || p.expired(attempts, now) {
+		return Decision{Allowed: true}
+	}
+
+	if p.LockoutThreshold > 0 && attempts.Failures >= p.LockoutThreshold {
+		until := attempts.LastFailure.Add(p.LockoutDuration)
+		return Decision{Locked: true, RetryAfter: until.Sub(now)}
+	}
+
+	if until := attempts.LastFailure.Add(p.delay(attempts.Failures)); now.Before(until) {
+		return Decision{RetryAfter: until.Sub(now)}
+	}
+	return Decision{Allowed: true}
+}
+
+// expired reports whether the recorded failures no longer count, either because the key
+// has been quiet for ResetAfter or because its lockout has run out.
+func (p Policy) expired(attempts Attempts, now time.Time) bool {
+	if p.ResetAfter > 0 && now.Sub(attempts.LastFailure) > p.ResetAfter {
+		return true
+	}
+	return p.LockoutThreshold > 0 && attempts.Failures >= p.LockoutThreshold &&
+		!now.Before(attempts.LastFailure.Add(p.LockoutDuration))
+}
+
+func (p Policy) delay(failures int) time.Duration {
+	excess := failures - p.FreeAttempts
+	if excess <= 0 {
+		return 0
+	}
+
+	delay := p.BaseDelay
+	for i := 1; i < excess && delay < p.MaxDelay; i++ {
+		delay *= 2
+	}
+	if delay > p.MaxDelay {
+		delay = p.MaxDelay
+	}
+	return delay
+}
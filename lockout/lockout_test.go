@@ -0,0 +1,150 @@
+package lockout
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestPolicyDelay(t *testing.T) {
+	policy := Policy{FreeAttempts: 3, BaseDelay: time.Second, MaxDelay: 5 * time.Second}
+
+	tests := []struct {
+		failures int
+		want     time.Duration
+	}{
+		{0, 0},
+		{3, 0},
+		{4, time.Second},
+		{5, 2 * time.Second},
+		{6, 4 * time.Second},
+		{7, 5 * time.Second},
+		{100, 5 * time.Second},
+	}
+	for _, tt := range tests {
+		if got := policy.delay(tt.failures); got != tt.want {
+			t.Errorf("delay(%d) = %s, want %s", tt.failures, got, tt.want)
+		}
+	}
+}
+
+func TestPolicyDecide(t *testing.T) {
+	policy := NewGuard(nil).Account
+	now := time.Now()
+
+	tests := []struct {
+		name     string
+		attempts Attempts
+		allowed  bool
+		locked   bool
+	}{
+		{"no failures", Attempts{}, true, false},
+		{"free attempts", Attempts{Failures: 3, LastFailure: now}, true, false},
+		{"backing off", Attempts{Failures: 4, LastFailure: now}, false, false},
+		{"backoff over", Attempts{Failures: 4, LastFailure: now.Add(-2 * time.Second)}, true, false},
+		{"locked", Attempts{Failures: 10, LastFailure: now.Add(-time.Minute)}, false, true},
+		{"lockout over", Attempts{Failures: 10, LastFailure: now.Add(-31 * time.Minute)}, true, false},
+		{"quiet for a day", Attempts{Failures: 9, LastFailure: now.Add(-25 * time.Hour)}, true, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := policy.decide(tt.attempts, now)
+			if d.Allowed != tt.allowed || d.Locked != tt.locked {
+				t.Errorf("decide() = %+v, want allowed %v, locked %v", d, tt.allowed, tt.locked)
+			}
+			if !d.Allowed && d.RetryAfter <= 0 {
+				t.Errorf("refused without a RetryAfter: %+v", d)
+			}
+		})
+	}
+}
+
+func TestGuardLocksAccount(t *testing.T) {
+	ctx := context.Background()
+	guard := NewGuard(NewMemoryStore())
+
+	for i := 1; i <= 10; i++ {
+		locked, err := guard.Fail(ctx, "user@example.com", "198.51.100.1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		// Only the failure reaching the threshold reports the lock, so the owner is emailed once
+		if locked != (i == 10) {
+			t.Errorf("failure %d: locked = %v", i, locked)
+		}
+	}
+
+	d, err := guard.Check(ctx, "user@example.com", "203.0.113.9")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.Allowed || !d.Locked {
+		t.Errorf("Check() after 10 failures = %+v, want locked", d)
+	}
+
+	// Other accounts are unaffected by one account's lockout
+	if d, _ := guard.Check(ctx, "other@example.com", "203.0.113.9"); !d.Allowed {
+		t.Errorf("Check() for another account = %+v, want allowed", d)
+	}
+
+	if err := guard.Unlock(ctx, "user@example.com"); err != nil {
+		t.Fatal(err)
+	}
+	if d, _ := guard.Check(ctx, "user@example.com", "203.0.113.9"); !d.Allowed {
+		t.Errorf("Check() after Unlock = %+v, want allowed", d)
+	}
+}
+
+func TestGuardThrottlesIP(t *testing.T) {
+	ctx := context.Background()
+	guard := NewGuard(NewMemoryStore())
+
+	// Guessing at many accounts from one client
+	for i := 0; i < 21; i++ {
+		if _, err := guard.Fail(ctx, fmt.Sprintf("user%d@example.com", i), "198.51.100.1"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if d, _ := guard.Check(ctx, "fresh@example.com", "198.51.100.1"); d.Allowed {
+		t.Error("Check() from a client over its free attempts was allowed")
+	}
+	if d, _ := guard.Check(ctx, "fresh@example.com", "203.0.113.9"); !d.Allowed {
+		t.Errorf("Check() from another client = %+v, want allowed", d)
+	}
+
+	// A successful login clears the account but not the client's failures
+	if err := guard.Succeed(ctx, "user0@example.com"); err != nil {
+		t.Fatal(err)
+	}
+	if d, _ := guard.Check(ctx, "user0@example.com", "198.51.100.1"); d.Allowed {
+		t.Error("Succeed() reset the client's failures")
+	}
+}
+
+func TestGuardRecordsAndForget(t *testing.T) {
+	ctx := context.Background()
+	guard := NewGuard(NewMemoryStore())
+	guard.Namespace = "test:"
+
+	if _, err := guard.Fail(ctx, "user@example.com", "198.51.100.1"); err != nil {
+		t.Fatal(err)
+	}
+
+	records, err := guard.Records(ctx, "user@example.com", []string{"198.51.100.1", "203.0.113.9"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 2 || records["test:account:user@example.com"].Failures != 1 || records["test:ip:198.51.100.1"].Failures != 1 {
+		t.Errorf("Records() = %v, want one failure for the account and one for its client", records)
+	}
+
+	if err := guard.Forget(ctx, "user@example.com", []string{"198.51.100.1"}); err != nil {
+		t.Fatal(err)
+	}
+	records, _ = guard.Records(ctx, "user@example.com", []string{"198.51.100.1"})
+	if len(records) != 0 {
+		t.Errorf("Records() after Forget = %v, want none", records)
+	}
+}
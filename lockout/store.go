@@ -0,0 +1,91 @@
+package lockout
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/Aman913k/database"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// MemoryStore keeps attempts in process memory. It suits a single instance;
+// use MongoStore when several instances share the load.
+type MemoryStore struct {
+	mu        sync.Mutex
+	attempts  map[string]Attempts
+	lastPrune time.Time
+}
+
+// NewMemoryStore creates an empty MemoryStore.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{attempts: make(map[string]Attempts)}
+}
+
+func (s *MemoryStore) Get(ctx context.Context, key string) (Attempts, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.attempts[key], nil
+}
+
+func (s *MemoryStore) AddFailure(ctx context.Context, key string, at time.Time) (Attempts, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Dropping entries nobody has failed on for a day so the map doesn't grow forever
+	if at.Sub(s.lastPrune) > time.Minute {
+		for k, a := range s.attempts {
+			if at.Sub(a.LastFailure) > 24*time.Hour {
+				delete(s.attempts, k)
+			}
+		}
+		s.lastPrune = at
+	}
+
+	a := s.attempts[key]
+	a.Failures++
+	a.LastFailure = at
+	s.attempts[key] = a
+	return a, nil
+}
+
+func (s *MemoryStore) Reset(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.attempts, key)
+	return nil
+}
+
+// MongoStore keeps attempts in the schooglockout collection, shared by every instance.
+// A TTL index on last_failure removes stale entries.
+type MongoStore struct{}
+
+func (MongoStore) collection() *mongo.Collection {
+	return database.GetCollection("schooglockout")
+}
+
+func (s MongoStore) Get(ctx context.Context, key string) (Attempts, error) {
+	var a Attempts
+	err := s.collection().FindOne(ctx, bson.M{"_id": key}).Decode(&a)
+	if err == mongo.ErrNoDocuments {
+		return Attempts{}, nil
+	}
+	return a, err
+}
+
+func (s MongoStore) AddFailure(ctx context.Context, key string, at time.Time) (Attempts, error) {
+	var a Attempts
+	err := s.collection().FindOneAndUpdate(ctx,
+		bson.M{"_id": key},
+		bson.M{"$inc": bson.M{"failures": 1}, "$set": bson.M{"last_failure": at}},
+		options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After),
+	).Decode(&a)
+	return a, err
+}
+
+func (s MongoStore) Reset(ctx context.Context, key string) error {
+	_, err := s.collection().DeleteOne(ctx, bson.M{"_id": key})
+	return err
+}
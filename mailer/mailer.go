@@ -0,0 +1,79 @@
+/*
+Package mailer sends transactional emails such as account unlock links.
+*/
+package mailer
+
+import (
+	"context"
+	"fmt"
+	"net/smtp"
+	"regexp"
+	"strings"
+
+	"github.com/Aman913k/middleware"
+)
+
+// Message is a plain-text email.
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer delivers messages.
+type Mailer interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+// Default is the mailer used by the API. It logs messages until an SMTP server is configured.
+var Default Mailer = LogMailer{}
+
+// LogMailer writes messages to the log instead of sending them, for development. Tokens in
+// the links of messages are redacted, since anyone reading the logs could use them.
+type LogMailer struct{}
+
+func (LogMailer) Send(ctx context.Context, msg Message) error {
+	middleware.Logger(ctx).Info("Mail", "to", msg.To, "subject", msg.Subject, "body", redactTokens(msg.Body))
+	return nil
+}
+
+// linkToken matches the token query parameter of links
+var linkToken = regexp.MustCompile(`([?&]token=)[^&\s]+`)
+
+// redactTokens hides the tokens of the sign-in, unlock and password reset links in text.
+func redactTokens(text string) string {
+	return linkToken.ReplaceAllString(text, "${1}REDACTED")
+}
+
+// SMTPMailer sends messages through an SMTP server.
+type SMTPMailer struct {
+	// Addr is the host:port of the server
+	Addr string
+	From string
+	// Auth may be nil for servers that accept unauthenticated mail
+	Auth smtp.Auth
+}
+
+// NewSMTPMailer creates an SMTPMailer using PLAIN authentication when a username is given.
+func NewSMTPMailer(addr, from, username, password string) *SMTPMailer {
+	m := &SMTPMailer{Addr: addr, From: from}
+	if username != "" {
+		host := addr
+		if i := strings.LastIndex(addr, ":"); i >= 0 {
+			host = addr[:i]
+		}
+		m.Auth = smtp.PlainAuth("", username, password, host)
+	}
+	return m
+}
+
+func (m *SMTPMailer) Send(ctx context.Context, msg Message) error {
+	// Rejecting header injection through the recipient or subject
+	if strings.ContainsAny(msg.To+msg.Subject, "\r\n") {
+		return fmt.Errorf("invalid mail header")
+	}
+
+	body := fmt.Sprintf("From: %s\r\nTo: %s\r\nSubject: %s\r\nContent-Type: text/plain; charset=UTF-8\r\n\r\n%s",
+		m.From, msg.To, msg.Subject, msg.Body)
+	return smtp.SendMail(m.Addr, m.Auth, m.From, []string{msg.To}, []byte(body))
+}
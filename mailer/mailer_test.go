@@ -0,0 +1,22 @@
+package mailer
+
+import "testing"
+
+func TestRedactTokens(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{
+			"Open this link to sign in:\nhttp://localhost:5000/login/magic/verify?token=abc-DEF_123\n\nIt expires soon.",
+			"Open this link to sign in:\nhttp://localhost:5000/login/magic/verify?token=REDACTED\n\nIt expires soon.",
+		},
+		{"https://app.test/reset?lang=en&token=a%2Bb&next=/", "https://app.test/reset?lang=en&token=REDACTED&next=/"},
+		{"https://app.test/posts?page=2", "https://app.test/posts?page=2"},
+		{"No links here, not even a csrf_token=abc", "No links here, not even a csrf_token=abc"},
+	}
+	for _, tt := range tests {
+		if got := redactTokens(tt.in); got != tt.want {
+			t.Errorf("redactTokens(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
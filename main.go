@@ -1,12 +1,29 @@
 package main
 
 import (
-	"fmt"
+	"context"
 	"log"
+	"log/slog"
 	"net/http"
 	"os"
+	"strings"
+	"strconv"
+	"sync"
+	"time"
 
+	controller "github.com/Aman913k/controllers"
+	"github.com/Aman913k/database"
+	"github.com/Aman913k/jobs"
+	"github.com/Aman913k/lockout"
+	"github.com/Aman913k/mailer"
+	"github.com/Aman913k/middleware"
+	"github.com/Aman913k/migrations"
+	"github.com/Aman913k/oidc"
+	"github.com/Aman913k/ratelimit"
 	"github.com/Aman913k/routes"
+	"github.com/Aman913k/tracing"
+	"github.com/Aman913k/utils"
+	"github.com/Aman913k/validate"
 	"github.com/joho/godotenv"
 )
 
@@ -17,23 +34,312 @@ func main() {
 		log.Fatal("Error loading .env file: ", err)
 	}
 
-	mongoURI := os.Getenv("MONGO_URI")
-	if mongoURI == "" {
-		log.Println("Warning: MONGO_URI not set, using default MongoDB URI")
-		mongoURI = "mongodb://localhost:27017" 
+	// Logging in structured form; the log package writes through the same handler
+	var level slog.Level
+	if value := os.Getenv("LOG_LEVEL"); value != "" {
+		if err := level.UnmarshalText([]byte(value)); err != nil {
+			log.Fatal("Error parsing LOG_LEVEL: ", err)
+		}
+	}
+	handlerOptions := &slog.HandlerOptions{Level: level}
+	switch format := os.Getenv("LOG_FORMAT"); format {
+	case "", "json":
+		slog.SetDefault(slog.New(slog.NewJSONHandler(os.Stderr, handlerOptions)))
+	case "text":
+		slog.SetDefault(slog.New(slog.NewTextHandler(os.Stderr, handlerOptions)))
+	default:
+		log.Fatal("Error: LOG_FORMAT must be \"json\" or \"text\"")
+	}
+
+	// Tracing requests and MongoDB commands when an exporter is configured
+	if err := tracing.Setup(context.Background(), os.Getenv("TRACING_EXPORTER")); err != nil {
+		log.Fatal("Error setting up tracing: ", err)
+	}
+
+	if mongoURI := os.Getenv("MONGO_URI"); mongoURI != "" {
+		database.URI = mongoURI
+	} else {
+		log.Println("Warning: MONGO_URI not set, using", database.URI)
 	}
 
 	jwtSecret := os.Getenv("JWT_SECRET")
 	if jwtSecret == "" {
 		log.Fatal("Error: JWT_SECRET environment variable is required")
 	}
+	utils.SigningKey = []byte(jwtSecret)
+
+	// Bounding database operations, which are also cancelled when their client goes away
+	database.OperationTimeout = envDuration("DB_OPERATION_TIMEOUT", database.OperationTimeout)
 
-	log.Println("Mongo URI:", mongoURI)
 	log.Println("JWT Secret loaded successfully.")
 
-	r := routes.Router()
-	fmt.Println("Server is getting Started...")
+	// Configuring links in emails and client IP detection
+	if publicURL := os.Getenv("PUBLIC_URL"); publicURL != "" {
+		utils.PublicURL = strings.TrimSuffix(publicURL, "/")
+	}
+	utils.TrustedProxies, err = utils.ParseProxies(os.Getenv("TRUSTED_PROXIES"))
+	if err != nil {
+		log.Fatal("Error parsing TRUSTED_PROXIES: ", err)
+	}
+
+	// Limiting the size of request bodies
+	validate.MaxBodyBytes = int64(envInt("MAX_BODY_BYTES", int(validate.MaxBodyBytes)))
+
+	// Sending mail through SMTP when configured, otherwise mails are only logged
+	if smtpAddr := os.Getenv("SMTP_ADDR"); smtpAddr != "" {
+		mailer.Default = mailer.NewSMTPMailer(smtpAddr, os.Getenv("MAIL_FROM"), os.Getenv("SMTP_USERNAME"), os.Getenv("SMTP_PASSWORD"))
+	}
+
+	// Enabling login through the school's identity provider when configured
+	if issuer := os.Getenv("OIDC_ISSUER"); issuer != "" {
+		redirectURL := os.Getenv("OIDC_REDIRECT_URL")
+		if redirectURL == "" {
+			redirectURL = utils.PublicURL + "/login/oidc/callback"
+		}
+		oidc.Default = oidc.NewProvider(oidc.Config{
+			Issuer:       issuer,
+			ClientID:     os.Getenv("OIDC_CLIENT_ID"),
+			ClientSecret: os.Getenv("OIDC_CLIENT_SECRET"),
+			RedirectURL:  redirectURL,
+		})
+	}
+
+	// Pointing sign-in and password reset links at the frontend when it handles them
+	controller.MagicLinkURL = os.Getenv("MAGIC_LINK_URL")
+	controller.PasswordResetURL = os.Getenv("PASSWORD_RESET_URL")
+	controller.LoginRedirectURL = os.Getenv("LOGIN_REDIRECT_URL")
+
+	// Letting browser clients keep their session in cookies
+	middleware.CookieSessions = os.Getenv("COOKIE_SESSIONS") == "true"
+	middleware.CookieSecure = os.Getenv("COOKIE_SECURE") != "false"
+	switch sameSite := os.Getenv("COOKIE_SAMESITE"); sameSite {
+	case "", "lax":
+	case "strict":
+		middleware.CookieSameSite = http.SameSiteStrictMode
+	case "none":
+		middleware.CookieSameSite = http.SameSiteNoneMode
+	default:
+		log.Fatal("Error: COOKIE_SAMESITE must be \"lax\", \"strict\" or \"none\"")
+	}
+	// The built-in confirmation page logs in with a cookie, so without cookies links need a frontend page
+	if !middleware.CookieSessions && controller.MagicLinkURL == "" {
+		log.Println("Warning: sign-in links won't work until COOKIE_SESSIONS is enabled or MAGIC_LINK_URL is set")
+	}
+
+	// Sharing login failures and sign-in link requests between instances through MongoDB unless told to keep them in memory
+	if os.Getenv("LOCKOUT_STORE") != "memory" {
+		lockout.LoginGuard.Store = lockout.MongoStore{}
+		lockout.MagicLinkGuard.Store = lockout.MongoStore{}
+	}
+
+	// Sharing rate limits between instances through MongoDB unless told to keep them in memory
+	switch store := os.Getenv("RATE_LIMIT_STORE"); store {
+	case "", "mongo":
+		for _, limiter := range []*ratelimit.Limiter{ratelimit.Auth, ratelimit.Registration, ratelimit.Posts} {
+			limiter.Store = ratelimit.MongoStore{}
+		}
+	case "memory":
+	default:
+		log.Fatalf("Error: RATE_LIMIT_STORE must be \"mongo\" or \"memory\", not %q", store)
+	}
+	if value := os.Getenv("RATE_LIMIT_FAIL_OPEN"); value != "" {
+		middleware.RateLimitFailOpen, err = strconv.ParseBool(value)
+		if err != nil {
+			log.Fatal("Error parsing RATE_LIMIT_FAIL_OPEN: ", err)
+		}
+	}
+
+	// Configuring the rate limits of route groups
+	for name, limiter := range map[string]*ratelimit.Limiter{
+		"RATE_LIMIT_AUTH":     ratelimit.Auth,
+		"RATE_LIMIT_REGISTER": ratelimit.Registration,
+		"RATE_LIMIT_POSTS":    ratelimit.Posts,
+	} {
+		if value := os.Getenv(name); value != "" {
+			limiter.Policy, err = ratelimit.ParsePolicy(value)
+			if err != nil {
+				log.Fatalf("Error parsing %s: %v", name, err)
+			}
+		}
+	}
+
+	// Running a CLI subcommand instead of the server when requested
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "migrate":
+			runMigrate(os.Args[2:])
+		case "unlock":
+			runUnlock(os.Args[2:])
+		case "set-role":
+			runSetRole(os.Args[2:])
+		case "mock-oidc":
+			runMockOIDC(os.Args[2:])
+		default:
+			log.Fatal("Unknown command: ", os.Args[1])
+		}
+		return
+	}
+
+	// Applying pending migrations on startup when enabled, other instances wait on the migration lock
+	if os.Getenv("MIGRATE_ON_START") == "true" {
+		applied, err := migrations.Up(context.Background())
+		if err != nil {
+			log.Fatal("Error applying migrations: ", err)
+		}
+		log.Printf("Applied %d migrations.", len(applied))
+	}
+
+	// Configuring which email addresses may register
+	emailPolicy := utils.RegistrationEmailPolicy
+	emailPolicy.AllowedDomains = utils.ParseDomains(os.Getenv("EMAIL_ALLOWED_DOMAINS"))
+	emailPolicy.DeniedDomains = utils.ParseDomains(os.Getenv("EMAIL_DENIED_DOMAINS"))
+	emailPolicy.BlockDisposable = os.Getenv("EMAIL_ALLOW_DISPOSABLE") != "true"
+	emailPolicy.CheckMX = os.Getenv("EMAIL_CHECK_MX") == "true"
 
-	log.Fatal(http.ListenAndServe(":5000", r))
-	fmt.Println("Listening at port 5000...")
+	// Configuring the password policy
+	passwordPolicy := utils.DefaultPasswordPolicy
+	if minLength := os.Getenv("PASSWORD_MIN_LENGTH"); minLength != "" {
+		passwordPolicy.MinLength, err = strconv.Atoi(minLength)
+		if err != nil {
+			log.Fatal("Error parsing PASSWORD_MIN_LENGTH: ", err)
+		}
+	}
+	if minClasses := os.Getenv("PASSWORD_MIN_CHARACTER_CLASSES"); minClasses != "" {
+		passwordPolicy.MinCharacterClasses, err = strconv.Atoi(minClasses)
+		if err != nil {
+			log.Fatal("Error parsing PASSWORD_MIN_CHARACTER_CLASSES: ", err)
+		}
+	}
+	if minEntropy := os.Getenv("PASSWORD_MIN_ENTROPY_BITS"); minEntropy != "" {
+		passwordPolicy.MinEntropyBits, err = strconv.ParseFloat(minEntropy, 64)
+		if err != nil {
+			log.Fatal("Error parsing PASSWORD_MIN_ENTROPY_BITS: ", err)
+		}
+	}
+
+	// Configuring password hashing. Existing hashes are upgraded to these settings as users log in.
+	switch hasher := os.Getenv("PASSWORD_HASHER"); hasher {
+	case "", "argon2id":
+		argon := *utils.DefaultArgon2id
+		argon.Memory = uint32(envInt("ARGON2_MEMORY_KIB", int(argon.Memory)))
+		argon.Iterations = uint32(envInt("ARGON2_ITERATIONS", int(argon.Iterations)))
+		argon.Parallelism = uint8(envInt("ARGON2_PARALLELISM", int(argon.Parallelism)))
+		utils.Passwords.Hasher = &argon
+	case "bcrypt":
+		utils.Passwords.Hasher = &utils.BcryptHasher{Cost: envInt("BCRYPT_COST", 10)}
+	default:
+		log.Fatal("Error: PASSWORD_HASHER must be \"argon2id\" or \"bcrypt\"")
+	}
+	if peppers := os.Getenv("PASSWORD_PEPPERS"); peppers != "" {
+		utils.Passwords.Peppers = make(map[string][]byte)
+		for _, pepper := range strings.Split(peppers, ",") {
+			id, secret, ok := strings.Cut(strings.TrimSpace(pepper), ":")
+			if !ok || id == "" || strings.Contains(id, "$") || secret == "" {
+				log.Fatal("Error: PASSWORD_PEPPERS must be a comma-separated list of id:secret")
+			}
+			utils.Passwords.Peppers[id] = []byte(secret)
+		}
+	}
+	utils.Passwords.PepperID = os.Getenv("PASSWORD_PEPPER_ID")
+	if _, ok := utils.Passwords.Peppers[utils.Passwords.PepperID]; utils.Passwords.PepperID != "" && !ok {
+		log.Fatal("Error: PASSWORD_PEPPER_ID names a pepper missing from PASSWORD_PEPPERS")
+	}
+
+	// Configuring account deletion
+	if grace := os.Getenv("ACCOUNT_DELETION_GRACE_PERIOD"); grace != "" {
+		jobs.DeletionGracePeriod, err = time.ParseDuration(grace)
+		if err != nil {
+			log.Fatal("Error parsing ACCOUNT_DELETION_GRACE_PERIOD: ", err)
+		}
+	}
+	switch policy := jobs.PostPolicy(os.Getenv("ACCOUNT_DELETION_POST_POLICY")); policy {
+	case "":
+	case jobs.PostPolicyAnonymise, jobs.PostPolicyDelete:
+		jobs.DeletedPostPolicy = policy
+	default:
+		log.Fatal("Error: ACCOUNT_DELETION_POST_POLICY must be \"anonymise\" or \"delete\"")
+	}
+
+	// Configuring audit log retention
+	if retention := os.Getenv("AUDIT_RETENTION"); retention != "" {
+		jobs.AuditRetention, err = time.ParseDuration(retention)
+		if err != nil {
+			log.Fatal("Error parsing AUDIT_RETENTION: ", err)
+		}
+	}
+
+	// Starting background jobs, which are stopped once the server has drained
+	jobsCtx, stopJobs := context.WithCancel(context.Background())
+	var jobsDone sync.WaitGroup
+	for _, job := range []func(context.Context){
+		jobs.RunAuthorPropagation,
+		func(ctx context.Context) { jobs.RunAuthorReconcile(ctx, time.Hour) },
+		func(ctx context.Context) { jobs.RunAccountPurge(ctx, time.Hour) },
+		func(ctx context.Context) { jobs.RunAuditRetention(ctx, time.Hour) },
+	} {
+		jobsDone.Add(1)
+		go func() {
+			defer jobsDone.Done()
+			job(jobsCtx)
+		}()
+	}
+
+	// Configuring the HTTP server, bounding how long slow clients can hold connections
+	addr := os.Getenv("LISTEN_ADDR")
+	if addr == "" {
+		addr = ":5000"
+	}
+	server := &http.Server{
+		Addr:              addr,
+		Handler:           routes.Router(),
+		ReadHeaderTimeout: envDuration("HTTP_READ_HEADER_TIMEOUT", 5*time.Second),
+		ReadTimeout:       envDuration("HTTP_READ_TIMEOUT", 30*time.Second),
+		WriteTimeout:      envDuration("HTTP_WRITE_TIMEOUT", 30*time.Second),
+		IdleTimeout:       envDuration("HTTP_IDLE_TIMEOUT", 2*time.Minute),
+		MaxHeaderBytes:    envInt("HTTP_MAX_HEADER_BYTES", http.DefaultMaxHeaderBytes),
+		ErrorLog:          slog.NewLogLogger(slog.Default().Handler(), slog.LevelWarn),
+	}
+
+	// Serving metrics on a separate listener, so that only the monitoring network can scrape them
+	metricsAddr := os.Getenv("METRICS_ADDR")
+	if metricsAddr == "" {
+		metricsAddr = ":9090"
+	}
+	internal := &http.Server{
+		Addr:              metricsAddr,
+		Handler:           routes.InternalRouter(),
+		ReadHeaderTimeout: 5 * time.Second,
+		ErrorLog:          server.ErrorLog,
+	}
+
+	serve([]*http.Server{server, internal}, stopJobs, &jobsDone, shutdownConfig{
+		Delay:   envDuration("SHUTDOWN_DELAY", 0),
+		Timeout: envDuration("SHUTDOWN_TIMEOUT", 30*time.Second),
+	})
+}
+
+// envInt reads a positive integer from the environment, returning fallback when it is unset.
+func envInt(name string, fallback int) int {
+	value := os.Getenv(name)
+	if value == "" {
+		return fallback
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n <= 0 {
+		log.Fatalf("Error parsing %s: must be a positive integer", name)
+	}
+	return n
+}
+
+// envDuration reads a duration such as "30s" from the environment, returning fallback when it is unset.
+func envDuration(name string, fallback time.Duration) time.Duration {
+	value := os.Getenv(name)
+	if value == "" {
+		return fallback
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil || d < 0 {
+		log.Fatalf("Error parsing %s: must be a duration such as 30s", name)
+	}
+	return d
 }
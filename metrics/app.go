@@ -0,0 +1,26 @@
+package metrics
+
+// HTTP traffic, labelled by the route template rather than the path so that IDs in
+// paths don't create a series per resource
+var (
+	HTTPRequests = NewCounter("http_requests_total",
+		"Requests served, by method, route and status.", "method", "route", "status")
+	HTTPDuration = NewHistogram("http_request_duration_seconds",
+		"Time taken to serve requests, by method and route.", DefaultBuckets, "method", "route")
+	HTTPInFlight = NewGauge("http_requests_in_flight",
+		"Requests being served, by route.", "route")
+)
+
+// MongoDB operations, labelled by command name such as find or insert
+var (
+	MongoDuration = NewHistogram("mongodb_command_duration_seconds",
+		"Time taken by MongoDB commands, by command and outcome.", DefaultBuckets, "command", "outcome")
+)
+
+// Business events
+var (
+	Registrations = NewCounter("schooglink_registrations_total", "Users registered.")
+	Logins        = NewCounter("schooglink_logins_total", "Successful logins.")
+	LoginFailures = NewCounter("schooglink_login_failures_total", "Failed logins, by reason.", "reason")
+	PostsCreated  = NewCounter("schooglink_posts_created_total", "Posts created.")
+)
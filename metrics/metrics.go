@@ -0,0 +1,224 @@
+/*
+Package metrics keeps counters, gauges and histograms in memory and exposes them, along
+with Go runtime and process metrics, in the Prometheus text format, so that the API can
+be scraped without pulling in a client library.
+*/
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DefaultBuckets are the upper bounds, in seconds, of latency histograms.
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// registry holds every metric created by this package, in creation order.
+var registry struct {
+	mu      sync.Mutex
+	metrics []*metric
+}
+
+// metric is a family of series sharing a name and label names.
+type metric struct {
+	name    string
+	help    string
+	kind    string
+	labels  []string
+	buckets []float64
+	// read, when set, gives the value of a metric without labels at scrape time
+	read func() float64
+
+	mu     sync.Mutex
+	series map[string]*series
+}
+
+// series is the value of a metric for one combination of label values.
+type series struct {
+	labelValues []string
+	value       float64
+	// counts holds per-bucket counts of histograms, not cumulative
+	counts []uint64
+	count  uint64
+}
+
+func newMetric(name, help, kind string, buckets []float64, labels []string) *metric {
+	m := &metric{name: name, help: help, kind: kind, labels: labels, buckets: buckets, series: make(map[string]*series)}
+	// Showing metrics without labels from the start, rather than once they first change
+	if len(labels) == 0 {
+		m.with(nil, func(*series) {})
+	}
+	registry.mu.Lock()
+	registry.metrics = append(registry.metrics, m)
+	registry.mu.Unlock()
+	return m
+}
+
+// with runs update on the series for labelValues, creating it if needed.
+func (m *metric) with(labelValues []string, update func(*series)) {
+	if len(labelValues) != len(m.labels) {
+		panic(fmt.Sprintf("metrics: %s takes %d label values, got %d", m.name, len(m.labels), len(labelValues)))
+	}
+	key := strings.Join(labelValues, "\xff")
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s, ok := m.series[key]
+	if !ok {
+		s = &series{labelValues: append([]string(nil), labelValues...)}
+		if m.buckets != nil {
+			s.counts = make([]uint64, len(m.buckets))
+		}
+		m.series[key] = s
+	}
+	update(s)
+}
+
+// Counter is a value that only goes up, such as a number of requests.
+type Counter struct{ m *metric }
+
+// NewCounter creates and registers a counter with the given label names.
+func NewCounter(name, help string, labels ...string) *Counter {
+	return &Counter{newMetric(name, help, "counter", nil, labels)}
+}
+
+// Inc adds one to the series for labelValues.
+func (c *Counter) Inc(labelValues ...string) {
+	c.m.with(labelValues, func(s *series) { s.value++ })
+}
+
+// Gauge is a value that goes up and down, such as requests in progress.
+type Gauge struct{ m *metric }
+
+// NewGauge creates and registers a gauge with the given label names.
+func NewGauge(name, help string, labels ...string) *Gauge {
+	return &Gauge{newMetric(name, help, "gauge", nil, labels)}
+}
+
+// Add adds delta, which may be negative, to the series for labelValues.
+func (g *Gauge) Add(delta float64, labelValues ...string) {
+	g.m.with(labelValues, func(s *series) { s.value += delta })
+}
+
+// NewGaugeFunc creates and registers a gauge without labels whose value is read when scraped.
+func NewGaugeFunc(name, help string, read func() float64) {
+	newMetric(name, help, "gauge", nil, nil).read = read
+}
+
+// NewCounterFunc creates and registers a counter without labels whose value is read when
+// scraped. read must never return less than it did before.
+func NewCounterFunc(name, help string, read func() float64) {
+	newMetric(name, help, "counter", nil, nil).read = read
+}
+
+// Histogram counts observations, such as latencies, in buckets.
+type Histogram struct{ m *metric }
+
+// NewHistogram creates and registers a histogram with the given bucket upper bounds and label names.
+func NewHistogram(name, help string, buckets []float64, labels ...string) *Histogram {
+	return &Histogram{newMetric(name, help, "histogram", buckets, labels)}
+}
+
+// Observe records a value in the series for labelValues.
+func (h *Histogram) Observe(value float64, labelValues ...string) {
+	h.m.with(labelValues, func(s *series) {
+		for i, bound := range h.m.buckets {
+			if value <= bound {
+				s.counts[i]++
+				break
+			}
+		}
+		s.count++
+		s.value += value
+	})
+}
+
+// Handler serves every metric in the Prometheus text exposition format.
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		out := bufio.NewWriter(w)
+		defer out.Flush()
+
+		registry.mu.Lock()
+		metrics := append([]*metric(nil), registry.metrics...)
+		registry.mu.Unlock()
+		for _, m := range metrics {
+			m.write(out)
+		}
+	})
+}
+
+// write prints the metric with its series sorted by label values, so that output is stable.
+func (m *metric) write(out *bufio.Writer) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	fmt.Fprintf(out, "# HELP %s %s\n# TYPE %s %s\n", m.name, helpEscaper.Replace(m.help), m.name, m.kind)
+	if m.read != nil {
+		fmt.Fprintf(out, "%s %s\n", m.name, formatFloat(m.read()))
+		return
+	}
+
+	keys := make([]string, 0, len(m.series))
+	for key := range m.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		s := m.series[key]
+		if m.kind != "histogram" {
+			fmt.Fprintf(out, "%s%s %s\n", m.name, m.labelPairs(s.labelValues, ""), formatFloat(s.value))
+			continue
+		}
+
+		var cumulative uint64
+		for i, bound := range m.buckets {
+			cumulative += s.counts[i]
+			fmt.Fprintf(out, "%s_bucket%s %d\n", m.name, m.labelPairs(s.labelValues, formatFloat(bound)), cumulative)
+		}
+		fmt.Fprintf(out, "%s_bucket%s %d\n", m.name, m.labelPairs(s.labelValues, "+Inf"), s.count)
+		fmt.Fprintf(out, "%s_sum%s %s\n", m.name, m.labelPairs(s.labelValues, ""), formatFloat(s.value))
+		fmt.Fprintf(out, "%s_count%s %d\n", m.name, m.labelPairs(s.labelValues, ""), s.count)
+	}
+}
+
+// labelPairs formats label values as {name="value",...}, adding the le label of histogram buckets when given.
+func (m *metric) labelPairs(values []string, le string) string {
+	pairs := make([]string, 0, len(values)+1)
+	for i, value := range values {
+		pairs = append(pairs, m.labels[i]+`="`+labelEscaper.Replace(value)+`"`)
+	}
+	if le != "" {
+		pairs = append(pairs, `le="`+le+`"`)
+	}
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+// labelEscaper and helpEscaper escape label values and help texts the way the text format requires
+var (
+	labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+)
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
@@ -0,0 +1,101 @@
+package metrics
+
+import (
+	"bufio"
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// exposition renders a single metric in the text format.
+func exposition(m *metric) string {
+	var buf bytes.Buffer
+	out := bufio.NewWriter(&buf)
+	m.write(out)
+	out.Flush()
+	return buf.String()
+}
+
+func TestCounterExposition(t *testing.T) {
+	requests := NewCounter("test_requests_total", "Requests, by path.\nPaths are C:\\ style.", "path")
+	requests.Inc("/b")
+	requests.Inc(`/a "quoted" \ back` + "\nslash")
+	requests.Inc("/b")
+
+	want := `# HELP test_requests_total Requests, by path.\nPaths are C:\\ style.
+# TYPE test_requests_total counter
+test_requests_total{path="/a \"quoted\" \\ back\nslash"} 1
+test_requests_total{path="/b"} 2
+`
+	if got := exposition(requests.m); got != want {
+		t.Errorf("exposition =\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestUnlabelledMetricsStartAtZero(t *testing.T) {
+	gauge := NewGauge("test_in_flight", "In flight.")
+	want := "# HELP test_in_flight In flight.\n# TYPE test_in_flight gauge\ntest_in_flight 0\n"
+	if got := exposition(gauge.m); got != want {
+		t.Errorf("exposition =\n%s\nwant\n%s", got, want)
+	}
+
+	gauge.Add(2)
+	gauge.Add(-0.5)
+	if got := exposition(gauge.m); !strings.HasSuffix(got, "test_in_flight 1.5\n") {
+		t.Errorf("exposition after changes =\n%s", got)
+	}
+}
+
+func TestHistogramExposition(t *testing.T) {
+	latency := NewHistogram("test_duration_seconds", "Durations.", []float64{0.1, 1}, "route")
+	for _, v := range []float64{0.05, 0.1, 0.5, 3} {
+		latency.Observe(v, "/posts")
+	}
+
+	// Buckets are cumulative and inclusive of their bound, and +Inf counts every observation
+	want := `# HELP test_duration_seconds Durations.
+# TYPE test_duration_seconds histogram
+test_duration_seconds_bucket{route="/posts",le="0.1"} 2
+test_duration_seconds_bucket{route="/posts",le="1"} 3
+test_duration_seconds_bucket{route="/posts",le="+Inf"} 4
+test_duration_seconds_sum{route="/posts"} 3.65
+test_duration_seconds_count{route="/posts"} 4
+`
+	if got := exposition(latency.m); got != want {
+		t.Errorf("exposition =\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestFuncMetricsReadWhenScraped(t *testing.T) {
+	value := 1.0
+	NewGaugeFunc("test_temperature", "Temperature.", func() float64 { return value })
+	value = 2
+
+	recorder := httptest.NewRecorder()
+	Handler().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	body := recorder.Body.String()
+
+	if got := recorder.Header().Get("Content-Type"); !strings.HasPrefix(got, "text/plain; version=0.0.4") {
+		t.Errorf("Content-Type = %q", got)
+	}
+	if !strings.Contains(body, "# TYPE test_temperature gauge\ntest_temperature 2\n") {
+		t.Errorf("scrape lacks the current gauge value:\n%s", body)
+	}
+	for _, name := range []string{"go_goroutines ", "go_memstats_alloc_bytes ", "go_gc_cycles_total ", "process_start_time_seconds ", `go_info{version="go`} {
+		if !strings.Contains(body, "\n"+name) {
+			t.Errorf("scrape lacks %s", name)
+		}
+	}
+}
+
+func TestWrongLabelCountPanics(t *testing.T) {
+	counter := NewCounter("test_labelled_total", "Labelled.", "a", "b")
+	defer func() {
+		if recover() == nil {
+			t.Error("Inc with too few label values didn't panic")
+		}
+	}()
+	counter.Inc("only one")
+}
@@ -0,0 +1,62 @@
+package metrics
+
+import (
+	"os"
+	"runtime"
+	"sync"
+	"time"
+)
+
+// Go runtime and process metrics, named like those of the official Prometheus client so
+// that existing dashboards work
+func init() {
+	NewGauge("go_info", "Version of Go the binary was built with.", "version").Add(1, runtime.Version())
+	NewGaugeFunc("go_goroutines", "Goroutines that currently exist.", func() float64 {
+		return float64(runtime.NumGoroutine())
+	})
+	NewGaugeFunc("go_memstats_alloc_bytes", "Bytes of allocated heap objects.", func() float64 {
+		return float64(memStats().HeapAlloc)
+	})
+	NewGaugeFunc("go_memstats_heap_inuse_bytes", "Bytes in in-use heap spans.", func() float64 {
+		return float64(memStats().HeapInuse)
+	})
+	NewGaugeFunc("go_memstats_sys_bytes", "Bytes of memory obtained from the OS.", func() float64 {
+		return float64(memStats().Sys)
+	})
+	NewCounterFunc("go_gc_cycles_total", "Completed garbage collection cycles.", func() float64 {
+		return float64(memStats().NumGC)
+	})
+	NewGaugeFunc("go_memstats_last_gc_time_seconds", "Unix time of the last garbage collection.", func() float64 {
+		return float64(memStats().LastGC) / 1e9
+	})
+
+	start := float64(time.Now().Unix())
+	NewGaugeFunc("process_start_time_seconds", "Unix time the process started.", func() float64 {
+		return start
+	})
+	NewGaugeFunc("process_open_fds", "Open file descriptors, or -1 where they can't be counted.", func() float64 {
+		fds, err := os.ReadDir("/proc/self/fd")
+		if err != nil {
+			return -1
+		}
+		return float64(len(fds))
+	})
+}
+
+// memStatsCache keeps the memory statistics of one scrape, since reading them stops the world
+var memStatsCache struct {
+	mu    sync.Mutex
+	stats runtime.MemStats
+	read  time.Time
+}
+
+// memStats returns the runtime's memory statistics, read at most once a second.
+func memStats() runtime.MemStats {
+	memStatsCache.mu.Lock()
+	defer memStatsCache.mu.Unlock()
+	if time.Since(memStatsCache.read) > time.Second {
+		runtime.ReadMemStats(&memStatsCache.stats)
+		memStatsCache.read = time.Now()
+	}
+	return memStatsCache.stats
+}
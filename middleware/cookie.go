@@ -0,0 +1,91 @@
+package middleware
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"time"
+
+	"github.com/Aman913k/models"
+	"github.com/Aman913k/utils"
+)
+
+// Cookies and headers of cookie sessions. Browser clients opt into a cookie session by
+// logging in with the SessionModeHeader set to "cookie"; the access token is then kept in
+// an HttpOnly cookie instead of being handed to JavaScript.
+const (
+	SessionCookieName = "session"
+	// CSRFCookieName holds the CSRF token, readable by the frontend so that it can echo it in CSRFHeaderName
+	CSRFCookieName    = "csrf_token"
+	CSRFHeaderName    = "X-CSRF-Token"
+	SessionModeHeader = "X-Session-Mode"
+)
+
+// CookieSessions enables cookie sessions. Bearer tokens are accepted either way.
+var CookieSessions = false
+
+// CookieSecure marks the session cookies Secure. Only turn it off for local development over plain HTTP.
+var CookieSecure = true
+
+// CookieSameSite is the SameSite attribute of the session cookies.
+var CookieSameSite = http.SameSiteLaxMode
+
+// WantsCookieSession reports whether a login request asked for a cookie session. Only a header
+// counts, as a cross-site page can't set one on the requests it makes the browser send.
+func WantsCookieSession(r *http.Request) bool {
+	return CookieSessions && r.Header.Get(SessionModeHeader) == "cookie"
+}
+
+// SetSessionCookies stores the access token and the CSRF token of its session in cookies.
+func SetSessionCookies(w http.ResponseWriter, token, csrfToken string, ttl time.Duration) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     SessionCookieName,
+		Value:    token,
+		Path:     "/",
+		MaxAge:   int(ttl.Seconds()),
+		HttpOnly: true,
+		Secure:   CookieSecure,
+		SameSite: CookieSameSite,
+	})
+	http.SetCookie(w, &http.Cookie{
+		Name:     CSRFCookieName,
+		Value:    csrfToken,
+		Path:     "/",
+		MaxAge:   int(ttl.Seconds()),
+		Secure:   CookieSecure,
+		SameSite: CookieSameSite,
+	})
+}
+
+// ClearSessionCookies removes the session cookies.
+func ClearSessionCookies(w http.ResponseWriter) {
+	for _, name := range []string{SessionCookieName, CSRFCookieName} {
+		http.SetCookie(w, &http.Cookie{
+			Name:     name,
+			Value:    "",
+			Path:     "/",
+			MaxAge:   -1,
+			Secure:   CookieSecure,
+			SameSite: CookieSameSite,
+		})
+	}
+}
+
+// validCSRF checks the CSRF header of a state-changing request authenticated by cookie.
+// The header is compared with the random token stored with the session, so a token planted
+// in the CSRF cookie by another site doesn't help. Bearer tokens need no CSRF token, as
+// browsers never attach them to a request on their own.
+func validCSRF(r *http.Request, session *models.Session, fromCookie bool) bool {
+	if !fromCookie {
+		return true
+	}
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return true
+	}
+
+	header := r.Header.Get(CSRFHeaderName)
+	if header == "" || session == nil || session.CSRFTokenHash == "" {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(utils.HashCSRFToken(header)), []byte(session.CSRFTokenHash)) == 1
+}
@@ -0,0 +1,78 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/Aman913k/models"
+	"github.com/Aman913k/utils"
+)
+
+func TestValidCSRF(t *testing.T) {
+	token, hash, err := utils.GenerateCSRFToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, _, _ := utils.GenerateCSRFToken()
+	session := &models.Session{CSRFTokenHash: hash}
+
+	tests := []struct {
+		name       string
+		method     string
+		header     string
+		session    *models.Session
+		fromCookie bool
+		want       bool
+	}{
+		{"matching header", http.MethodPost, token, session, true, true},
+		{"missing header", http.MethodPost, "", session, true, false},
+		{"mismatched header", http.MethodDelete, other, session, true, false},
+		{"hash sent as the token", http.MethodPut, hash, session, true, false},
+		{"session without a token", http.MethodPost, token, &models.Session{}, true, false},
+		{"GET needs no token", http.MethodGet, "", session, true, true},
+		{"HEAD needs no token", http.MethodHead, "", session, true, true},
+		{"OPTIONS needs no token", http.MethodOptions, "", session, true, true},
+		{"bearer token needs none", http.MethodPost, "", session, false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(tt.method, "/posts", nil)
+			if tt.header != "" {
+				r.Header.Set(CSRFHeaderName, tt.header)
+			}
+			if got := validCSRF(r, tt.session, tt.fromCookie); got != tt.want {
+				t.Errorf("validCSRF() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWantsCookieSession(t *testing.T) {
+	defer func(enabled bool) { CookieSessions = enabled }(CookieSessions)
+
+	tests := []struct {
+		name    string
+		enabled bool
+		header  string
+		query   string
+		want    bool
+	}{
+		{"header", true, "cookie", "", true},
+		{"no header", true, "", "", false},
+		{"query parameter doesn't count", true, "", "?session=cookie", false},
+		{"cookie sessions disabled", false, "cookie", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			CookieSessions = tt.enabled
+			r := httptest.NewRequest(http.MethodPost, "/login"+tt.query, nil)
+			if tt.header != "" {
+				r.Header.Set(SessionModeHeader, tt.header)
+			}
+			if got := WantsCookieSession(r); got != tt.want {
+				t.Errorf("WantsCookieSession() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,155 @@
+package middleware
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"log/slog"
+	"net/http"
+	"runtime/debug"
+	"time"
+
+	"github.com/Aman913k/problem"
+	"github.com/Aman913k/utils"
+)
+
+// loggerContextKey holds the logger of a request, which tags every entry with the request ID
+const loggerContextKey = contextKey("logger")
+
+// maxRequestIDLength bounds request IDs accepted from clients
+const maxRequestIDLength = 128
+
+// Logger returns the logger of the request ctx belongs to, or the default logger outside requests.
+func Logger(ctx context.Context) *slog.Logger {
+	if logger, ok := ctx.Value(loggerContextKey).(*slog.Logger); ok {
+		return logger
+	}
+	return slog.Default()
+}
+
+// RequestID assigns every request an ID, keeping the one the client or a proxy sent in
+// X-Request-ID when it is reasonable, and echoes it in the response. It also gives the
+// request a logger tagged with the ID.
+func RequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(utils.RequestIDHeader)
+		if !validRequestID(id) {
+			id = newRequestID()
+		}
+		w.Header().Set(utils.RequestIDHeader, id)
+
+		ctx := utils.WithRequestID(r.Context(), id)
+		ctx = context.WithValue(ctx, loggerContextKey, slog.Default().With("request_id", id))
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// AccessLog logs every request with its status, size and latency once it has been served.
+func AccessLog(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		recorder := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(recorder, r)
+
+		Logger(r.Context()).Info("request",
+			"method", r.Method,
+			"path", r.URL.Path,
+			"status", recorder.Status(),
+			"bytes", recorder.bytes,
+			"duration_ms", float64(time.Since(start).Microseconds())/1000,
+			"ip", utils.ClientIP(r),
+			"user_agent", r.UserAgent(),
+		)
+	})
+}
+
+// Recover turns a panicking handler into a 500 problem response instead of a dropped
+// connection, logging the panic with its stack.
+func Recover(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		recorder, ok := w.(*statusRecorder)
+		if !ok {
+			recorder = &statusRecorder{ResponseWriter: w}
+		}
+
+		defer func() {
+			err := recover()
+			if err == nil {
+				return
+			}
+			// Letting handlers abort a response on purpose, as net/http expects
+			if err == http.ErrAbortHandler {
+				panic(err)
+			}
+
+			Logger(r.Context()).Error("panic serving request", "panic", err, "stack", string(debug.Stack()))
+			if recorder.status == 0 {
+				problem.Error(recorder, r, http.StatusInternalServerError, problem.CodeInternal, "Internal server error")
+			}
+		}()
+		next.ServeHTTP(recorder, r)
+	})
+}
+
+// Chain wraps handler in middlewares, the first one outermost.
+func Chain(handler http.Handler, middlewares ...func(http.Handler) http.Handler) http.Handler {
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		handler = middlewares[i](handler)
+	}
+	return handler
+}
+
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for _, c := range id {
+		// Keeping IDs printable so they can't forge log lines
+		if c < '!' || c > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+func newRequestID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// statusRecorder remembers the status and size of a response for logging.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int
+}
+
+func (s *statusRecorder) WriteHeader(status int) {
+	if s.status == 0 {
+		s.status = status
+	}
+	s.ResponseWriter.WriteHeader(status)
+}
+
+func (s *statusRecorder) Write(b []byte) (int, error) {
+	if s.status == 0 {
+		s.status = http.StatusOK
+	}
+	n, err := s.ResponseWriter.Write(b)
+	s.bytes += n
+	return n, err
+}
+
+// Status returns the status sent, which is 200 when the handler wrote nothing.
+func (s *statusRecorder) Status() int {
+	if s.status == 0 {
+		return http.StatusOK
+	}
+	return s.status
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (s *statusRecorder) Unwrap() http.ResponseWriter {
+	return s.ResponseWriter
+}
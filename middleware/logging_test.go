@@ -0,0 +1,172 @@
+package middleware
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/Aman913k/problem"
+	"github.com/Aman913k/utils"
+)
+
+// captureLogs sends the default logger's JSON entries to the returned buffer for the test.
+func captureLogs(t *testing.T) *bytes.Buffer {
+	t.Helper()
+	var buf bytes.Buffer
+	previous := slog.Default()
+	slog.SetDefault(slog.New(slog.NewJSONHandler(&buf, nil)))
+	t.Cleanup(func() { slog.SetDefault(previous) })
+	return &buf
+}
+
+// logEntries decodes the JSON log lines written to buf.
+func logEntries(t *testing.T, buf *bytes.Buffer) []map[string]interface{} {
+	t.Helper()
+	var entries []map[string]interface{}
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		if line == "" {
+			continue
+		}
+		var entry map[string]interface{}
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			t.Fatalf("log line %q: %v", line, err)
+		}
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+func TestRequestID(t *testing.T) {
+	tests := []struct {
+		name  string
+		sent  string
+		keeps bool
+	}{
+		{"assigned when missing", "", false},
+		{"kept from the client", "req-42", true},
+		{"replaced when it has spaces", "req 42", false},
+		{"replaced when it could forge log lines", "req\n42", false},
+		{"replaced when too long", strings.Repeat("a", maxRequestIDLength+1), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logs := captureLogs(t)
+			var seen string
+			handler := RequestID(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				seen = utils.RequestID(r)
+				Logger(r.Context()).Info("handling")
+			}))
+
+			r := httptest.NewRequest(http.MethodGet, "/posts", nil)
+			if tt.sent != "" {
+				r.Header.Set(utils.RequestIDHeader, tt.sent)
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+
+			echoed := w.Header().Get(utils.RequestIDHeader)
+			if tt.keeps && echoed != tt.sent {
+				t.Errorf("response ID = %q, want the client's %q", echoed, tt.sent)
+			}
+			if !tt.keeps && (echoed == tt.sent || len(echoed) != 32) {
+				t.Errorf("response ID = %q, want a new one", echoed)
+			}
+			if seen != echoed {
+				t.Errorf("handler saw ID %q, response has %q", seen, echoed)
+			}
+			if entries := logEntries(t, logs); len(entries) != 1 || entries[0]["request_id"] != echoed {
+				t.Errorf("handler logged %v, want an entry tagged with the request ID", entries)
+			}
+		})
+	}
+}
+
+func TestRecover(t *testing.T) {
+	logs := captureLogs(t)
+	handler := Chain(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic("boom")
+	}), RequestID, AccessLog, Recover)
+
+	r := httptest.NewRequest(http.MethodGet, "/posts", nil)
+	r.Header.Set(utils.RequestIDHeader, "req-1")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+
+	var p problem.Problem
+	if err := json.Unmarshal(w.Body.Bytes(), &p); err != nil {
+		t.Fatal(err)
+	}
+	if w.Code != http.StatusInternalServerError || p.Code != problem.CodeInternal || p.RequestID != "req-1" {
+		t.Errorf("response %d %+v, want a 500 problem for req-1", w.Code, p)
+	}
+
+	entries := logEntries(t, logs)
+	if len(entries) != 2 {
+		t.Fatalf("logged %v, want the panic and the access log", entries)
+	}
+	if panicked := entries[0]; panicked["panic"] != "boom" || !strings.Contains(panicked["stack"].(string), "TestRecover") {
+		t.Errorf("panic entry = %v, want the value and the stack", panicked)
+	}
+	// The access log sees the status Recover sent
+	if access := entries[1]; access["status"] != float64(http.StatusInternalServerError) || access["request_id"] != "req-1" {
+		t.Errorf("access entry = %v, want status 500 for req-1", access)
+	}
+}
+
+func TestRecoverAfterResponseStarted(t *testing.T) {
+	captureLogs(t)
+	handler := Recover(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusAccepted)
+		w.Write([]byte("partial"))
+		panic("boom")
+	}))
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/posts", nil))
+	if w.Code != http.StatusAccepted || w.Body.String() != "partial" {
+		t.Errorf("response %d %q, want the partial response left alone", w.Code, w.Body)
+	}
+}
+
+func TestRecoverLetsHandlersAbort(t *testing.T) {
+	handler := Recover(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic(http.ErrAbortHandler)
+	}))
+	defer func() {
+		if err := recover(); err != http.ErrAbortHandler {
+			t.Errorf("recovered %v, want http.ErrAbortHandler passed on", err)
+		}
+	}()
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/posts", nil))
+}
+
+func TestAccessLog(t *testing.T) {
+	logs := captureLogs(t)
+	handler := AccessLog(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte("hello"))
+	}))
+
+	r := httptest.NewRequest(http.MethodPost, "/posts", nil)
+	r.RemoteAddr = "198.51.100.1:1234"
+	r.Header.Set("User-Agent", "test")
+	handler.ServeHTTP(httptest.NewRecorder(), r)
+
+	entries := logEntries(t, logs)
+	if len(entries) != 1 {
+		t.Fatalf("logged %v, want one entry", entries)
+	}
+	want := map[string]interface{}{
+		"method": "POST", "path": "/posts", "status": float64(http.StatusCreated),
+		"bytes": float64(5), "ip": "198.51.100.1", "user_agent": "test",
+	}
+	for key, value := range want {
+		if entries[0][key] != value {
+			t.Errorf("%s = %v, want %v", key, entries[0][key], value)
+		}
+	}
+}
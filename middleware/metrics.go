@@ -0,0 +1,34 @@
+package middleware
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Aman913k/metrics"
+	"github.com/gorilla/mux"
+)
+
+// Instrument counts the requests, latency and requests in flight of each route. It must run
+// inside the router, where the matched route is known; requests matching no route are
+// counted under "unmatched".
+func Instrument(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		route := "unmatched"
+		if current := mux.CurrentRoute(r); current != nil {
+			if template, err := current.GetPathTemplate(); err == nil {
+				route = template
+			}
+		}
+
+		metrics.HTTPInFlight.Add(1, route)
+		defer metrics.HTTPInFlight.Add(-1, route)
+
+		start := time.Now()
+		recorder := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(recorder, r)
+
+		metrics.HTTPRequests.Inc(r.Method, route, strconv.Itoa(recorder.Status()))
+		metrics.HTTPDuration.Observe(time.Since(start).Seconds(), r.Method, route)
+	})
+}
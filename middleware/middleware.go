@@ -5,42 +5,107 @@ import (
 	"net/http"
 	"strings"
 
+	"github.com/Aman913k/problem"
+	"github.com/Aman913k/tracing"
 	"github.com/Aman913k/utils"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // Defining custom type for context keys
 type contextKey string
 
 const (
+	UserIDContextKey = contextKey("user_id")
 	EmailContextKey  = contextKey("email")
 	NameContextKey   = contextKey("name")
+	// ScopesContextKey holds the scopes of the API key a request was authenticated with; it is unset for JWTs
+	ScopesContextKey = contextKey("scopes")
+	// SessionIDContextKey holds the login session a JWT belongs to
+	SessionIDContextKey = contextKey("session_id")
 )
 
-// JWTAuth middleware function for validating JWT tokens
+// JWTAuth middleware function for validating JWT tokens, also accepting personal API keys as bearer credentials
+// and, without an Authorization header, the session cookie
 func JWTAuth(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		// Retrieving the token from the Authorization header
+		// Timing authentication in its own span, which ends once the request is handed on
+		parent := trace.SpanFromContext(r.Context())
+		ctx, span := tracing.Tracer().Start(r.Context(), "middleware.JWTAuth")
+		r = r.WithContext(ctx)
+		authenticated := false
+		defer func() {
+			// Requests handed on have ended the span already
+			if !authenticated {
+				span.SetStatus(codes.Error, "request not authenticated")
+				span.End()
+			}
+		}()
+		// Handing on under the route's span, so that the handler's spans aren't children of an ended one
+		handOn := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			authenticated = true
+			span.End()
+			next.ServeHTTP(w, r.WithContext(trace.ContextWithSpan(r.Context(), parent)))
+		})
+
+		// Retrieving the token from the Authorization header, or from the session cookie
 		tokenStr := r.Header.Get("Authorization")
+		fromCookie := false
+		if cookie, err := r.Cookie(SessionCookieName); tokenStr == "" && err == nil && CookieSessions {
+			tokenStr = "Bearer " + cookie.Value
+			fromCookie = true
+		}
 		if tokenStr == "" || !strings.HasPrefix(tokenStr, "Bearer ") {
-			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			problem.Error(w, r, http.StatusUnauthorized, problem.CodeUnauthorized, "Unauthorized")
 			return
 		}
 
 		// Removing "Bearer " prefix to get the actual token
 		tokenStr = strings.TrimPrefix(tokenStr, "Bearer ")
 
+		if utils.IsAPIKey(tokenStr) && !fromCookie {
+			span.SetAttributes(attribute.String("auth.method", "api_key"))
+			apiKeyAuth(w, r, tokenStr, handOn)
+			return
+		}
+		span.SetAttributes(attribute.String("auth.method", "jwt"), attribute.Bool("auth.cookie", fromCookie))
+
 		// Validating JWT token
 		claims, err := utils.ValidateJWT(tokenStr)
 		if err != nil {
-			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			problem.Error(w, r, http.StatusUnauthorized, problem.CodeUnauthorized, "Unauthorized")
+			return
+		}
+
+		// Rejecting tokens without a session, which couldn't be revoked, and tokens whose session has been
+		if claims.SessionID == "" {
+			problem.Error(w, r, http.StatusUnauthorized, problem.CodeUnauthorized, "Unauthorized")
+			return
+		}
+		session, err := activeSession(r.Context(), claims.SessionID, claims.UserID)
+		if err != nil {
+			problem.ServerError(w, r, err, "Failed to check session")
+			return
+		}
+		if session == nil {
+			problem.Error(w, r, http.StatusUnauthorized, problem.CodeUnauthorized, "Unauthorized")
+			return
+		}
+
+		// Browsers send cookies with cross-site requests too, so state changes must prove they come from our frontend
+		if !validCSRF(r, session, fromCookie) {
+			problem.Error(w, r, http.StatusForbidden, problem.CodeInvalidCSRFToken, "Invalid CSRF token")
 			return
 		}
 
-		// Storing the email from claims in the context with the custom key
-		ctx := context.WithValue(r.Context(), EmailContextKey, claims.Email)
+		// Storing the user ID, email and name from claims in the context with the custom keys
+		ctx = context.WithValue(r.Context(), UserIDContextKey, claims.UserID)
+		ctx = context.WithValue(ctx, EmailContextKey, claims.Email)
 		ctx = context.WithValue(ctx, NameContextKey, claims.Name)
+		ctx = context.WithValue(ctx, SessionIDContextKey, claims.SessionID)
 
 		// Passing control to the next handler
-		next.ServeHTTP(w, r.WithContext(ctx))
+		handOn.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
@@ -0,0 +1,74 @@
+package middleware
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Aman913k/problem"
+	"github.com/Aman913k/ratelimit"
+	"github.com/Aman913k/utils"
+)
+
+// RateLimitFailOpen lets requests through when the rate limit store fails, so that an outage
+// of a shared store doesn't take every limited route down with it. When false such requests
+// are refused with 503 instead, which keeps brute-force protection in place.
+var RateLimitFailOpen = true
+
+// RateLimitKey picks the key a request is counted under.
+type RateLimitKey func(r *http.Request) string
+
+// ByIP counts requests per client IP.
+func ByIP(r *http.Request) string {
+	return "ip:" + utils.ClientIP(r)
+}
+
+// ByUser counts requests per authenticated user, falling back to the client IP before
+// authentication. It must run after JWTAuth.
+func ByUser(r *http.Request) string {
+	if email, ok := r.Context().Value(EmailContextKey).(string); ok && email != "" {
+		return "user:" + email
+	}
+	return ByIP(r)
+}
+
+// RateLimit refuses requests with 429 once the client has used up the limiter's bucket,
+// telling it the policy and its remaining quota in RateLimit-* headers.
+func RateLimit(limiter *ratelimit.Limiter, key RateLimitKey, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !limiter.Enabled() {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		result, err := limiter.Take(r.Context(), key(r))
+		if err != nil {
+			Logger(r.Context()).Error("Rate limit store failed", "limiter", limiter.Name, "error", err, "fail_open", RateLimitFailOpen)
+			if RateLimitFailOpen {
+				next.ServeHTTP(w, r)
+			} else {
+				problem.Error(w, r, http.StatusServiceUnavailable, problem.CodeUnavailable, "Rate limiting is unavailable, try again later")
+			}
+			return
+		}
+
+		header := w.Header()
+		header.Set("RateLimit-Policy", strconv.Itoa(limiter.Policy.Limit)+";w="+ceilSeconds(limiter.Policy.Period))
+		header.Set("RateLimit-Limit", strconv.Itoa(limiter.Policy.Limit))
+		header.Set("RateLimit-Remaining", strconv.Itoa(result.Remaining))
+		header.Set("RateLimit-Reset", ceilSeconds(result.Reset))
+		if !result.Allowed {
+			header.Set("Retry-After", ceilSeconds(result.RetryAfter))
+			problem.Error(w, r, http.StatusTooManyRequests, problem.CodeRateLimited, "Too many requests, try again later")
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// ceilSeconds formats a duration as whole seconds, rounding up.
+func ceilSeconds(d time.Duration) string {
+	return strconv.Itoa(int(math.Ceil(d.Seconds())))
+}
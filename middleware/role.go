@@ -0,0 +1,42 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/Aman913k/database"
+	"github.com/Aman913k/models"
+	"github.com/Aman913k/problem"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// RequireRole only lets through users with the given role. The role is read from the
+// database on every request, so demoting or suspending a user takes effect immediately.
+func RequireRole(role string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userIDStr, _ := r.Context().Value(UserIDContextKey).(string)
+		userID, err := primitive.ObjectIDFromHex(userIDStr)
+		if err != nil {
+			problem.Error(w, r, http.StatusUnauthorized, problem.CodeUnauthorized, "Unauthorized")
+			return
+		}
+
+		var user models.User
+		err = database.GetCollection("schooguser").FindOne(r.Context(), bson.M{"_id": userID}).Decode(&user)
+		if err == mongo.ErrNoDocuments {
+			problem.Error(w, r, http.StatusUnauthorized, problem.CodeUnauthorized, "Unauthorized")
+			return
+		}
+		if err != nil {
+			problem.ServerError(w, r, err, "Failed to check role")
+			return
+		}
+		if user.Role != role || user.SuspendedAt != nil {
+			problem.Error(w, r, http.StatusForbidden, problem.CodeForbidden, "Forbidden")
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,49 @@
+package middleware
+
+import (
+	"context"
+	"time"
+
+	"github.com/Aman913k/database"
+	"github.com/Aman913k/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// activeSession returns the session if it exists for the user and is neither revoked nor
+// expired, recording that it has been seen. It returns nil for sessions that can't be used,
+// and an error when the session couldn't be looked up.
+func activeSession(ctx context.Context, sessionID, userID string) (*models.Session, error) {
+	id, err := primitive.ObjectIDFromHex(sessionID)
+	if err != nil {
+		return nil, nil
+	}
+	owner, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return nil, nil
+	}
+
+	collection := database.GetCollection("schoogsession")
+	var session models.Session
+	err = collection.FindOne(ctx, bson.M{"_id": id, "user_id": owner}).Decode(&session)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	if session.RevokedAt != nil || now.After(session.ExpiresAt) {
+		return nil, nil
+	}
+
+	if now.Sub(session.LastSeenAt) > lastUsedResolution {
+		_, err := collection.UpdateOne(ctx, bson.M{"_id": id}, bson.M{"$set": bson.M{"last_seen_at": now}})
+		if err != nil {
+			Logger(ctx).Error("Failed to record session activity", "error", err)
+		}
+	}
+	return &session, nil
+}
@@ -0,0 +1,30 @@
+package migrations
+
+import (
+	"context"
+
+	"github.com/Aman913k/database"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// createAPIKeyIndexes indexes API keys by hash, used to authenticate every request made
+// with a key, and by owner for listing.
+func createAPIKeyIndexes(ctx context.Context) error {
+	_, err := database.GetCollection("schoogapikey").Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "hash", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "created_at", Value: -1}}},
+	})
+	return err
+}
+
+// dropAPIKeyIndexes drops the indexes created by createAPIKeyIndexes.
+func dropAPIKeyIndexes(ctx context.Context) error {
+	indexes := database.GetCollection("schoogapikey").Indexes()
+	if _, err := indexes.DropOne(ctx, "hash_1"); err != nil {
+		return err
+	}
+	_, err := indexes.DropOne(ctx, "user_id_1_created_at_-1")
+	return err
+}
@@ -0,0 +1,46 @@
+package migrations
+
+import (
+	"context"
+
+	"github.com/Aman913k/database"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// createAuditIndexes indexes the audit log by time and by the users involved, for the
+// admin activity view.
+func createAuditIndexes(ctx context.Context) error {
+	_, err := database.GetCollection("schoogaudit").Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "time", Value: -1}}},
+		{Keys: bson.D{{Key: "actor_id", Value: 1}, {Key: "time", Value: -1}}},
+		{Keys: bson.D{{Key: "target_id", Value: 1}, {Key: "time", Value: -1}}},
+	})
+	return err
+}
+
+// dropAuditIndexes drops the indexes created by createAuditIndexes.
+func dropAuditIndexes(ctx context.Context) error {
+	indexes := database.GetCollection("schoogaudit").Indexes()
+	for _, name := range []string{"time_-1", "actor_id_1_time_-1", "target_id_1_time_-1"} {
+		if _, err := indexes.DropOne(ctx, name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// createAuditActionIndex indexes the audit log by action and time, for filtering the
+// admin audit query.
+func createAuditActionIndex(ctx context.Context) error {
+	_, err := database.GetCollection("schoogaudit").Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "action", Value: 1}, {Key: "time", Value: -1}},
+	})
+	return err
+}
+
+// dropAuditActionIndex drops the index created by createAuditActionIndex.
+func dropAuditActionIndex(ctx context.Context) error {
+	_, err := database.GetCollection("schoogaudit").Indexes().DropOne(ctx, "action_1_time_-1")
+	return err
+}
@@ -0,0 +1,25 @@
+package migrations
+
+import (
+	"context"
+
+	"github.com/Aman913k/database"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// createMagicLinkTTLIndex removes sign-in links once they expire.
+func createMagicLinkTTLIndex(ctx context.Context) error {
+	_, err := database.GetCollection("schoogmagiclink").Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "expires_at", Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(0),
+	})
+	return err
+}
+
+// dropMagicLinkTTLIndex drops the index created by createMagicLinkTTLIndex.
+func dropMagicLinkTTLIndex(ctx context.Context) error {
+	_, err := database.GetCollection("schoogmagiclink").Indexes().DropOne(ctx, "expires_at_1")
+	return err
+}
@@ -0,0 +1,363 @@
+/*
+Package migrations provides versioned schema and data migrations for the MongoDB collections.
+Applied migrations are recorded in their own collection, and a lock document keeps
+concurrent instances from running migrations at the same time.
+*/
+package migrations
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+)
+
+const (
+	appliedColName = "schoogmigration"
+	lockColName    = "schoogmigrationlock"
+	lockID         = "migrations"
+
+	// stepTimeout bounds a single migration step
+	stepTimeout = time.Hour
+)
+
+var (
+	// lockLease bounds how long a crashed instance can hold the lock. The holder renews it
+	// every lockRenewal, so migrations themselves may take longer than the lease.
+	lockLease   = 10 * time.Minute
+	lockRenewal = lockLease / 5
+	lockRetry   = time.Second
+)
+
+// Migration is a single versioned change to the database.
+// A nil Down means there is nothing to undo when rolling the migration back.
+type Migration struct {
+	Version     int
+	Description string
+	Up          func(ctx context.Context) error
+	Down        func(ctx context.Context) error
+}
+
+// Status reports whether a migration has been applied and when.
+type Status struct {
+	Migration
+	Applied   bool
+	AppliedAt time.Time
+}
+
+type lockDocument struct {
+	ID        string    `bson:"_id"`
+	Owner     string    `bson:"owner"`
+	LockedAt  time.Time `bson:"locked_at"`
+	ExpiresAt time.Time `bson:"expires_at"`
+}
+
+type appliedMigration struct {
+	Version     int       `bson:"_id"`
+	Description string    `bson:"description"`
+	AppliedAt   time.Time `bson:"applied_at"`
+}
+
+// all lists every migration in version order. New migrations are appended with the next version.
+var all = []Migration{
+	{
+		Version:     1,
+		Description: "Normalise user emails and create the unique email index",
+		Up:          migrateUserIdentity,
+		Down:        dropUserEmailIndex,
+	},
+	{
+		Version:     2,
+		Description: "Backfill post author IDs",
+		Up:          backfillPostAuthors,
+	},
+	{
+		Version:     3,
+		Description: "Create user and post lookup indexes",
+		Up:          createAPIIndexes,
+		Down:        dropAPIIndexes,
+	},
+	{
+		Version:     4,
+		Description: "Create the account deletion schedule index",
+		Up:          createDeletionIndex,
+		Down:        dropDeletionIndex,
+	},
+	{
+		Version:     5,
+		Description: "Expire stale login failures",
+		Up:          createLockoutTTLIndex,
+		Down:        dropLockoutTTLIndex,
+	},
+	{
+		Version:     6,
+		Description: "Create API key indexes",
+		Up:          createAPIKeyIndexes,
+		Down:        dropAPIKeyIndexes,
+	},
+	{
+		Version:     7,
+		Description: "Create OIDC identity and login state indexes",
+		Up:          createOIDCIndexes,
+		Down:        dropOIDCIndexes,
+	},
+	{
+		Version:     8,
+		Description: "Expire sign-in links",
+		Up:          createMagicLinkTTLIndex,
+		Down:        dropMagicLinkTTLIndex,
+	},
+	{
+		Version:     9,
+		Description: "Create session indexes",
+		Up:          createSessionIndexes,
+		Down:        dropSessionIndexes,
+	},
+	{
+		Version:     10,
+		Description: "Create audit log indexes",
+		Up:          createAuditIndexes,
+		Down:        dropAuditIndexes,
+	},
+	{
+		Version:     11,
+		Description: "Index the audit log by action",
+		Up:          createAuditActionIndex,
+		Down:        dropAuditActionIndex,
+	},
+	{
+		Version:     12,
+		Description: "Expire unlock links",
+		Up:          createUnlockTokenTTLIndex,
+		Down:        dropUnlockTokenTTLIndex,
+	},
+	{
+		Version:     13,
+		Description: "Expire rate limit buckets",
+		Up:          createRateLimitTTLIndex,
+		Down:        dropRateLimitTTLIndex,
+	},
+}
+
+// ErrNothingToRollBack is returned by Down when no migration has been applied.
+var ErrNothingToRollBack = errors.New("no applied migrations to roll back")
+
+// ErrLockLost is returned when the migration lock could not be renewed, in which case another
+// instance may have taken it over and the migrations are aborted.
+var ErrLockLost = errors.New("lost the migration lock")
+
+// Up applies every pending migration in order and returns the ones it applied.
+func Up(ctx context.Context) ([]Migration, error) {
+	ctx, release, err := acquireLock(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	applied, err := appliedVersions(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var ran []Migration
+	for _, m := range all {
+		if _, ok := applied[m.Version]; ok {
+			continue
+		}
+
+		if err := context.Cause(ctx); err != nil {
+			return ran, err
+		}
+		if err := runStep(ctx, m.Up); err != nil {
+			return ran, fmt.Errorf("migration %d (%s): %w", m.Version, m.Description, err)
+		}
+
+		err := backend.markApplied(ctx, appliedMigration{
+			Version:     m.Version,
+			Description: m.Description,
+			AppliedAt:   time.Now(),
+		})
+		if err != nil {
+			return ran, err
+		}
+		ran = append(ran, m)
+	}
+
+	return ran, nil
+}
+
+// Down rolls back the most recently applied migrations, up to steps of them,
+// and returns the ones it rolled back.
+func Down(ctx context.Context, steps int) ([]Migration, error) {
+	ctx, release, err := acquireLock(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	applied, err := appliedVersions(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if len(applied) == 0 {
+		return nil, ErrNothingToRollBack
+	}
+
+	var rolledBack []Migration
+	for i := len(all) - 1; i >= 0 && len(rolledBack) < steps; i-- {
+		m := all[i]
+		if _, ok := applied[m.Version]; !ok {
+			continue
+		}
+
+		if err := context.Cause(ctx); err != nil {
+			return rolledBack, err
+		}
+		if m.Down != nil {
+			if err := runStep(ctx, m.Down); err != nil {
+				return rolledBack, fmt.Errorf("rolling back migration %d (%s): %w", m.Version, m.Description, err)
+			}
+		}
+
+		if err := backend.markRolledBack(ctx, m.Version); err != nil {
+			return rolledBack, err
+		}
+		rolledBack = append(rolledBack, m)
+	}
+
+	return rolledBack, nil
+}
+
+// Statuses reports every known migration along with whether it has been applied.
+func Statuses(ctx context.Context) ([]Status, error) {
+	applied, err := appliedVersions(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := make([]Status, 0, len(all))
+	for _, m := range all {
+		record, ok := applied[m.Version]
+		statuses = append(statuses, Status{
+			Migration: m,
+			Applied:   ok,
+			AppliedAt: record.AppliedAt,
+		})
+	}
+	return statuses, nil
+}
+
+// runStep runs a migration step with its own deadline, so that building indexes on large
+// collections isn't cut short by the database's per-operation timeout. The step is cancelled
+// as soon as the lock is lost.
+func runStep(ctx context.Context, step func(ctx context.Context) error) error {
+	ctx, cancel := context.WithTimeout(ctx, stepTimeout)
+	defer cancel()
+
+	err := step(ctx)
+	if cause := context.Cause(ctx); err != nil && errors.Is(cause, ErrLockLost) {
+		return cause
+	}
+	return err
+}
+
+func appliedVersions(ctx context.Context) (map[int]appliedMigration, error) {
+	records, err := backend.appliedMigrations(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	applied := make(map[int]appliedMigration, len(records))
+	for _, record := range records {
+		applied[record.Version] = record
+	}
+	return applied, nil
+}
+
+// acquireLock blocks until this process holds the migration lock or ctx is done.
+// A lock left behind by a crashed instance is taken over once its lease expires.
+// The returned context is cancelled with ErrLockLost if the lease can't be renewed.
+func acquireLock(ctx context.Context) (context.Context, func(), error) {
+	owner := lockOwner()
+
+	for {
+		now := time.Now()
+		lock := lockDocument{ID: lockID, Owner: owner, LockedAt: now, ExpiresAt: now.Add(lockLease)}
+
+		inserted, err := backend.insertLock(ctx, lock)
+		if err != nil {
+			return nil, nil, err
+		}
+		if inserted {
+			return holdLock(ctx, lock)
+		}
+
+		// Taking over an expired lock
+		takenOver, err := backend.takeOverLock(ctx, lock, now)
+		if err != nil {
+			return nil, nil, err
+		}
+		if takenOver {
+			return holdLock(ctx, lock)
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, nil, fmt.Errorf("waiting for migration lock: %w", ctx.Err())
+		case <-time.After(lockRetry):
+		}
+	}
+}
+
+// holdLock renews the lease on a held lock until it is released. If the lock has been taken
+// over, or renewing keeps failing until the lease is about to run out, the returned context is
+// cancelled with ErrLockLost.
+func holdLock(ctx context.Context, lock lockDocument) (context.Context, func(), error) {
+	ctx, cancel := context.WithCancelCause(ctx)
+	stopped := make(chan struct{})
+
+	go func() {
+		defer close(stopped)
+		ticker := time.NewTicker(lockRenewal)
+		defer ticker.Stop()
+
+		expiresAt := lock.ExpiresAt
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+
+			now := time.Now()
+			held, err := backend.renewLock(ctx, lock.Owner, now.Add(lockLease))
+			switch {
+			case err == nil && held:
+				expiresAt = now.Add(lockLease)
+			case err == nil:
+				cancel(ErrLockLost)
+				return
+			case time.Now().Add(lockRenewal).After(expiresAt):
+				// Giving up before the lease runs out so no other instance overlaps with us
+				cancel(fmt.Errorf("%w: %v", ErrLockLost, err))
+				return
+			}
+		}
+	}()
+
+	release := func() {
+		cancel(nil)
+		<-stopped
+		backend.releaseLock(context.Background(), lock.Owner)
+	}
+	return ctx, release, nil
+}
+
+func lockOwner() string {
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "unknown"
+	}
+	return fmt.Sprintf("%s/%d/%d", hostname, os.Getpid(), time.Now().UnixNano())
+}
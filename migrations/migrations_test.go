@@ -0,0 +1,311 @@
+package migrations
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// memoryStore keeps the migration state in memory, following the semantics of mongoStore.
+type memoryStore struct {
+	mu      sync.Mutex
+	lock    *lockDocument
+	applied map[int]appliedMigration
+}
+
+func (s *memoryStore) insertLock(ctx context.Context, lock lockDocument) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.lock != nil {
+		return false, nil
+	}
+	s.lock = &lock
+	return true, nil
+}
+
+func (s *memoryStore) takeOverLock(ctx context.Context, lock lockDocument, now time.Time) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.lock == nil || !s.lock.ExpiresAt.Before(now) {
+		return false, nil
+	}
+	s.lock = &lock
+	return true, nil
+}
+
+func (s *memoryStore) renewLock(ctx context.Context, owner string, expiresAt time.Time) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.lock == nil || s.lock.Owner != owner {
+		return false, nil
+	}
+	s.lock.ExpiresAt = expiresAt
+	return true, nil
+}
+
+func (s *memoryStore) releaseLock(ctx context.Context, owner string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.lock != nil && s.lock.Owner == owner {
+		s.lock = nil
+	}
+	return nil
+}
+
+func (s *memoryStore) appliedMigrations(ctx context.Context) ([]appliedMigration, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	records := make([]appliedMigration, 0, len(s.applied))
+	for _, record := range s.applied {
+		records = append(records, record)
+	}
+	return records, nil
+}
+
+func (s *memoryStore) markApplied(ctx context.Context, record appliedMigration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.applied[record.Version] = record
+	return nil
+}
+
+func (s *memoryStore) markRolledBack(ctx context.Context, version int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.applied, version)
+	return nil
+}
+
+// heldBy returns the owner of the lock, or "" when nobody holds it.
+func (s *memoryStore) heldBy() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.lock == nil {
+		return ""
+	}
+	return s.lock.Owner
+}
+
+// expiresAt returns when the lease of the held lock runs out.
+func (s *memoryStore) expiresAt() time.Time {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.lock.ExpiresAt
+}
+
+// waitFor polls condition until it holds, reporting false if it still doesn't by deadline.
+func waitFor(condition func() bool, deadline time.Time) bool {
+	for !condition() {
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(time.Millisecond)
+	}
+	return true
+}
+
+// testMigrations records the order migration steps run in.
+type testMigrations struct {
+	mu  sync.Mutex
+	ran []string
+}
+
+func (tm *testMigrations) step(name string, err error) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		tm.mu.Lock()
+		tm.ran = append(tm.ran, name)
+		tm.mu.Unlock()
+		return err
+	}
+}
+
+// useMemoryStore runs the test against an empty memoryStore, with migrations and short lock timings.
+func useMemoryStore(t *testing.T, migrations []Migration) *memoryStore {
+	t.Helper()
+	s := &memoryStore{applied: make(map[int]appliedMigration)}
+
+	previousBackend, previousAll := backend, all
+	previousLease, previousRenewal, previousRetry := lockLease, lockRenewal, lockRetry
+	t.Cleanup(func() {
+		backend, all = previousBackend, previousAll
+		lockLease, lockRenewal, lockRetry = previousLease, previousRenewal, previousRetry
+	})
+
+	backend, all = s, migrations
+	lockLease, lockRenewal, lockRetry = 200*time.Millisecond, 20*time.Millisecond, 10*time.Millisecond
+	return s
+}
+
+func versions(migrations []Migration) []int {
+	var v []int
+	for _, m := range migrations {
+		v = append(v, m.Version)
+	}
+	return v
+}
+
+func TestUpSkipsAppliedMigrations(t *testing.T) {
+	tm := &testMigrations{}
+	s := useMemoryStore(t, []Migration{
+		{Version: 1, Up: tm.step("up 1", nil)},
+		{Version: 2, Up: tm.step("up 2", nil)},
+		{Version: 3, Up: tm.step("up 3", nil)},
+	})
+	s.applied[2] = appliedMigration{Version: 2}
+
+	ran, err := Up(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := versions(ran); !reflect.DeepEqual(got, []int{1, 3}) {
+		t.Errorf("Up() ran %v, want [1 3]", got)
+	}
+	if !reflect.DeepEqual(tm.ran, []string{"up 1", "up 3"}) {
+		t.Errorf("steps ran %v, want up 1 then up 3", tm.ran)
+	}
+	if len(s.applied) != 3 {
+		t.Errorf("applied = %v, want all three", s.applied)
+	}
+	if owner := s.heldBy(); owner != "" {
+		t.Errorf("lock still held by %s after Up", owner)
+	}
+
+	// Nothing is left to do the second time
+	if ran, err := Up(context.Background()); len(ran) != 0 || err != nil {
+		t.Errorf("second Up() = %v, %v, want nothing to run", versions(ran), err)
+	}
+}
+
+func TestUpStopsAtFailingMigration(t *testing.T) {
+	tm := &testMigrations{}
+	failure := errors.New("index build failed")
+	s := useMemoryStore(t, []Migration{
+		{Version: 1, Up: tm.step("up 1", nil)},
+		{Version: 2, Up: tm.step("up 2", failure)},
+		{Version: 3, Up: tm.step("up 3", nil)},
+	})
+
+	ran, err := Up(context.Background())
+	if !errors.Is(err, failure) {
+		t.Errorf("Up() error = %v, want the failing step's", err)
+	}
+	if got := versions(ran); !reflect.DeepEqual(got, []int{1}) {
+		t.Errorf("Up() ran %v, want [1]", got)
+	}
+	if _, ok := s.applied[2]; ok {
+		t.Error("failed migration recorded as applied")
+	}
+	if _, ok := s.applied[3]; ok || len(tm.ran) != 2 {
+		t.Errorf("steps ran %v, want none after the failure", tm.ran)
+	}
+}
+
+func TestDownRollsBackNewestFirst(t *testing.T) {
+	tm := &testMigrations{}
+	s := useMemoryStore(t, []Migration{
+		{Version: 1, Up: tm.step("up 1", nil), Down: tm.step("down 1", nil)},
+		{Version: 2, Up: tm.step("up 2", nil)},
+		{Version: 3, Up: tm.step("up 3", nil), Down: tm.step("down 3", nil)},
+		{Version: 4, Up: tm.step("up 4", nil), Down: tm.step("down 4", nil)},
+	})
+	for _, v := range []int{1, 2, 3} {
+		s.applied[v] = appliedMigration{Version: v}
+	}
+
+	// Migration 4 was never applied, and migration 2 has nothing to undo
+	rolledBack, err := Down(context.Background(), 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := versions(rolledBack); !reflect.DeepEqual(got, []int{3, 2}) {
+		t.Errorf("Down(2) rolled back %v, want [3 2]", got)
+	}
+	if !reflect.DeepEqual(tm.ran, []string{"down 3"}) {
+		t.Errorf("steps ran %v, want only down 3", tm.ran)
+	}
+	if _, ok := s.applied[1]; !ok || len(s.applied) != 1 {
+		t.Errorf("applied = %v, want only migration 1 left", s.applied)
+	}
+
+	if _, err := Down(context.Background(), 5); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Down(context.Background(), 1); !errors.Is(err, ErrNothingToRollBack) {
+		t.Errorf("Down() with nothing applied = %v, want ErrNothingToRollBack", err)
+	}
+}
+
+func TestAcquireLockWaitsForHolder(t *testing.T) {
+	s := useMemoryStore(t, nil)
+	s.lock = &lockDocument{ID: lockID, Owner: "other", ExpiresAt: time.Now().Add(time.Hour)}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, _, err := acquireLock(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("acquireLock() error = %v, want to give up waiting", err)
+	}
+	if owner := s.heldBy(); owner != "other" {
+		t.Errorf("lock held by %q, want other", owner)
+	}
+}
+
+func TestAcquireLockTakesOverExpiredLock(t *testing.T) {
+	s := useMemoryStore(t, nil)
+	s.lock = &lockDocument{ID: lockID, Owner: "crashed", ExpiresAt: time.Now().Add(-time.Second)}
+
+	_, release, err := acquireLock(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	owner := s.heldBy()
+	if owner == "" || owner == "crashed" {
+		t.Fatalf("lock held by %q, want it taken over", owner)
+	}
+
+	// The lease is renewed while the lock is held, so it outlives its first expiry
+	first := s.expiresAt()
+	if !waitFor(func() bool { return s.expiresAt().After(first) }, time.Now().Add(5*lockRenewal)) {
+		t.Error("lease not renewed")
+	}
+
+	release()
+	if owner := s.heldBy(); owner != "" {
+		t.Errorf("lock held by %q after release", owner)
+	}
+}
+
+func TestLosingLockAbortsMigrations(t *testing.T) {
+	tm := &testMigrations{}
+	started := make(chan struct{})
+	s := useMemoryStore(t, []Migration{
+		{Version: 1, Up: func(ctx context.Context) error {
+			close(started)
+			<-ctx.Done()
+			return ctx.Err()
+		}},
+		{Version: 2, Up: tm.step("up 2", nil)},
+	})
+
+	go func() {
+		<-started
+		// Another instance takes the lock over, as if our lease had run out
+		s.mu.Lock()
+		s.lock.Owner = "other"
+		s.mu.Unlock()
+	}()
+
+	ran, err := Up(context.Background())
+	if !errors.Is(err, ErrLockLost) {
+		t.Errorf("Up() error = %v, want ErrLockLost", err)
+	}
+	if len(ran) != 0 || len(tm.ran) != 0 || len(s.applied) != 0 {
+		t.Errorf("Up() ran %v and steps %v after losing the lock", versions(ran), tm.ran)
+	}
+	// Releasing doesn't remove the lock of the instance that took it over
+	if owner := s.heldBy(); owner != "other" {
+		t.Errorf("lock held by %q, want other", owner)
+	}
+}
@@ -0,0 +1,43 @@
+package migrations
+
+import (
+	"context"
+
+	"github.com/Aman913k/database"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// oidcStateTTL is how long a started OIDC login can be completed
+const oidcStateTTL = 600
+
+// createOIDCIndexes makes each identity provider account link to at most one user and
+// expires OIDC logins that were started but never completed.
+func createOIDCIndexes(ctx context.Context) error {
+	_, err := database.GetCollection("schooguser").Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "oidc_issuer", Value: 1}, {Key: "oidc_subject", Value: 1}},
+		Options: options.Index().
+			SetName("oidc_identity_unique").
+			SetUnique(true).
+			SetPartialFilterExpression(bson.M{"oidc_subject": bson.M{"$exists": true}}),
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = database.GetCollection("schoogoidcstate").Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "created_at", Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(oidcStateTTL),
+	})
+	return err
+}
+
+// dropOIDCIndexes drops the indexes created by createOIDCIndexes.
+func dropOIDCIndexes(ctx context.Context) error {
+	if _, err := database.GetCollection("schooguser").Indexes().DropOne(ctx, "oidc_identity_unique"); err != nil {
+		return err
+	}
+	_, err := database.GetCollection("schoogoidcstate").Indexes().DropOne(ctx, "created_at_1")
+	return err
+}
@@ -0,0 +1,80 @@
+package migrations
+
+import (
+	"context"
+
+	"github.com/Aman913k/database"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// backfillPostAuthors links posts created before posts referenced their author by ID.
+// Each post is matched to its user by email, gets the user's ID as author_id and has
+// its denormalised author name and email refreshed from the user record. Emails are
+// compared case-insensitively, because migration 1 has already lowercased the users'
+// emails but not those of posts that had no author_id yet.
+func backfillPostAuthors(ctx context.Context) error {
+	users := database.GetCollection("schooguser")
+	posts := database.GetCollection("schoogpost")
+
+	cursor, err := users.Find(ctx, bson.D{})
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		var user struct {
+			ID    primitive.ObjectID `bson:"_id"`
+			Name  string             `bson:"name"`
+			Email string             `bson:"email"`
+		}
+		if err := cursor.Decode(&user); err != nil {
+			return err
+		}
+
+		_, err := posts.UpdateMany(ctx, bson.M{"email": user.Email}, bson.M{"$set": bson.M{
+			"author_id": user.ID,
+			"author":    user.Name,
+			"email":     user.Email,
+		}}, options.Update().SetCollation(&options.Collation{Locale: "en", Strength: 2}))
+		if err != nil {
+			return err
+		}
+	}
+
+	return cursor.Err()
+}
+
+// createAPIIndexes creates the indexes backing the API's lookups: users by email,
+// posts by author (used by author propagation) and posts by creation time.
+func createAPIIndexes(ctx context.Context) error {
+	_, err := database.GetCollection("schooguser").Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "email", Value: 1}},
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = database.GetCollection("schoogpost").Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "author_id", Value: 1}}},
+		{Keys: bson.D{{Key: "created_at", Value: -1}}},
+	})
+	return err
+}
+
+// dropAPIIndexes drops the indexes created by createAPIIndexes.
+func dropAPIIndexes(ctx context.Context) error {
+	if _, err := database.GetCollection("schooguser").Indexes().DropOne(ctx, "email_1"); err != nil {
+		return err
+	}
+
+	postIndexes := database.GetCollection("schoogpost").Indexes()
+	if _, err := postIndexes.DropOne(ctx, "author_id_1"); err != nil {
+		return err
+	}
+	_, err := postIndexes.DropOne(ctx, "created_at_-1")
+	return err
+}
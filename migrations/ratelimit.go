@@ -0,0 +1,26 @@
+package migrations
+
+import (
+	"context"
+
+	"github.com/Aman913k/database"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// createRateLimitTTLIndex removes rate limit buckets once they have refilled, as a missing
+// bucket is the same as a full one.
+func createRateLimitTTLIndex(ctx context.Context) error {
+	_, err := database.GetCollection("schoogratelimit").Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "expires_at", Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(0),
+	})
+	return err
+}
+
+// dropRateLimitTTLIndex drops the index created by createRateLimitTTLIndex.
+func dropRateLimitTTLIndex(ctx context.Context) error {
+	_, err := database.GetCollection("schoogratelimit").Indexes().DropOne(ctx, "expires_at_1")
+	return err
+}
@@ -0,0 +1,30 @@
+package migrations
+
+import (
+	"context"
+
+	"github.com/Aman913k/database"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// createSessionIndexes indexes sessions by owner for listing and removes them once their
+// tokens have expired.
+func createSessionIndexes(ctx context.Context) error {
+	_, err := database.GetCollection("schoogsession").Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "last_seen_at", Value: -1}}},
+		{Keys: bson.D{{Key: "expires_at", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(0)},
+	})
+	return err
+}
+
+// dropSessionIndexes drops the indexes created by createSessionIndexes.
+func dropSessionIndexes(ctx context.Context) error {
+	indexes := database.GetCollection("schoogsession").Indexes()
+	if _, err := indexes.DropOne(ctx, "user_id_1_last_seen_at_-1"); err != nil {
+		return err
+	}
+	_, err := indexes.DropOne(ctx, "expires_at_1")
+	return err
+}
@@ -0,0 +1,89 @@
+package migrations
+
+import (
+	"context"
+	"time"
+
+	"github.com/Aman913k/database"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// store keeps the migration lock and the record of applied migrations.
+type store interface {
+	// insertLock takes the lock if nobody holds it, reporting whether it did
+	insertLock(ctx context.Context, lock lockDocument) (bool, error)
+	// takeOverLock replaces the lock if its lease ran out before now, reporting whether it did
+	takeOverLock(ctx context.Context, lock lockDocument, now time.Time) (bool, error)
+	// renewLock extends the lease of the lock if owner still holds it, reporting whether it does
+	renewLock(ctx context.Context, owner string, expiresAt time.Time) (bool, error)
+	// releaseLock gives up the lock if owner still holds it
+	releaseLock(ctx context.Context, owner string) error
+
+	appliedMigrations(ctx context.Context) ([]appliedMigration, error)
+	markApplied(ctx context.Context, record appliedMigration) error
+	markRolledBack(ctx context.Context, version int) error
+}
+
+// backend is where migrations record their state
+var backend store = mongoStore{}
+
+// mongoStore keeps the lock and the applied migrations in their own collections.
+type mongoStore struct{}
+
+func (mongoStore) insertLock(ctx context.Context, lock lockDocument) (bool, error) {
+	_, err := database.GetCollection(lockColName).InsertOne(ctx, lock)
+	if mongo.IsDuplicateKeyError(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (mongoStore) takeOverLock(ctx context.Context, lock lockDocument, now time.Time) (bool, error) {
+	err := database.GetCollection(lockColName).FindOneAndReplace(ctx,
+		bson.M{"_id": lockID, "expires_at": bson.M{"$lt": now}},
+		lock,
+	).Err()
+	if err == mongo.ErrNoDocuments {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (mongoStore) renewLock(ctx context.Context, owner string, expiresAt time.Time) (bool, error) {
+	result, err := database.GetCollection(lockColName).UpdateOne(ctx,
+		bson.M{"_id": lockID, "owner": owner},
+		bson.M{"$set": bson.M{"expires_at": expiresAt}},
+	)
+	if err != nil {
+		return false, err
+	}
+	return result.MatchedCount == 1, nil
+}
+
+func (mongoStore) releaseLock(ctx context.Context, owner string) error {
+	_, err := database.GetCollection(lockColName).DeleteOne(ctx, bson.M{"_id": lockID, "owner": owner})
+	return err
+}
+
+func (mongoStore) appliedMigrations(ctx context.Context) ([]appliedMigration, error) {
+	cursor, err := database.GetCollection(appliedColName).Find(ctx, bson.D{})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var records []appliedMigration
+	err = cursor.All(ctx, &records)
+	return records, err
+}
+
+func (mongoStore) markApplied(ctx context.Context, record appliedMigration) error {
+	_, err := database.GetCollection(appliedColName).InsertOne(ctx, record)
+	return err
+}
+
+func (mongoStore) markRolledBack(ctx context.Context, version int) error {
+	_, err := database.GetCollection(appliedColName).DeleteOne(ctx, bson.M{"_id": version})
+	return err
+}
@@ -0,0 +1,123 @@
+package migrations
+
+import (
+	"context"
+
+	"github.com/Aman913k/database"
+	"github.com/Aman913k/utils"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// migrateUserIdentity brings existing user records in line with ObjectID identities.
+// It drops the legacy numeric id field, lowercases stored emails (and the copies in
+// posts already linked to the user by author_id) and creates the case-insensitive
+// unique index on email that Register relies on. Legacy posts without an author_id
+// are linked and normalised by backfillPostAuthors. Index creation fails if two
+// accounts differ only by case; those have to be merged by hand before re-running
+// the migration.
+// The data changes are not undone on the way down, only the index is dropped.
+func migrateUserIdentity(ctx context.Context) error {
+	users := database.GetCollection("schooguser")
+	posts := database.GetCollection("schoogpost")
+
+	_, err := users.UpdateMany(ctx, bson.M{"id": bson.M{"$exists": true}}, bson.M{"$unset": bson.M{"id": ""}})
+	if err != nil {
+		return err
+	}
+
+	cursor, err := users.Find(ctx, bson.D{})
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		var user struct {
+			ID    primitive.ObjectID `bson:"_id"`
+			Email string             `bson:"email"`
+		}
+		if err := cursor.Decode(&user); err != nil {
+			return err
+		}
+
+		email := utils.NormalizeEmail(user.Email)
+		if email == user.Email {
+			continue
+		}
+
+		_, err := users.UpdateOne(ctx, bson.M{"_id": user.ID}, bson.M{"$set": bson.M{"email": email}})
+		if err != nil {
+			return err
+		}
+		_, err = posts.UpdateMany(ctx, bson.M{"author_id": user.ID}, bson.M{"$set": bson.M{"email": email}})
+		if err != nil {
+			return err
+		}
+	}
+	if err := cursor.Err(); err != nil {
+		return err
+	}
+
+	_, err = users.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "email", Value: 1}},
+		Options: options.Index().
+			SetName("email_unique_ci").
+			SetUnique(true).
+			SetCollation(&options.Collation{Locale: "en", Strength: 2}),
+	})
+	return err
+}
+
+// dropUserEmailIndex drops the unique email index created by migrateUserIdentity.
+func dropUserEmailIndex(ctx context.Context) error {
+	_, err := database.GetCollection("schooguser").Indexes().DropOne(ctx, "email_unique_ci")
+	return err
+}
+
+// createDeletionIndex indexes the accounts awaiting deletion for the purge job.
+func createDeletionIndex(ctx context.Context) error {
+	_, err := database.GetCollection("schooguser").Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "deletion_scheduled_at", Value: 1}},
+		Options: options.Index().SetSparse(true),
+	})
+	return err
+}
+
+// dropDeletionIndex drops the index created by createDeletionIndex.
+func dropDeletionIndex(ctx context.Context) error {
+	_, err := database.GetCollection("schooguser").Indexes().DropOne(ctx, "deletion_scheduled_at_1")
+	return err
+}
+
+// createLockoutTTLIndex lets MongoDB remove login failure counters a week after the last failure.
+func createLockoutTTLIndex(ctx context.Context) error {
+	_, err := database.GetCollection("schooglockout").Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "last_failure", Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(7 * 24 * 60 * 60),
+	})
+	return err
+}
+
+// dropLockoutTTLIndex drops the index created by createLockoutTTLIndex.
+func dropLockoutTTLIndex(ctx context.Context) error {
+	_, err := database.GetCollection("schooglockout").Indexes().DropOne(ctx, "last_failure_1")
+	return err
+}
+
+// createUnlockTokenTTLIndex removes unlock links once they expire.
+func createUnlockTokenTTLIndex(ctx context.Context) error {
+	_, err := database.GetCollection("schoogunlocktoken").Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "expires_at", Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(0),
+	})
+	return err
+}
+
+// dropUnlockTokenTTLIndex drops the index created by createUnlockTokenTTLIndex.
+func dropUnlockTokenTTLIndex(ctx context.Context) error {
+	_, err := database.GetCollection("schoogunlocktoken").Indexes().DropOne(ctx, "expires_at_1")
+	return err
+}
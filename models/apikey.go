@@ -0,0 +1,29 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// APIKey is a personal access key a user creates for scripts and automation.
+// Only the hash of the key is stored; the key itself is shown once when it is created.
+// @Description API key model
+// @Name APIKey
+type APIKey struct {
+	ID         primitive.ObjectID `json:"id,omitempty" bson:"_id,omitempty"`
+	UserID     primitive.ObjectID `json:"-" bson:"user_id"`
+	Name       string             `json:"name" bson:"name"`
+	Prefix     string             `json:"prefix" bson:"prefix"`
+	Hash       string             `json:"-" bson:"hash"`
+	Scopes     []string           `json:"scopes" bson:"scopes"`
+	CreatedAt  time.Time          `json:"created_at" bson:"created_at"`
+	LastUsedAt *time.Time         `json:"last_used_at,omitempty" bson:"last_used_at,omitempty"`
+	RevokedAt  *time.Time         `json:"revoked_at,omitempty" bson:"revoked_at,omitempty"`
+}
+
+// CreateAPIKeyRequest is the body for creating an API key.
+type CreateAPIKeyRequest struct {
+	Name   string   `json:"name" validate:"required,max=100"`
+	Scopes []string `json:"scopes" validate:"required,max=3,dive,oneof=posts:write profile:read profile:write" enums:"posts:write,profile:read,profile:write"`
+}
@@ -10,6 +10,7 @@ import (
 // @Description Post model
 // @Name Post
 // @Property id objectId `json:"id,omitempty" bson:"_id,omitempty"`
+// @Property author_id objectId `json:"author_id,omitempty" bson:"author_id,omitempty"` // ID of the user who wrote the post
 // @Property email string `json:"email,omitempty"`
 // @Property title string `json:"title"`
 // @Property content string `json:"content"`
@@ -18,6 +19,7 @@ import (
 // @Property updated_at string `json:"updated_at"` // Date when the post was last updated
 type Post struct {
 	ID         primitive.ObjectID `json:"id,omitempty" bson:"_id,omitempty"`
+	AuthorID   primitive.ObjectID `json:"author_id,omitempty" bson:"author_id,omitempty"`
 	Email      string             `json:"email,omitempty"`
 	Title      string             `json:"title"`
 	Content    string             `json:"content"`
@@ -26,8 +28,13 @@ type Post struct {
 	Updated_AT time.Time          `json:"updated_at"`
 }
 
+// PostRequest is the body for creating or updating a post.
+type PostRequest struct {
+	Title   string `json:"title" validate:"required,max=200"`
+	Content string `json:"content" validate:"required,max=50000"`
+}
 
 // type UpdatePostResponse struct {
 //     Message string `json:"message"`
 //     User    User   `json:"user"`
-// }
\ No newline at end of file
+// }
\ No newline at end of file
@@ -0,0 +1,26 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Session is one login of a user on a device. Every access token belongs to a session
+// and stops working when the session is revoked.
+// @Description Session model
+// @Name Session
+type Session struct {
+	ID         primitive.ObjectID `json:"id,omitempty" bson:"_id,omitempty"`
+	UserID     primitive.ObjectID `json:"-" bson:"user_id"`
+	UserAgent  string             `json:"user_agent" bson:"user_agent"`
+	IP         string             `json:"ip" bson:"ip"`
+	CreatedAt  time.Time          `json:"created_at" bson:"created_at"`
+	LastSeenAt time.Time          `json:"last_seen_at" bson:"last_seen_at"`
+	ExpiresAt  time.Time          `json:"expires_at" bson:"expires_at"`
+	RevokedAt  *time.Time         `json:"revoked_at,omitempty" bson:"revoked_at,omitempty"`
+	// CSRFTokenHash is the hash of the CSRF token of a cookie session
+	CSRFTokenHash string `json:"-" bson:"csrf_token_hash,omitempty"`
+	// Current marks the session the listing request was made with
+	Current bool `json:"current" bson:"-"`
+}
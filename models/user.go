@@ -1,28 +1,132 @@
 package models
 
-import "golang.org/x/crypto/bcrypt"
+import (
+	"time"
+
+	"github.com/Aman913k/utils"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
 
 // User represents the user model for the application.
 // @Description User model
 // @Name User
-// @Property id int64 `json:"id,omitempty" bson:"id,omitempty"`
+// @Property id objectId `json:"id,omitempty" bson:"_id,omitempty"`
 // @Property name string `json:"name,omitempty"`
 // @Property email string `json:"email,omitempty"`
-// @Property password string `json:"password,omitempty"`
+// @Property deletion_requested_at string `json:"deletion_requested_at,omitempty"` // Date when account deletion was requested
+// @Property deletion_scheduled_at string `json:"deletion_scheduled_at,omitempty"` // Date when the account will be deleted
+// @Property totp_enabled bool `json:"totp_enabled,omitempty"` // Whether logins require a TOTP code
+// @Property role string `json:"role,omitempty"` // "admin" for administrators, empty for regular users
+// @Property suspended_at string `json:"suspended_at,omitempty"` // Date when an administrator suspended the account
 type User struct {
-	ID       int64  `json:"id,omitempty" bson:"id,omitempty"`
-	Name     string `json:"name,omitempty"`
-	Email    string `json:"email,omitempty"`
-	Password string `json:"password,omitempty"`
+	ID    primitive.ObjectID `json:"id,omitempty" bson:"_id,omitempty"`
+	Name  string             `json:"name,omitempty"`
+	Email string             `json:"email,omitempty"`
+	// Password is the hash of the password. It is a credential and never leaves the server.
+	Password            string     `json:"-"`
+	DeletionRequestedAt *time.Time `json:"deletion_requested_at,omitempty" bson:"deletion_requested_at,omitempty"`
+	DeletionScheduledAt *time.Time `json:"deletion_scheduled_at,omitempty" bson:"deletion_scheduled_at,omitempty"`
+
+	// TOTPSecret is set once enrolment starts and only takes effect when TOTPEnabled is confirmed
+	TOTPSecret    string   `json:"-" bson:"totp_secret,omitempty"`
+	TOTPEnabled   bool     `json:"totp_enabled,omitempty" bson:"totp_enabled,omitempty"`
+	TOTPLastStep  int64    `json:"-" bson:"totp_last_step,omitempty"`
+	RecoveryCodes []string `json:"-" bson:"recovery_codes,omitempty"`
+
+	// Role is empty for regular users
+	Role             string     `json:"role,omitempty" bson:"role,omitempty"`
+	SuspendedAt      *time.Time `json:"suspended_at,omitempty" bson:"suspended_at,omitempty"`
+	SuspensionReason string     `json:"suspension_reason,omitempty" bson:"suspension_reason,omitempty"`
+	// PasswordResetRequired blocks logins and API keys until the password is reset through the emailed link
+	PasswordResetRequired bool `json:"password_reset_required,omitempty" bson:"password_reset_required,omitempty"`
+
+	// OIDCIssuer and OIDCSubject link the account to an identity provider login
+	OIDCIssuer  string `json:"-" bson:"oidc_issuer,omitempty"`
+	OIDCSubject string `json:"-" bson:"oidc_subject,omitempty"`
 }
 
-type UpdateUserResponse struct {
-    Message string `json:"message"`
-    User    User   `json:"user"`
+// RegisterRequest is the body for registering a user.
+type RegisterRequest struct {
+	Name     string `json:"name" validate:"required,max=100"`
+	Email    string `json:"email" validate:"required,email,max=254" format:"email"`
+	Password string `json:"password" validate:"required"`
+}
+
+// LoginRequest is the body for logging in with a password.
+type LoginRequest struct {
+	Email    string `json:"email" validate:"required,max=254" format:"email"`
+	Password string `json:"password" validate:"required"`
+}
+
+// UpdateProfileRequest is the body for updating a user's profile.
+type UpdateProfileRequest struct {
+	Name string `json:"name" validate:"required,max=100"`
+}
+
+// User roles. Regular users have no role stored, RoleUser is only used to filter and assign.
+const (
+	RoleUser  = "user"
+	RoleAdmin = "admin"
+)
+
+// SuspendUserRequest is the body for suspending a user.
+type SuspendUserRequest struct {
+	Reason string `json:"reason" validate:"max=500"`
+}
+
+// ChangeRoleRequest is the body for changing a user's role.
+type ChangeRoleRequest struct {
+	Role string `json:"role" validate:"required,oneof=user admin"`
+}
+
+// ResetPasswordRequest sets a new password with the token from a password reset email.
+type ResetPasswordRequest struct {
+	Token       string `json:"token" validate:"required"`
+	NewPassword string `json:"new_password" validate:"required"`
+}
+
+// MagicLinkRequest asks for a sign-in link to be emailed.
+type MagicLinkRequest struct {
+	Email string `json:"email" validate:"required,email,max=254" format:"email"`
+}
+
+// VerifyMagicLinkRequest exchanges the token from a sign-in link for a login.
+type VerifyMagicLinkRequest struct {
+	Token string `json:"token" validate:"required"`
+}
+
+// ChangePasswordRequest is the body of a password change.
+type ChangePasswordRequest struct {
+	CurrentPassword string `json:"current_password" validate:"required"`
+	NewPassword     string `json:"new_password" validate:"required"`
 }
 
+// MFACodeRequest carries a TOTP code, or a recovery code in its place.
+type MFACodeRequest struct {
+	Code         string `json:"code,omitempty"`
+	RecoveryCode string `json:"recovery_code,omitempty"`
+}
+
+// MFALoginRequest completes a login that returned an MFA challenge.
+type MFALoginRequest struct {
+	MFAToken     string `json:"mfa_token" validate:"required"`
+	Code         string `json:"code,omitempty"`
+	RecoveryCode string `json:"recovery_code,omitempty"`
+}
+
+// DisableMFARequest confirms turning off two-factor authentication.
+type DisableMFARequest struct {
+	Password     string `json:"password" validate:"required"`
+	Code         string `json:"code,omitempty"`
+	RecoveryCode string `json:"recovery_code,omitempty"`
+}
+
+type UpdateUserResponse struct {
+	Message string `json:"message"`
+	User    User   `json:"user"`
+}
 
+// HashPassword hashes a password with the configured password hasher.
 func HashPassword(password string) (string, error) {
-	bytes, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
-	return string(bytes), err
+	return utils.Passwords.Hash(password)
 }
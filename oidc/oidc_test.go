@@ -0,0 +1,208 @@
+package oidc_test
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/Aman913k/oidc"
+	"github.com/Aman913k/oidc/oidctest"
+)
+
+const (
+	clientID     = "schooglink"
+	clientSecret = "secret"
+	redirectURL  = "http://app.test/login/oidc/callback"
+)
+
+var student = oidctest.User{
+	Subject:       "student-1",
+	Email:         "student@example.edu",
+	EmailVerified: true,
+	Name:          "Test Student",
+}
+
+func newProvider(t *testing.T) (*oidctest.Issuer, *oidc.Provider) {
+	t.Helper()
+	issuer, server, err := oidctest.NewServer(clientID, clientSecret, student)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(server.Close)
+
+	provider := oidc.NewProvider(oidc.Config{
+		Issuer:       issuer.URL,
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		RedirectURL:  redirectURL,
+	})
+	return issuer, provider
+}
+
+// authorize opens the authorization URL at the issuer and returns the query of the redirect back to the app.
+func authorize(t *testing.T, issuer http.Handler, authURL string) url.Values {
+	t.Helper()
+	recorder := httptest.NewRecorder()
+	issuer.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, authURL, nil))
+	if recorder.Code != http.StatusFound {
+		t.Fatalf("authorize returned %d: %s", recorder.Code, recorder.Body)
+	}
+
+	location, err := url.Parse(recorder.Header().Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(location.String(), redirectURL+"?") {
+		t.Fatalf("redirected to %s, want %s", location, redirectURL)
+	}
+	return location.Query()
+}
+
+func TestLoginFlow(t *testing.T) {
+	issuer, provider := newProvider(t)
+	ctx := context.Background()
+
+	verifier, challenge, err := oidc.NewPKCE()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256([]byte(verifier))
+	if challenge != base64.RawURLEncoding.EncodeToString(sum[:]) {
+		t.Fatal("code challenge is not the S256 hash of the verifier")
+	}
+
+	authURL, err := provider.AuthCodeURL(ctx, "the-state", "the-nonce", challenge)
+	if err != nil {
+		t.Fatal(err)
+	}
+	params, _ := url.Parse(authURL)
+	if got := params.Query().Get("code_challenge_method"); got != "S256" {
+		t.Errorf("code_challenge_method = %q, want S256", got)
+	}
+
+	callback := authorize(t, issuer, authURL)
+	if got := callback.Get("state"); got != "the-state" {
+		t.Errorf("state = %q, want the-state", got)
+	}
+
+	rawIDToken, err := provider.Exchange(ctx, callback.Get("code"), verifier)
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims, err := provider.VerifyIDToken(ctx, rawIDToken, "the-nonce")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims.Subject != student.Subject || claims.Email != student.Email || !claims.EmailVerified {
+		t.Errorf("claims = %+v, want the signed-in student", claims)
+	}
+
+	// Codes can only be redeemed once
+	if _, err := provider.Exchange(ctx, callback.Get("code"), verifier); err == nil {
+		t.Error("redeeming a code twice succeeded")
+	}
+}
+
+func TestExchangeRejectsWrongVerifier(t *testing.T) {
+	issuer, provider := newProvider(t)
+	ctx := context.Background()
+
+	_, challenge, err := oidc.NewPKCE()
+	if err != nil {
+		t.Fatal(err)
+	}
+	authURL, err := provider.AuthCodeURL(ctx, "state", "nonce", challenge)
+	if err != nil {
+		t.Fatal(err)
+	}
+	callback := authorize(t, issuer, authURL)
+
+	otherVerifier, _, _ := oidc.NewPKCE()
+	if _, err := provider.Exchange(ctx, callback.Get("code"), otherVerifier); err == nil {
+		t.Error("exchange with another code verifier succeeded")
+	}
+}
+
+func TestVerifyIDTokenRejections(t *testing.T) {
+	issuer, provider := newProvider(t)
+	ctx := context.Background()
+
+	// signIn runs the flow at the given issuer, which may not be the one the provider trusts
+	signIn := func(t *testing.T, at *oidctest.Issuer, nonce string) string {
+		t.Helper()
+		atProvider := oidc.NewProvider(oidc.Config{
+			Issuer:       issuer.URL,
+			ClientID:     at.ClientID,
+			ClientSecret: at.ClientSecret,
+			RedirectURL:  redirectURL,
+			HTTPClient:   &http.Client{Transport: handlerTransport{at}},
+		})
+		verifier, challenge, _ := oidc.NewPKCE()
+		authURL, err := atProvider.AuthCodeURL(ctx, "state", nonce, challenge)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rawIDToken, err := atProvider.Exchange(ctx, authorize(t, at, authURL).Get("code"), verifier)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return rawIDToken
+	}
+
+	// An issuer at the same URL with its own key, as an attacker minting tokens would be
+	forger, err := oidctest.New(issuer.URL, clientID, clientSecret, student)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A token the trusted issuer signed for another application
+	issuer.ClientID = "another-app"
+	forOtherClient := signIn(t, issuer, "nonce")
+	issuer.ClientID = clientID
+
+	tests := []struct {
+		name       string
+		rawIDToken string
+		nonce      string
+		reason     string
+	}{
+		{"nonce mismatch", signIn(t, issuer, "issued-nonce"), "expected-nonce", "nonce mismatch"},
+		{"missing nonce", signIn(t, issuer, ""), "expected-nonce", "nonce mismatch"},
+		{"key not in the JWKS", signIn(t, forger, "nonce"), "nonce", "verification error"},
+		{"issued to another client", forOtherClient, "nonce", "unexpected audience"},
+		{"tampered token", tamper(signIn(t, issuer, "nonce")), "nonce", "verification error"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := provider.VerifyIDToken(ctx, tt.rawIDToken, tt.nonce)
+			if !errors.Is(err, oidc.ErrInvalidIDToken) || !strings.Contains(err.Error(), tt.reason) {
+				t.Errorf("VerifyIDToken() error = %v, want ErrInvalidIDToken for %s", err, tt.reason)
+			}
+		})
+	}
+}
+
+// tamper swaps the payload of a token for one claiming another subject, keeping the signature.
+func tamper(rawIDToken string) string {
+	parts := strings.Split(rawIDToken, ".")
+	payload, _ := base64.RawURLEncoding.DecodeString(parts[1])
+	payload = []byte(strings.Replace(string(payload), student.Subject, "admin", 1))
+	parts[1] = base64.RawURLEncoding.EncodeToString(payload)
+	return strings.Join(parts, ".")
+}
+
+// handlerTransport serves HTTP requests with a handler instead of the network.
+type handlerTransport struct {
+	handler http.Handler
+}
+
+func (t handlerTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	recorder := httptest.NewRecorder()
+	t.handler.ServeHTTP(recorder, r)
+	return recorder.Result(), nil
+}
@@ -0,0 +1,253 @@
+/*
+Package oidctest provides a minimal OpenID Connect issuer for local development and tests.
+It signs in a single configured user without asking for credentials, supports the
+authorization code flow with PKCE and publishes its signing key as a JWKS.
+*/
+package oidctest
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+// User is the identity the issuer signs in.
+type User struct {
+	Subject       string
+	Email         string
+	EmailVerified bool
+	Name          string
+}
+
+// Issuer is an http.Handler serving the discovery, JWKS, authorization and token endpoints.
+type Issuer struct {
+	URL          string
+	ClientID     string
+	ClientSecret string
+
+	mu     sync.Mutex
+	user   User
+	key    *rsa.PrivateKey
+	keyID  string
+	grants map[string]grant
+	mux    *http.ServeMux
+}
+
+// grant is an issued authorization code waiting to be redeemed
+type grant struct {
+	redirectURI   string
+	codeChallenge string
+	nonce         string
+	user          User
+	expiresAt     time.Time
+}
+
+const codeTTL = time.Minute
+
+// New creates an issuer identified by issuerURL, the URL it will be served at.
+func New(issuerURL, clientID, clientSecret string, user User) (*Issuer, error) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, err
+	}
+
+	i := &Issuer{
+		URL:          strings.TrimSuffix(issuerURL, "/"),
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		user:         user,
+		key:          key,
+		keyID:        "mock-1",
+		grants:       make(map[string]grant),
+		mux:          http.NewServeMux(),
+	}
+	i.mux.HandleFunc("/.well-known/openid-configuration", i.discovery)
+	i.mux.HandleFunc("/jwks", i.jwks)
+	i.mux.HandleFunc("/authorize", i.authorize)
+	i.mux.HandleFunc("/token", i.token)
+	return i, nil
+}
+
+// NewServer starts an issuer on a local port. Close the returned server when done.
+func NewServer(clientID, clientSecret string, user User) (*Issuer, *httptest.Server, error) {
+	var issuer *Issuer
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		issuer.ServeHTTP(w, r)
+	}))
+
+	issuer, err := New(server.URL, clientID, clientSecret, user)
+	if err != nil {
+		server.Close()
+		return nil, nil, err
+	}
+	return issuer, server, nil
+}
+
+// SetUser changes the identity signed in by later authorizations.
+func (i *Issuer) SetUser(user User) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.user = user
+}
+
+func (i *Issuer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	i.mux.ServeHTTP(w, r)
+}
+
+func (i *Issuer) discovery(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"issuer":                                i.URL,
+		"authorization_endpoint":                i.URL + "/authorize",
+		"token_endpoint":                        i.URL + "/token",
+		"jwks_uri":                              i.URL + "/jwks",
+		"response_types_supported":              []string{"code"},
+		"subject_types_supported":               []string{"public"},
+		"id_token_signing_alg_values_supported": []string{"RS256"},
+		"code_challenge_methods_supported":      []string{"S256"},
+	})
+}
+
+func (i *Issuer) jwks(w http.ResponseWriter, r *http.Request) {
+	public := i.key.PublicKey
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"keys": []map[string]string{{
+			"kty": "RSA",
+			"use": "sig",
+			"alg": "RS256",
+			"kid": i.keyID,
+			"n":   base64.RawURLEncoding.EncodeToString(public.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(public.E)).Bytes()),
+		}},
+	})
+}
+
+// authorize signs the configured user in straight away and redirects back with a code.
+func (i *Issuer) authorize(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	redirectURI := query.Get("redirect_uri")
+	if query.Get("client_id") != i.ClientID || redirectURI == "" {
+		http.Error(w, "unknown client or missing redirect_uri", http.StatusBadRequest)
+		return
+	}
+	if query.Get("response_type") != "code" || query.Get("code_challenge_method") != "S256" || query.Get("code_challenge") == "" {
+		http.Error(w, "only the code flow with S256 PKCE is supported", http.StatusBadRequest)
+		return
+	}
+
+	code := randomString()
+	i.mu.Lock()
+	i.grants[code] = grant{
+		redirectURI:   redirectURI,
+		codeChallenge: query.Get("code_challenge"),
+		nonce:         query.Get("nonce"),
+		user:          i.user,
+		expiresAt:     time.Now().Add(codeTTL),
+	}
+	i.mu.Unlock()
+
+	target, err := url.Parse(redirectURI)
+	if err != nil {
+		http.Error(w, "invalid redirect_uri", http.StatusBadRequest)
+		return
+	}
+	params := target.Query()
+	params.Set("code", code)
+	params.Set("state", query.Get("state"))
+	target.RawQuery = params.Encode()
+	http.Redirect(w, r, target.String(), http.StatusFound)
+}
+
+// token redeems a code once, checking the client credentials and the PKCE verifier.
+func (i *Issuer) token(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		tokenError(w, "invalid_request")
+		return
+	}
+
+	clientID, clientSecret, ok := r.BasicAuth()
+	if ok {
+		clientID, _ = url.QueryUnescape(clientID)
+		clientSecret, _ = url.QueryUnescape(clientSecret)
+	} else {
+		clientID, clientSecret = r.PostForm.Get("client_id"), r.PostForm.Get("client_secret")
+	}
+	if clientID != i.ClientID || subtle.ConstantTimeCompare([]byte(clientSecret), []byte(i.ClientSecret)) != 1 {
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "invalid_client"})
+		return
+	}
+
+	code := r.PostForm.Get("code")
+	i.mu.Lock()
+	g, found := i.grants[code]
+	delete(i.grants, code)
+	i.mu.Unlock()
+
+	if r.PostForm.Get("grant_type") != "authorization_code" || !found || time.Now().After(g.expiresAt) ||
+		r.PostForm.Get("redirect_uri") != g.redirectURI {
+		tokenError(w, "invalid_grant")
+		return
+	}
+	sum := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+	if base64.RawURLEncoding.EncodeToString(sum[:]) != g.codeChallenge {
+		tokenError(w, "invalid_grant")
+		return
+	}
+
+	now := time.Now()
+	idToken := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
+		"iss":            i.URL,
+		"sub":            g.user.Subject,
+		"aud":            i.ClientID,
+		"iat":            now.Unix(),
+		"exp":            now.Add(5 * time.Minute).Unix(),
+		"nonce":          g.nonce,
+		"email":          g.user.Email,
+		"email_verified": g.user.EmailVerified,
+		"name":           g.user.Name,
+	})
+	idToken.Header["kid"] = i.keyID
+	signed, err := idToken.SignedString(i.key)
+	if err != nil {
+		http.Error(w, "failed to sign token", http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"access_token": randomString(),
+		"token_type":   "Bearer",
+		"expires_in":   300,
+		"id_token":     signed,
+	})
+}
+
+func tokenError(w http.ResponseWriter, code string) {
+	writeJSON(w, http.StatusBadRequest, map[string]string{"error": code})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func randomString() string {
+	b := make([]byte, 24)
+	rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
@@ -0,0 +1,139 @@
+/*
+Package problem writes error responses as RFC 7807 problem details, so that clients can
+tell errors apart by a stable code instead of parsing messages meant for people.
+*/
+package problem
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/Aman913k/utils"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/x/mongo/driver/topology"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// ContentType is the media type of problem responses.
+const ContentType = "application/problem+json"
+
+// Codes identifying the kind of problem. They are part of the API and must not change.
+const (
+	CodeInvalidRequest        = "invalid_request"
+	CodeValidationFailed      = "validation_failed"
+	CodeRequestTooLarge       = "request_too_large"
+	CodeInvalidToken          = "invalid_token"
+	CodeUnauthorized          = "unauthorized"
+	CodeInvalidCredentials    = "invalid_credentials"
+	CodeInvalidMFACode        = "invalid_mfa_code"
+	CodeForbidden             = "forbidden"
+	CodeInsufficientScope     = "insufficient_scope"
+	CodeInvalidCSRFToken      = "invalid_csrf_token"
+	CodeAccountSuspended      = "account_suspended"
+	CodePasswordResetRequired = "password_reset_required"
+	CodeNotFound              = "not_found"
+	CodeMethodNotAllowed      = "method_not_allowed"
+	CodeConflict              = "conflict"
+	CodeEmailInUse            = "email_in_use"
+	CodeAccountLocked         = "account_locked"
+	CodeRateLimited           = "rate_limited"
+	CodeIdentityProvider      = "identity_provider_error"
+	CodeTimeout               = "timeout"
+	CodeUnavailable           = "service_unavailable"
+	CodeClientClosedRequest   = "client_closed_request"
+	CodeInternal              = "internal_error"
+)
+
+// StatusClientClosedRequest is recorded, following nginx, for requests whose client went away
+// before they were answered, so that access logs and metrics tell them apart from failures.
+const StatusClientClosedRequest = 499
+
+// Problem is an RFC 7807 problem details object.
+// @Description Error response in RFC 7807 format
+// @Name Problem
+type Problem struct {
+	// Type is a URI naming the kind of problem, derived from Code
+	Type   string `json:"type"`
+	Title  string `json:"title"`
+	Status int    `json:"status"`
+	Detail string `json:"detail,omitempty"`
+	// Instance is the path of the request that failed
+	Instance  string `json:"instance,omitempty"`
+	Code      string `json:"code"`
+	RequestID string `json:"request_id,omitempty"`
+	// Errors lists the invalid fields of the request, if any
+	Errors []FieldError `json:"errors,omitempty"`
+}
+
+// FieldError explains why one field of a request was rejected.
+// @Description Rejected request field
+// @Name FieldError
+type FieldError struct {
+	Field   string `json:"field"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// New returns a problem with the given status, code and human-readable detail.
+func New(status int, code, detail string) *Problem {
+	return &Problem{
+		Type:   "urn:schooglink:problem:" + code,
+		Title:  http.StatusText(status),
+		Status: status,
+		Detail: detail,
+		Code:   code,
+	}
+}
+
+// Write sends the problem as the response to r.
+func Write(w http.ResponseWriter, r *http.Request, p *Problem) {
+	p.Instance = r.URL.Path
+	p.RequestID = utils.RequestID(r)
+	// Marking the handler's span failed when the server is at fault
+	if p.Status >= http.StatusInternalServerError {
+		trace.SpanFromContext(r.Context()).SetStatus(codes.Error, p.Code)
+	}
+
+	w.Header().Set("Content-Type", ContentType)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(p.Status)
+	json.NewEncoder(w).Encode(p)
+}
+
+// Error replies to r with a problem, like http.Error does with plain text.
+func Error(w http.ResponseWriter, r *http.Request, status int, code, detail string) {
+	Write(w, r, New(status, code, detail))
+}
+
+// Invalid replies to r that the request failed validation, listing the rejected fields.
+func Invalid(w http.ResponseWriter, r *http.Request, detail string, fieldErrors ...FieldError) {
+	p := New(http.StatusBadRequest, CodeValidationFailed, detail)
+	p.Errors = fieldErrors
+	Write(w, r, p)
+}
+
+// FromError returns the problem describing why err kept a request from being served.
+// Unreachable dependencies are reported as 503 and operations that ran out of time as 504,
+// so that clients know to retry; anything else is a 500 with detail.
+func FromError(err error, detail string) *Problem {
+	var selectionErr topology.ServerSelectionError
+	switch {
+	case errors.Is(err, context.Canceled):
+		p := New(StatusClientClosedRequest, CodeClientClosedRequest, "Client closed the request")
+		p.Title = "Client Closed Request"
+		return p
+	case errors.As(err, &selectionErr), mongo.IsNetworkError(err) && !mongo.IsTimeout(err):
+		return New(http.StatusServiceUnavailable, CodeUnavailable, "A service the API depends on is unavailable, try again later")
+	case mongo.IsTimeout(err), errors.Is(err, context.DeadlineExceeded):
+		return New(http.StatusGatewayTimeout, CodeTimeout, "The request took too long to process, try again later")
+	}
+	return New(http.StatusInternalServerError, CodeInternal, detail)
+}
+
+// ServerError replies to r with the problem describing err, see FromError.
+func ServerError(w http.ResponseWriter, r *http.Request, err error, detail string) {
+	Write(w, r, FromError(err, detail))
+}
@@ -0,0 +1,104 @@
+package problem
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/Aman913k/utils"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/x/mongo/driver/topology"
+)
+
+func TestFromError(t *testing.T) {
+	tests := []struct {
+		name   string
+		err    error
+		status int
+		code   string
+	}{
+		{"client went away", fmt.Errorf("finding user: %w", context.Canceled), StatusClientClosedRequest, CodeClientClosedRequest},
+		{"no server to talk to", topology.ServerSelectionError{Wrapped: errors.New("no reachable servers")}, http.StatusServiceUnavailable, CodeUnavailable},
+		{"connection dropped", mongo.CommandError{Labels: []string{"NetworkError"}}, http.StatusServiceUnavailable, CodeUnavailable},
+		{"deadline", fmt.Errorf("finding user: %w", context.DeadlineExceeded), http.StatusGatewayTimeout, CodeTimeout},
+		{"network timeout", mongo.CommandError{Labels: []string{"NetworkError", "NetworkTimeoutError"}}, http.StatusGatewayTimeout, CodeTimeout},
+		{"anything else", errors.New("duplicate key"), http.StatusInternalServerError, CodeInternal},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := FromError(tt.err, "Failed to find user")
+			if p.Status != tt.status || p.Code != tt.code {
+				t.Errorf("FromError() = %d %s, want %d %s", p.Status, p.Code, tt.status, tt.code)
+			}
+			// Only unexpected errors keep the caller's detail; the others explain how to retry
+			if (p.Detail == "Failed to find user") != (tt.status == http.StatusInternalServerError) {
+				t.Errorf("detail = %q", p.Detail)
+			}
+		})
+	}
+
+	if p := FromError(context.Canceled, ""); p.Title != "Client Closed Request" {
+		t.Errorf("499 title = %q, want Client Closed Request", p.Title)
+	}
+}
+
+func TestWrite(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/posts/42", nil)
+	r.Header.Set(utils.RequestIDHeader, "req-1")
+	w := httptest.NewRecorder()
+
+	ServerError(w, r, context.DeadlineExceeded, "Failed to find post")
+
+	if w.Code != http.StatusGatewayTimeout {
+		t.Errorf("status = %d, want %d", w.Code, http.StatusGatewayTimeout)
+	}
+	if got := w.Header().Get("Content-Type"); got != ContentType {
+		t.Errorf("Content-Type = %q, want %q", got, ContentType)
+	}
+	if got := w.Header().Get("X-Content-Type-Options"); got != "nosniff" {
+		t.Errorf("X-Content-Type-Options = %q, want nosniff", got)
+	}
+
+	var body map[string]interface{}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]interface{}{
+		"type":       "urn:schooglink:problem:timeout",
+		"title":      "Gateway Timeout",
+		"status":     float64(http.StatusGatewayTimeout),
+		"detail":     "The request took too long to process, try again later",
+		"instance":   "/posts/42",
+		"code":       CodeTimeout,
+		"request_id": "req-1",
+	}
+	for key, value := range want {
+		if body[key] != value {
+			t.Errorf("%s = %v, want %v", key, body[key], value)
+		}
+	}
+	if _, ok := body["errors"]; ok {
+		t.Error("problem without field errors has an errors member")
+	}
+}
+
+func TestInvalid(t *testing.T) {
+	w := httptest.NewRecorder()
+	Invalid(w, httptest.NewRequest(http.MethodPost, "/register", nil), "Request failed validation",
+		FieldError{Field: "email", Code: "invalid_email", Message: "Must be an email address"})
+
+	var p Problem
+	if err := json.Unmarshal(w.Body.Bytes(), &p); err != nil {
+		t.Fatal(err)
+	}
+	if w.Code != http.StatusBadRequest || p.Code != CodeValidationFailed || p.Status != http.StatusBadRequest {
+		t.Errorf("response %d %+v, want 400 validation_failed", w.Code, p)
+	}
+	if len(p.Errors) != 1 || p.Errors[0].Field != "email" || p.Errors[0].Code != "invalid_email" {
+		t.Errorf("errors = %+v, want the invalid email", p.Errors)
+	}
+}
@@ -0,0 +1,139 @@
+/*
+Package ratelimit limits how often a client may call a group of routes, using token
+buckets: each key starts with Limit tokens, a request takes one, and tokens refill
+evenly so that a full bucket is restored over Period.
+*/
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Bucket is the state stored for a key.
+type Bucket struct {
+	Tokens  float64   `bson:"tokens"`
+	Updated time.Time `bson:"updated"`
+}
+
+// Store persists buckets. Implementations must make Take atomic so that concurrent
+// requests can't spend the same token; a distributed store lets every instance
+// share the limits.
+type Store interface {
+	// Take refills the bucket of key under policy and takes a token from it if one is left.
+	Take(ctx context.Context, key string, policy Policy, now time.Time) (Result, error)
+}
+
+// Policy is the size and refill period of a bucket. A zero Limit disables limiting.
+type Policy struct {
+	Limit  int
+	Period time.Duration
+}
+
+// Result is the outcome of taking a token.
+type Result struct {
+	Allowed   bool
+	Remaining int
+	// Reset is how long until the bucket is full again
+	Reset time.Duration
+	// RetryAfter is how long until a token is available, when none was
+	RetryAfter time.Duration
+}
+
+// Limiter applies a policy to one group of routes.
+type Limiter struct {
+	// Name prefixes the stored keys so that several limiters can share a Store
+	Name   string
+	Policy Policy
+	Store  Store
+}
+
+// Default policies of the route groups
+var (
+	// Auth covers logins, sign-in links and password resets, per client IP
+	Auth = &Limiter{Name: "auth", Policy: Policy{Limit: 20, Period: time.Minute}, Store: NewMemoryStore()}
+	// Registration covers sign-ups, per client IP
+	Registration = &Limiter{Name: "register", Policy: Policy{Limit: 5, Period: time.Hour}, Store: NewMemoryStore()}
+	// Posts covers creating, editing and deleting posts, per user
+	Posts = &Limiter{Name: "posts", Policy: Policy{Limit: 30, Period: time.Minute}, Store: NewMemoryStore()}
+)
+
+// Enabled reports whether the limiter limits anything.
+func (l *Limiter) Enabled() bool {
+	return l.Policy.Limit > 0 && l.Policy.Period > 0
+}
+
+// Take takes a token for key, always allowing the request when the limiter is disabled.
+func (l *Limiter) Take(ctx context.Context, key string) (Result, error) {
+	if !l.Enabled() {
+		return Result{Allowed: true}, nil
+	}
+	return l.Store.Take(ctx, l.Name+":"+key, l.Policy, time.Now())
+}
+
+// Take refills bucket up to now and takes a token from it if one is left, returning the new
+// bucket state. Stores use it to implement Store.Take; a missing bucket is the zero Bucket.
+func (p Policy) Take(bucket Bucket, now time.Time) (Bucket, Result) {
+	rate := float64(p.Limit) / p.Period.Seconds()
+
+	tokens := float64(p.Limit)
+	if !bucket.Updated.IsZero() {
+		tokens = math.Min(tokens, bucket.Tokens+now.Sub(bucket.Updated).Seconds()*rate)
+	}
+
+	allowed := tokens >= 1
+	if allowed {
+		tokens--
+	}
+	return Bucket{Tokens: tokens, Updated: now}, p.result(tokens, allowed)
+}
+
+// result describes a bucket left with tokens after a request that was allowed or not.
+func (p Policy) result(tokens float64, allowed bool) Result {
+	rate := float64(p.Limit) / p.Period.Seconds()
+	result := Result{
+		Allowed:   allowed,
+		Remaining: int(tokens),
+		Reset:     seconds((float64(p.Limit) - tokens) / rate),
+	}
+	if !allowed {
+		result.RetryAfter = seconds((1 - tokens) / rate)
+	}
+	return result
+}
+
+// String formats the policy like ParsePolicy reads it.
+func (p Policy) String() string {
+	if p.Limit <= 0 {
+		return "off"
+	}
+	return fmt.Sprintf("%d/%s", p.Limit, p.Period)
+}
+
+// ParsePolicy reads a policy written as "<limit>/<period>", such as "20/1m", or "off".
+func ParsePolicy(s string) (Policy, error) {
+	if s == "off" {
+		return Policy{}, nil
+	}
+	limit, period, ok := strings.Cut(s, "/")
+	if !ok {
+		return Policy{}, fmt.Errorf("rate limit %q is not <limit>/<period> or off", s)
+	}
+	n, err := strconv.Atoi(limit)
+	if err != nil || n <= 0 {
+		return Policy{}, fmt.Errorf("rate limit %q must allow a positive number of requests", s)
+	}
+	d, err := time.ParseDuration(period)
+	if err != nil || d <= 0 {
+		return Policy{}, fmt.Errorf("rate limit %q must have a positive period", s)
+	}
+	return Policy{Limit: n, Period: d}, nil
+}
+
+func seconds(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
@@ -0,0 +1,93 @@
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestPolicyTake(t *testing.T) {
+	policy := Policy{Limit: 3, Period: 3 * time.Second}
+	start := time.Unix(1700000000, 0)
+
+	var bucket Bucket
+	var result Result
+	for i, want := range []int{2, 1, 0} {
+		bucket, result = policy.Take(bucket, start)
+		if !result.Allowed || result.Remaining != want {
+			t.Fatalf("request %d: %+v, want allowed with %d remaining", i+1, result, want)
+		}
+	}
+
+	// The bucket is empty, and refills one token a second
+	bucket, result = policy.Take(bucket, start)
+	if result.Allowed || result.RetryAfter != time.Second || result.Reset != 3*time.Second {
+		t.Fatalf("request over the limit: %+v, want refused for a second, full in 3s", result)
+	}
+
+	bucket, result = policy.Take(bucket, start.Add(1500*time.Millisecond))
+	if !result.Allowed || result.Remaining != 0 {
+		t.Fatalf("after 1.5s: %+v, want allowed with 0 remaining", result)
+	}
+	if result.Reset != 2500*time.Millisecond {
+		t.Errorf("after 1.5s: Reset = %s, want 2.5s", result.Reset)
+	}
+
+	// A long pause refills the bucket, but never beyond the limit
+	_, result = policy.Take(bucket, start.Add(time.Hour))
+	if !result.Allowed || result.Remaining != 2 {
+		t.Errorf("after an hour: %+v, want allowed with 2 remaining", result)
+	}
+}
+
+func TestMemoryStoreKeepsKeysApart(t *testing.T) {
+	limiter := &Limiter{Name: "test", Policy: Policy{Limit: 1, Period: time.Minute}, Store: NewMemoryStore()}
+	ctx := context.Background()
+
+	if result, _ := limiter.Take(ctx, "198.51.100.1"); !result.Allowed {
+		t.Fatal("first request refused")
+	}
+	if result, _ := limiter.Take(ctx, "198.51.100.1"); result.Allowed {
+		t.Error("second request within the period allowed")
+	}
+	if result, _ := limiter.Take(ctx, "203.0.113.9"); !result.Allowed {
+		t.Error("another client's request refused")
+	}
+}
+
+func TestDisabledLimiterAllowsEverything(t *testing.T) {
+	limiter := &Limiter{Name: "test", Store: NewMemoryStore()}
+	for i := 0; i < 100; i++ {
+		if result, err := limiter.Take(context.Background(), "key"); !result.Allowed || err != nil {
+			t.Fatalf("request %d: %+v, %v", i+1, result, err)
+		}
+	}
+}
+
+func TestParsePolicy(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    Policy
+		wantErr bool
+	}{
+		{"20/1m", Policy{Limit: 20, Period: time.Minute}, false},
+		{"5/1h", Policy{Limit: 5, Period: time.Hour}, false},
+		{"off", Policy{}, false},
+		{"20", Policy{}, true},
+		{"0/1m", Policy{}, true},
+		{"-1/1m", Policy{}, true},
+		{"20/0s", Policy{}, true},
+		{"20/soon", Policy{}, true},
+	}
+	for _, tt := range tests {
+		got, err := ParsePolicy(tt.in)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("ParsePolicy(%q) = %v, %v, want %v, error %v", tt.in, got, err, tt.want, tt.wantErr)
+		}
+		if err == nil {
+			if again, _ := ParsePolicy(got.String()); again != got {
+				t.Errorf("ParsePolicy(%q.String()) = %v, want %v", tt.in, again, got)
+			}
+		}
+	}
+}
@@ -0,0 +1,105 @@
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/Aman913k/database"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// MemoryStore keeps buckets in process memory, so each instance enforces its own limits.
+type MemoryStore struct {
+	mu        sync.Mutex
+	buckets   map[string]memoryBucket
+	lastPrune time.Time
+}
+
+type memoryBucket struct {
+	Bucket
+	// full is when the bucket will have refilled completely
+	full time.Time
+}
+
+// NewMemoryStore creates an empty MemoryStore.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{buckets: make(map[string]memoryBucket)}
+}
+
+func (s *MemoryStore) Take(ctx context.Context, key string, policy Policy, now time.Time) (Result, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Dropping buckets that have refilled completely, they are the same as missing ones
+	if now.Sub(s.lastPrune) > time.Minute {
+		for k, b := range s.buckets {
+			if now.After(b.full) {
+				delete(s.buckets, k)
+			}
+		}
+		s.lastPrune = now
+	}
+
+	bucket, result := policy.Take(s.buckets[key].Bucket, now)
+	s.buckets[key] = memoryBucket{Bucket: bucket, full: now.Add(result.Reset)}
+	return result, nil
+}
+
+// MongoStore keeps buckets in the schoogratelimit collection, so that every instance
+// enforces the same limits. A TTL index removes buckets once they have refilled.
+type MongoStore struct{}
+
+type mongoBucket struct {
+	Bucket  `bson:",inline"`
+	Allowed bool `bson:"allowed"`
+}
+
+func (MongoStore) collection() *mongo.Collection {
+	return database.GetCollection("schoogratelimit")
+}
+
+// Take refills the bucket and takes a token in a single update, so that concurrent requests
+// can't spend the same token however many of them hit one key.
+func (s MongoStore) Take(ctx context.Context, key string, policy Policy, now time.Time) (Result, error) {
+	limit := float64(policy.Limit)
+	rate := limit / policy.Period.Seconds()
+
+	// The same arithmetic as Policy.Take, with a missing bucket starting full
+	refilled := bson.M{"$cond": bson.A{
+		bson.M{"$eq": bson.A{bson.M{"$type": "$updated"}, "missing"}},
+		limit,
+		bson.M{"$min": bson.A{limit, bson.M{"$add": bson.A{
+			"$tokens",
+			bson.M{"$multiply": bson.A{bson.M{"$divide": bson.A{bson.M{"$subtract": bson.A{now, "$updated"}}, 1000}}, rate}},
+		}}}},
+	}}
+	update := mongo.Pipeline{
+		{{Key: "$set", Value: bson.M{"tokens": refilled}}},
+		{{Key: "$set", Value: bson.M{
+			"allowed": bson.M{"$gte": bson.A{"$tokens", 1}},
+			"tokens":  bson.M{"$cond": bson.A{bson.M{"$gte": bson.A{"$tokens", 1}}, bson.M{"$subtract": bson.A{"$tokens", 1}}, "$tokens"}},
+			"updated": now,
+		}}},
+		{{Key: "$set", Value: bson.M{
+			// When the bucket will have refilled completely, in milliseconds from now
+			"expires_at": bson.M{"$add": bson.A{now, bson.M{"$multiply": bson.A{
+				bson.M{"$divide": bson.A{bson.M{"$subtract": bson.A{limit, "$tokens"}}, rate}}, 1000,
+			}}}},
+		}}},
+	}
+
+	var stored mongoBucket
+	opts := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)
+	err := s.collection().FindOneAndUpdate(ctx, bson.M{"_id": key}, update, opts).Decode(&stored)
+	if mongo.IsDuplicateKeyError(err) {
+		// Another request created the bucket at the same moment; it exists now
+		err = s.collection().FindOneAndUpdate(ctx, bson.M{"_id": key}, update, opts).Decode(&stored)
+	}
+	if err != nil {
+		return Result{}, err
+	}
+	return policy.result(stored.Tokens, stored.Allowed), nil
+}
@@ -4,27 +4,112 @@ import (
 	"net/http"
 
 	controller "github.com/Aman913k/controllers"
+	"github.com/Aman913k/metrics"
 	"github.com/Aman913k/middleware"
+	"github.com/Aman913k/models"
+	"github.com/Aman913k/problem"
+	"github.com/Aman913k/ratelimit"
+	"github.com/Aman913k/tracing"
+	"github.com/Aman913k/utils"
 	"github.com/gorilla/mux"
 	httpSwagger "github.com/swaggo/http-swagger"
+	"go.opentelemetry.io/contrib/instrumentation/github.com/gorilla/mux/otelmux"
 )
 
-func Router() *mux.Router {
+// Router returns the API's routes, wrapped in the middleware every request goes through:
+// request IDs, access logs and panic recovery. Routes also record metrics and traces.
+func Router() http.Handler {
 	router := mux.NewRouter()
 
-	router.HandleFunc("/register", controller.Register).Methods("POST")
-	router.HandleFunc("/login", controller.Login).Methods("POST")
-	router.Handle("/profile/view", middleware.JWTAuth(http.HandlerFunc(controller.ViewProfile))).Methods("GET")
-	router.Handle("/posts/create", middleware.JWTAuth(http.HandlerFunc(controller.CreatePost))).Methods("POST")
-	router.Handle("/post/delete", middleware.JWTAuth(http.HandlerFunc(controller.DeletePost))).Methods("DELETE")
+	// withScope authenticates with a login token, or with an API key granted scope
+	withScope := func(scope string, handler http.HandlerFunc) http.Handler {
+		return middleware.JWTAuth(middleware.RequireScope(scope, handler))
+	}
+	// sessionOnly authenticates with a login token only, for account security and key management
+	sessionOnly := func(handler http.HandlerFunc) http.Handler {
+		return middleware.JWTAuth(middleware.RequireSession(handler))
+	}
+	// limited counts requests to handler against the rate limit of its route group
+	limited := func(limiter *ratelimit.Limiter, key middleware.RateLimitKey, handler http.HandlerFunc) http.HandlerFunc {
+		return middleware.RateLimit(limiter, key, handler).ServeHTTP
+	}
+	// adminOnly authenticates with a login token of an administrator
+	adminOnly := func(handler http.HandlerFunc) http.Handler {
+		return middleware.JWTAuth(middleware.RequireSession(middleware.RequireRole(models.RoleAdmin, handler)))
+	}
+
+	router.HandleFunc("/register", limited(ratelimit.Registration, middleware.ByIP, controller.Register)).Methods("POST")
+	router.HandleFunc("/login", limited(ratelimit.Auth, middleware.ByIP, controller.Login)).Methods("POST")
+	router.HandleFunc("/login/unlock", limited(ratelimit.Auth, middleware.ByIP, controller.UnlockAccount)).Methods("GET")
+	router.HandleFunc("/login/mfa", limited(ratelimit.Auth, middleware.ByIP, controller.VerifyMFALogin)).Methods("POST")
+	router.Handle("/logout", sessionOnly(controller.Logout)).Methods("POST")
+	router.HandleFunc("/login/magic", limited(ratelimit.Auth, middleware.ByIP, controller.RequestMagicLink)).Methods("POST")
+	router.HandleFunc("/login/magic/verify", limited(ratelimit.Auth, middleware.ByIP, controller.ConfirmMagicLink)).Methods("GET")
+	router.HandleFunc("/login/magic/verify", limited(ratelimit.Auth, middleware.ByIP, controller.VerifyMagicLink)).Methods("POST")
+	router.HandleFunc("/login/oidc", limited(ratelimit.Auth, middleware.ByIP, controller.StartOIDCLogin)).Methods("GET")
+	router.HandleFunc("/login/oidc/callback", limited(ratelimit.Auth, middleware.ByIP, controller.OIDCCallback)).Methods("GET")
+	router.Handle("/profile/view", withScope(utils.ScopeProfileRead, controller.ViewProfile)).Methods("GET")
+	router.Handle("/posts/create", withScope(utils.ScopePostsWrite, limited(ratelimit.Posts, middleware.ByUser, controller.CreatePost))).Methods("POST")
+	router.Handle("/post/delete", withScope(utils.ScopePostsWrite, limited(ratelimit.Posts, middleware.ByUser, controller.DeletePost))).Methods("DELETE")
 	router.HandleFunc("/posts", controller.GetAllPosts).Methods("GET")
 	router.HandleFunc("/posts/{post_id}", controller.GetPostByID).Methods("GET")
-	router.Handle("/profile/{id}", middleware.JWTAuth(http.HandlerFunc(controller.UpdateProfile))).Methods("PUT")
+	router.Handle("/profile/{id:[0-9a-fA-F]{24}}", withScope(utils.ScopeProfileWrite, controller.UpdateProfile)).Methods("PUT")
+	router.Handle("/profile/password", sessionOnly(controller.ChangePassword)).Methods("PUT")
+	router.Handle("/profile/mfa/enroll", sessionOnly(controller.EnrollMFA)).Methods("POST")
+	router.Handle("/profile/mfa/confirm", sessionOnly(controller.ConfirmMFA)).Methods("POST")
+	router.Handle("/profile/mfa/disable", sessionOnly(controller.DisableMFA)).Methods("POST")
+	router.Handle("/profile/mfa/recovery-codes", sessionOnly(controller.RegenerateRecoveryCodes)).Methods("POST")
+	router.Handle("/profile/oidc/link", sessionOnly(controller.StartOIDCLink)).Methods("POST")
+	router.Handle("/profile/api-keys", sessionOnly(controller.CreateAPIKey)).Methods("POST")
+	router.Handle("/profile/api-keys", sessionOnly(controller.ListAPIKeys)).Methods("GET")
+	router.Handle("/profile/api-keys/{key_id}", sessionOnly(controller.RevokeAPIKey)).Methods("DELETE")
+	router.Handle("/profile/sessions", sessionOnly(controller.ListSessions)).Methods("GET")
+	router.Handle("/profile/sessions/{session_id}", sessionOnly(controller.RevokeSession)).Methods("DELETE")
+	router.Handle("/profile/export", sessionOnly(controller.ExportAccount)).Methods("GET")
+	router.Handle("/profile", sessionOnly(controller.DeleteAccount)).Methods("DELETE")
+	router.Handle("/profile/delete/cancel", sessionOnly(controller.CancelAccountDeletion)).Methods("POST")
+	router.Handle("/posts/{post_id}", withScope(utils.ScopePostsWrite, limited(ratelimit.Posts, middleware.ByUser, controller.UpdatePost))).Methods("PUT")
+	router.HandleFunc("/password/reset", limited(ratelimit.Auth, middleware.ByIP, controller.ResetPassword)).Methods("POST")
 
-	router.HandleFunc("/posts/{post_id}", func(w http.ResponseWriter, r *http.Request) {
-		middleware.JWTAuth(http.HandlerFunc(controller.UpdatePost)).ServeHTTP(w, r)
-	}).Methods("PUT")
+	router.Handle("/admin/users", adminOnly(controller.ListUsers)).Methods("GET")
+	router.Handle("/admin/users/{id}", adminOnly(controller.DeleteUser)).Methods("DELETE")
+	router.Handle("/admin/users/{id}/activity", adminOnly(controller.GetUserActivity)).Methods("GET")
+	router.Handle("/admin/users/{id}/suspend", adminOnly(controller.SuspendUser)).Methods("POST")
+	router.Handle("/admin/users/{id}/unsuspend", adminOnly(controller.UnsuspendUser)).Methods("POST")
+	router.Handle("/admin/users/{id}/force-password-reset", adminOnly(controller.ForcePasswordReset)).Methods("POST")
+	router.Handle("/admin/users/{id}/role", adminOnly(controller.ChangeUserRole)).Methods("PUT")
+	router.Handle("/admin/audit", adminOnly(controller.ListAuditEvents)).Methods("GET")
 
 	router.PathPrefix("/swagger/").Handler(httpSwagger.WrapHandler)
+	router.HandleFunc("/healthz", controller.Healthz).Methods("GET")
+	router.HandleFunc("/readyz", controller.Readyz).Methods("GET")
+	router.HandleFunc("/version", controller.Version).Methods("GET")
+
+	// Answering unknown routes in the same format as every other error
+	router.NotFoundHandler = middleware.Instrument(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		problem.Error(w, r, http.StatusNotFound, problem.CodeNotFound, "No such endpoint")
+	}))
+	router.MethodNotAllowedHandler = middleware.Instrument(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		problem.Error(w, r, http.StatusMethodNotAllowed, problem.CodeMethodNotAllowed, "Method not allowed on this endpoint")
+	}))
+	router.Use(middleware.Instrument)
+	// Starting a span per request named after its route, continuing the caller's trace when it sent one.
+	// Probes aren't traced.
+	router.Use(otelmux.Middleware(tracing.ServiceName, otelmux.WithFilter(func(r *http.Request) bool {
+		switch r.URL.Path {
+		case "/healthz", "/readyz":
+			return false
+		}
+		return true
+	})))
+
+	return middleware.Chain(router, middleware.RequestID, middleware.AccessLog, middleware.Recover)
+}
+
+// InternalRouter returns the routes served on the internal listener, for monitoring that
+// shouldn't be reachable from the public internet.
+func InternalRouter() http.Handler {
+	router := http.NewServeMux()
+	router.Handle("GET /metrics", metrics.Handler())
 	return router
 }
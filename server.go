@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"log/slog"
+	"net/http"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	controller "github.com/Aman913k/controllers"
+	"github.com/Aman913k/database"
+	"github.com/Aman913k/health"
+	"github.com/Aman913k/tracing"
+)
+
+// shutdownConfig controls how the server stops.
+type shutdownConfig struct {
+	// Delay keeps serving after readiness starts failing, so that load balancers stop routing first
+	Delay time.Duration
+	// Timeout bounds how long in-flight requests and jobs are waited for
+	Timeout time.Duration
+}
+
+// serve runs the servers until SIGINT or SIGTERM, then shuts down gracefully: readiness fails,
+// the listeners close, in-flight requests drain, background jobs and emails being sent finish,
+// and connections to MongoDB and the trace exporter are closed. A second signal exits immediately.
+func serve(servers []*http.Server, stopJobs context.CancelFunc, jobsDone *sync.WaitGroup, config shutdownConfig) {
+	signals, stopSignals := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stopSignals()
+
+	serveErr := make(chan error, len(servers))
+	for _, server := range servers {
+		go func() {
+			serveErr <- server.ListenAndServe()
+		}()
+		slog.Info("Server is listening", "addr", server.Addr)
+	}
+
+	select {
+	case err := <-serveErr:
+		log.Fatal("Error serving HTTP: ", err)
+	case <-signals.Done():
+	}
+	// Restoring the default signal handling, so that a second signal kills the process
+	stopSignals()
+
+	slog.Info("Shutting down", "delay", config.Delay.String(), "timeout", config.Timeout.String())
+	health.SetDraining()
+	time.Sleep(config.Delay)
+
+	ctx, cancel := context.WithTimeout(context.Background(), config.Timeout)
+	defer cancel()
+
+	for _, server := range servers {
+		if err := server.Shutdown(ctx); err != nil {
+			slog.Error("Failed to drain in-flight requests", "addr", server.Addr, "error", err)
+			server.Close()
+		}
+	}
+	for range servers {
+		if err := <-serveErr; !errors.Is(err, http.ErrServerClosed) {
+			slog.Error("Error serving HTTP", "error", err)
+		}
+	}
+
+	stopJobs()
+	if !waitGroup(ctx, jobsDone) {
+		slog.Error("Background jobs did not stop in time")
+	}
+	if !controller.WaitForBackground(ctx) {
+		slog.Error("Work left running by handlers did not finish in time")
+	}
+
+	if err := tracing.Shutdown(ctx); err != nil {
+		slog.Error("Failed to flush traces", "error", err)
+	}
+	if err := database.Disconnect(ctx); err != nil {
+		slog.Error("Failed to disconnect from MongoDB", "error", err)
+	}
+	slog.Info("Server stopped")
+}
+
+// waitGroup waits for wg until ctx is done, reporting whether wg finished.
+func waitGroup(ctx context.Context, wg *sync.WaitGroup) bool {
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
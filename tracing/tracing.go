@@ -0,0 +1,87 @@
+/*
+Package tracing sets up OpenTelemetry tracing. Spans are exported over OTLP, printed to
+stdout, or not recorded at all, and W3C trace context is propagated in both directions.
+*/
+package tracing
+
+import (
+	"context"
+	"fmt"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// ServiceName identifies the API in traces unless OTEL_SERVICE_NAME overrides it.
+const ServiceName = "schooglink"
+
+// Exporters that Setup accepts
+const (
+	// ExporterOTLP sends spans over OTLP/HTTP, configured by the standard OTEL_EXPORTER_OTLP_* variables
+	ExporterOTLP = "otlp"
+	// ExporterStdout prints spans as JSON, for local debugging
+	ExporterStdout = "stdout"
+	// ExporterNone records nothing but still passes incoming trace context on
+	ExporterNone = "none"
+)
+
+// provider is the installed tracer provider, nil when spans aren't recorded
+var provider *sdktrace.TracerProvider
+
+// Setup installs the W3C trace context propagator and, unless exporter is ExporterNone,
+// a tracer provider that batches spans to the exporter.
+func Setup(ctx context.Context, exporter string) error {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+
+	var spanExporter sdktrace.SpanExporter
+	var err error
+	switch exporter {
+	case "", ExporterNone:
+		return nil
+	case ExporterOTLP:
+		spanExporter, err = otlptracehttp.New(ctx)
+	case ExporterStdout:
+		spanExporter, err = stdouttrace.New()
+	default:
+		return fmt.Errorf("unknown trace exporter %q", exporter)
+	}
+	if err != nil {
+		return fmt.Errorf("creating %s trace exporter: %w", exporter, err)
+	}
+
+	// Letting OTEL_SERVICE_NAME and OTEL_RESOURCE_ATTRIBUTES override the defaults
+	res, err := resource.New(ctx,
+		resource.WithAttributes(semconv.ServiceName(ServiceName)),
+		resource.WithTelemetrySDK(),
+		resource.WithFromEnv(),
+	)
+	if err != nil {
+		return fmt.Errorf("describing trace resource: %w", err)
+	}
+
+	provider = sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(spanExporter),
+		sdktrace.WithResource(res),
+	)
+	otel.SetTracerProvider(provider)
+	return nil
+}
+
+// Shutdown flushes spans that haven't been exported yet and stops the tracer provider.
+func Shutdown(ctx context.Context) error {
+	if provider == nil {
+		return nil
+	}
+	return provider.Shutdown(ctx)
+}
+
+// Tracer returns the tracer of spans the API starts itself.
+func Tracer() trace.Tracer {
+	return otel.Tracer("github.com/Aman913k")
+}
@@ -0,0 +1,46 @@
+package utils
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"strings"
+)
+
+// Scopes an API key can be granted. Tokens from a login carry every scope. Reading posts
+// is public, so it needs no scope.
+const (
+	ScopePostsWrite   = "posts:write"
+	ScopeProfileRead  = "profile:read"
+	ScopeProfileWrite = "profile:write"
+)
+
+// APIKeyScopes lists every scope an API key can be granted. The validate tag of
+// models.CreateAPIKeyRequest must list the same scopes.
+var APIKeyScopes = []string{ScopePostsWrite, ScopeProfileRead, ScopeProfileWrite}
+
+// APIKeyPrefix marks bearer credentials that are API keys rather than JWTs.
+const APIKeyPrefix = "sk_"
+
+// GenerateAPIKey returns a new random API key and a short prefix of it that identifies the key in listings.
+func GenerateAPIKey() (key, displayPrefix string, err error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", "", err
+	}
+	key = APIKeyPrefix + base64.RawURLEncoding.EncodeToString(secret)
+	return key, key[:len(APIKeyPrefix)+8], nil
+}
+
+// HashAPIKey hashes an API key for storage. Keys are long and random, so SHA-256 is
+// enough and lets a presented key be looked up directly by its hash.
+func HashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// IsAPIKey reports whether a bearer credential is an API key.
+func IsAPIKey(credential string) bool {
+	return strings.HasPrefix(credential, APIKeyPrefix)
+}
@@ -0,0 +1,126 @@
+package utils
+
+import (
+	"bufio"
+	"context"
+	_ "embed"
+	"errors"
+	"net"
+	"net/mail"
+	"strings"
+)
+
+//go:embed disposable_domains.txt
+var disposableDomainList string
+
+// DisposableDomains is the bundled list of throwaway email providers.
+var DisposableDomains = parseDomainList(disposableDomainList)
+
+// MXResolver looks up the mail exchangers of a domain. *net.Resolver satisfies it;
+// StaticMXResolver can be used instead when running offline.
+type MXResolver interface {
+	LookupMX(ctx context.Context, domain string) ([]*net.MX, error)
+}
+
+// StaticMXResolver resolves MX records from a fixed map, treating missing domains as having none.
+type StaticMXResolver map[string][]*net.MX
+
+func (s StaticMXResolver) LookupMX(ctx context.Context, domain string) ([]*net.MX, error) {
+	return s[domain], nil
+}
+
+// EmailPolicyError explains why an email address was rejected.
+type EmailPolicyError struct {
+	Reason string
+}
+
+func (e *EmailPolicyError) Error() string {
+	return e.Reason
+}
+
+// EmailPolicy decides which email addresses may be used to register.
+// Domain lists match the domain itself and all of its subdomains.
+type EmailPolicy struct {
+	// AllowedDomains restricts registration to these domains when not empty
+	AllowedDomains []string
+	DeniedDomains  []string
+
+	BlockDisposable bool
+
+	// CheckMX rejects domains without mail exchangers, looked up through Resolver
+	CheckMX  bool
+	Resolver MXResolver
+}
+
+// RegistrationEmailPolicy is the policy applied by Register.
+var RegistrationEmailPolicy = &EmailPolicy{
+	BlockDisposable: true,
+	Resolver:        net.DefaultResolver,
+}
+
+// Validate checks the email address against the policy, returning an *EmailPolicyError if it is rejected.
+func (p *EmailPolicy) Validate(ctx context.Context, email string) error {
+	// Accepting only a bare RFC 5322 address, without display name or comments
+	if len(email) > 254 {
+		return &EmailPolicyError{Reason: "Email address is too long"}
+	}
+	addr, err := mail.ParseAddress(email)
+	if err != nil || addr.Name != "" || addr.Address != email {
+		return &EmailPolicyError{Reason: "Invalid email address"}
+	}
+
+	domain := strings.ToLower(email[strings.LastIndex(email, "@")+1:])
+	if !strings.Contains(domain, ".") {
+		return &EmailPolicyError{Reason: "Invalid email address"}
+	}
+
+	if len(p.AllowedDomains) > 0 && !matchesDomain(domain, p.AllowedDomains) {
+		return &EmailPolicyError{Reason: "Email domain is not allowed"}
+	}
+	if matchesDomain(domain, p.DeniedDomains) {
+		return &EmailPolicyError{Reason: "Email domain is not allowed"}
+	}
+	if p.BlockDisposable && matchesDomain(domain, DisposableDomains) {
+		return &EmailPolicyError{Reason: "Disposable email addresses are not allowed"}
+	}
+
+	if p.CheckMX {
+		records, err := p.Resolver.LookupMX(ctx, domain)
+		var dnsErr *net.DNSError
+		if err != nil && !(errors.As(err, &dnsErr) && dnsErr.IsNotFound) {
+			return err
+		}
+		if len(records) == 0 {
+			return &EmailPolicyError{Reason: "Email domain does not accept mail"}
+		}
+	}
+
+	return nil
+}
+
+// ParseDomains splits a comma-separated list of domains, as used in configuration.
+func ParseDomains(list string) []string {
+	return parseDomainList(strings.ReplaceAll(list, ",", "\n"))
+}
+
+func parseDomainList(list string) []string {
+	var domains []string
+	scanner := bufio.NewScanner(strings.NewReader(list))
+	for scanner.Scan() {
+		domain := strings.ToLower(strings.TrimSpace(scanner.Text()))
+		if domain == "" || strings.HasPrefix(domain, "#") {
+			continue
+		}
+		domains = append(domains, domain)
+	}
+	return domains
+}
+
+func matchesDomain(domain string, domains []string) bool {
+	for _, d := range domains {
+		if domain == d || strings.HasSuffix(domain, "."+d) {
+			return true
+		}
+	}
+	return false
+}
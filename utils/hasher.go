@@ -0,0 +1,218 @@
+package utils
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// ErrUnknownHashFormat is returned when a stored hash isn't produced by any supported hasher.
+var ErrUnknownHashFormat = errors.New("unknown password hash format")
+
+// PasswordHasher is one password hashing algorithm with its parameters. Hashes are
+// self-describing, so they can still be verified after the parameters change.
+type PasswordHasher interface {
+	Hash(password []byte) (string, error)
+	// Verify compares password with an encoded hash of this algorithm. It reports whether the
+	// password matches and whether the hash was made with parameters other than the hasher's.
+	Verify(encoded string, password []byte) (match, outdated bool, err error)
+	// Recognises reports whether encoded was produced by this algorithm
+	Recognises(encoded string) bool
+}
+
+// Argon2idHasher hashes passwords with argon2id, encoded in the PHC string format:
+// $argon2id$v=19$m=<KiB>,t=<iterations>,p=<parallelism>$<salt>$<key>
+type Argon2idHasher struct {
+	// Memory is in KiB
+	Memory      uint32
+	Iterations  uint32
+	Parallelism uint8
+	SaltLength  uint32
+	KeyLength   uint32
+}
+
+// DefaultArgon2id follows the OWASP recommendation of 19 MiB, 2 iterations and 1 thread.
+var DefaultArgon2id = &Argon2idHasher{
+	Memory:      19 * 1024,
+	Iterations:  2,
+	Parallelism: 1,
+	SaltLength:  16,
+	KeyLength:   32,
+}
+
+var phcEncoding = base64.RawStdEncoding
+
+func (h *Argon2idHasher) Hash(password []byte) (string, error) {
+	salt := make([]byte, h.SaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+
+	key := argon2.IDKey(password, salt, h.Iterations, h.Memory, h.Parallelism, h.KeyLength)
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s", argon2.Version, h.Memory, h.Iterations, h.Parallelism,
+		phcEncoding.EncodeToString(salt), phcEncoding.EncodeToString(key)), nil
+}
+
+func (h *Argon2idHasher) Verify(encoded string, password []byte) (bool, bool, error) {
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 6 || parts[1] != "argon2id" {
+		return false, false, ErrUnknownHashFormat
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return false, false, fmt.Errorf("unsupported argon2 version %q", parts[2])
+	}
+	var stored Argon2idHasher
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &stored.Memory, &stored.Iterations, &stored.Parallelism); err != nil {
+		return false, false, fmt.Errorf("invalid argon2 parameters %q", parts[3])
+	}
+	salt, err := phcEncoding.DecodeString(parts[4])
+	if err != nil {
+		return false, false, err
+	}
+	key, err := phcEncoding.DecodeString(parts[5])
+	if err != nil {
+		return false, false, err
+	}
+
+	computed := argon2.IDKey(password, salt, stored.Iterations, stored.Memory, stored.Parallelism, uint32(len(key)))
+	match := subtle.ConstantTimeCompare(key, computed) == 1
+	outdated := stored.Memory != h.Memory || stored.Iterations != h.Iterations || stored.Parallelism != h.Parallelism ||
+		uint32(len(salt)) != h.SaltLength || uint32(len(key)) != h.KeyLength
+	return match, outdated, nil
+}
+
+func (h *Argon2idHasher) Recognises(encoded string) bool {
+	return strings.HasPrefix(encoded, "$argon2id$")
+}
+
+// BcryptHasher hashes passwords with bcrypt at the given cost. Only the first 72 bytes
+// of a password count, which the password policy's maximum length accounts for.
+type BcryptHasher struct {
+	Cost int
+}
+
+func (h *BcryptHasher) Hash(password []byte) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword(password, h.Cost)
+	return string(hash), err
+}
+
+func (h *BcryptHasher) Verify(encoded string, password []byte) (bool, bool, error) {
+	cost, err := bcrypt.Cost([]byte(encoded))
+	if err != nil {
+		return false, false, err
+	}
+	err = bcrypt.CompareHashAndPassword([]byte(encoded), password)
+	if err == bcrypt.ErrMismatchedHashAndPassword {
+		return false, false, nil
+	} else if err != nil {
+		return false, false, err
+	}
+	return true, cost != h.Cost, nil
+}
+
+func (h *BcryptHasher) Recognises(encoded string) bool {
+	return strings.HasPrefix(encoded, "$2a$") || strings.HasPrefix(encoded, "$2b$") || strings.HasPrefix(encoded, "$2y$")
+}
+
+// PasswordHashing hashes new passwords with the configured hasher and verifies hashes made by
+// any supported one, so that hashes can be upgraded as users log in.
+//
+// With a pepper, the password is first keyed with HMAC-SHA256 using a secret that is kept
+// out of the database, and the hash is prefixed with the pepper's ID: $pepper$<id>$argon2id$...
+// Old peppers stay configured by ID until every hash using them has been upgraded.
+type PasswordHashing struct {
+	Hasher PasswordHasher
+	// Peppers are the server-side secrets by ID
+	Peppers map[string][]byte
+	// PepperID names the pepper for new hashes; empty for none
+	PepperID string
+}
+
+// Passwords is the password hashing configuration used by the API.
+var Passwords = &PasswordHashing{Hasher: DefaultArgon2id}
+
+// knownHashers verify hashes from algorithms other than the configured one
+var knownHashers = []PasswordHasher{&Argon2idHasher{}, &BcryptHasher{}}
+
+const pepperPrefix = "$pepper$"
+
+// Hash hashes a password with the current hasher and pepper.
+func (p *PasswordHashing) Hash(password string) (string, error) {
+	input, err := p.pepper(p.PepperID, password)
+	if err != nil {
+		return "", err
+	}
+	hash, err := p.Hasher.Hash(input)
+	if err != nil {
+		return "", err
+	}
+	if p.PepperID != "" {
+		hash = pepperPrefix + p.PepperID + hash
+	}
+	return hash, nil
+}
+
+// Verify reports whether password matches the encoded hash, and whether the hash should be
+// replaced because it uses another algorithm, other parameters or another pepper.
+func (p *PasswordHashing) Verify(encoded, password string) (match, needsRehash bool, err error) {
+	pepperID := ""
+	if strings.HasPrefix(encoded, pepperPrefix) {
+		rest := strings.TrimPrefix(encoded, pepperPrefix)
+		end := strings.Index(rest, "$")
+		if end <= 0 {
+			return false, false, ErrUnknownHashFormat
+		}
+		pepperID, encoded = rest[:end], rest[end:]
+	}
+
+	input, err := p.pepper(pepperID, password)
+	if err != nil {
+		return false, false, err
+	}
+
+	hasher, current := p.Hasher, true
+	if !hasher.Recognises(encoded) {
+		hasher, current = nil, false
+		for _, known := range knownHashers {
+			if known.Recognises(encoded) {
+				hasher = known
+				break
+			}
+		}
+		if hasher == nil {
+			return false, false, ErrUnknownHashFormat
+		}
+	}
+
+	match, outdated, err := hasher.Verify(encoded, input)
+	if err != nil || !match {
+		return false, false, err
+	}
+	return true, outdated || !current || pepperID != p.PepperID, nil
+}
+
+// pepper keys the password with the pepper of the given ID, or returns it unchanged for none.
+func (p *PasswordHashing) pepper(id, password string) ([]byte, error) {
+	if id == "" {
+		return []byte(password), nil
+	}
+	secret, ok := p.Peppers[id]
+	if !ok {
+		return nil, fmt.Errorf("unknown password pepper %q", id)
+	}
+
+	// Encoding the MAC keeps it free of NUL bytes and within bcrypt's 72 byte limit
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(password))
+	return []byte(base64.StdEncoding.EncodeToString(mac.Sum(nil))), nil
+}
@@ -0,0 +1,124 @@
+package utils
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Cheap parameters keep the tests fast; the algorithms don't change with them
+var (
+	testArgon2id = &Argon2idHasher{Memory: 64, Iterations: 1, Parallelism: 1, SaltLength: 16, KeyLength: 32}
+	testBcrypt   = &BcryptHasher{Cost: bcrypt.MinCost}
+)
+
+func TestHashersRoundTrip(t *testing.T) {
+	for name, hasher := range map[string]PasswordHasher{"argon2id": testArgon2id, "bcrypt": testBcrypt} {
+		t.Run(name, func(t *testing.T) {
+			hash, err := hasher.Hash([]byte("correct horse"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !hasher.Recognises(hash) {
+				t.Errorf("hasher doesn't recognise its own hash %q", hash)
+			}
+
+			if match, outdated, err := hasher.Verify(hash, []byte("correct horse")); !match || outdated || err != nil {
+				t.Errorf("Verify(right password) = %v, %v, %v", match, outdated, err)
+			}
+			if match, _, err := hasher.Verify(hash, []byte("wrong horse")); match || err != nil {
+				t.Errorf("Verify(wrong password) = %v, %v", match, err)
+			}
+
+			// Salting makes every hash of the same password different
+			if again, _ := hasher.Hash([]byte("correct horse")); again == hash {
+				t.Error("hashing twice gave the same hash")
+			}
+		})
+	}
+}
+
+func TestArgon2idFormat(t *testing.T) {
+	hash, err := testArgon2id.Hash([]byte("password"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(hash, "$argon2id$v=19$m=64,t=1,p=1$") {
+		t.Errorf("hash %q is not in the PHC format", hash)
+	}
+
+	stronger := *testArgon2id
+	stronger.Iterations = 2
+	if match, outdated, _ := stronger.Verify(hash, []byte("password")); !match || !outdated {
+		t.Errorf("Verify() with changed parameters = %v, %v, want a match that is outdated", match, outdated)
+	}
+}
+
+func TestPasswordHashingUpgrades(t *testing.T) {
+	bcryptHash, err := testBcrypt.Hash([]byte("password"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	hashing := &PasswordHashing{Hasher: testArgon2id}
+
+	// Hashes from another algorithm still verify, and are flagged for rehashing
+	match, needsRehash, err := hashing.Verify(bcryptHash, "password")
+	if !match || !needsRehash || err != nil {
+		t.Errorf("Verify(bcrypt hash) = %v, %v, %v, want a match that needs rehashing", match, needsRehash, err)
+	}
+
+	current, err := hashing.Hash("password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if match, needsRehash, _ := hashing.Verify(current, "password"); !match || needsRehash {
+		t.Errorf("Verify(current hash) = %v, %v, want a match that needs no rehashing", match, needsRehash)
+	}
+
+	if _, _, err := hashing.Verify("plaintext", "plaintext"); !errors.Is(err, ErrUnknownHashFormat) {
+		t.Errorf("Verify(unknown format) error = %v, want ErrUnknownHashFormat", err)
+	}
+}
+
+func TestPasswordHashingPeppers(t *testing.T) {
+	hashing := &PasswordHashing{
+		Hasher:   testArgon2id,
+		Peppers:  map[string][]byte{"p1": []byte("first secret"), "p2": []byte("second secret")},
+		PepperID: "p1",
+	}
+	unpeppered, err := (&PasswordHashing{Hasher: testArgon2id}).Hash("password")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hash, err := hashing.Hash("password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(hash, "$pepper$p1$argon2id$") {
+		t.Fatalf("hash %q doesn't name its pepper", hash)
+	}
+	if match, needsRehash, _ := hashing.Verify(hash, "password"); !match || needsRehash {
+		t.Errorf("Verify() = %v, %v, want a match that needs no rehashing", match, needsRehash)
+	}
+
+	// The pepper is part of the hash, so the hash alone doesn't verify without it
+	if match, _, _ := (&PasswordHashing{Hasher: testArgon2id}).Verify(strings.TrimPrefix(hash, "$pepper$p1"), "password"); match {
+		t.Error("peppered hash verified without its pepper")
+	}
+
+	// Rotating to a new pepper keeps old hashes working until they are upgraded
+	hashing.PepperID = "p2"
+	for name, encoded := range map[string]string{"old pepper": hash, "no pepper": unpeppered} {
+		if match, needsRehash, err := hashing.Verify(encoded, "password"); !match || !needsRehash || err != nil {
+			t.Errorf("Verify(%s) = %v, %v, %v, want a match that needs rehashing", name, match, needsRehash, err)
+		}
+	}
+
+	delete(hashing.Peppers, "p1")
+	if _, _, err := hashing.Verify(hash, "password"); err == nil {
+		t.Error("hash with a removed pepper verified")
+	}
+}
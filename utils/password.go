@@ -0,0 +1,170 @@
+package utils
+
+import (
+	_ "embed"
+	"fmt"
+	"math"
+	"strings"
+	"unicode"
+)
+
+//go:embed common_passwords.txt
+var commonPasswordList string
+
+var commonPasswords = func() map[string]struct{} {
+	passwords := make(map[string]struct{})
+	for _, line := range strings.Split(commonPasswordList, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		passwords[strings.ToLower(line)] = struct{}{}
+	}
+	return passwords
+}()
+
+// PasswordViolation is a single reason a password was rejected, returned to the client as-is.
+type PasswordViolation struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// PasswordPolicy describes what makes a password acceptable.
+// Zero values disable the corresponding check.
+type PasswordPolicy struct {
+	MinLength int
+	// MaxLength guards against hashing very long inputs
+	MaxLength int
+	// MinEntropyBits is compared against EstimateEntropy
+	MinEntropyBits float64
+	// MinCharacterClasses counts lowercase, uppercase, digits and symbols
+	MinCharacterClasses int
+	RejectPersonalInfo  bool
+	RejectCommon        bool
+}
+
+// DefaultPasswordPolicy is applied wherever a user chooses a password.
+var DefaultPasswordPolicy = &PasswordPolicy{
+	MinLength:           8,
+	MaxLength:           72,
+	MinEntropyBits:      40,
+	MinCharacterClasses: 2,
+	RejectPersonalInfo:  true,
+	RejectCommon:        true,
+}
+
+// Check returns every way the password breaks the policy, or nil if it is acceptable.
+// personal holds the user's own details, such as name and email, which the password may not contain.
+func (p *PasswordPolicy) Check(password string, personal ...string) []PasswordViolation {
+	var violations []PasswordViolation
+	length := len([]rune(password))
+
+	if length < p.MinLength {
+		violations = append(violations, PasswordViolation{
+			Code:    "too_short",
+			Message: fmt.Sprintf("Password must be at least %d characters long", p.MinLength),
+		})
+	}
+	if p.MaxLength > 0 && len(password) > p.MaxLength {
+		violations = append(violations, PasswordViolation{
+			Code:    "too_long",
+			Message: fmt.Sprintf("Password must be at most %d bytes long", p.MaxLength),
+		})
+	}
+	if classes := characterClasses(password); classes < p.MinCharacterClasses {
+		violations = append(violations, PasswordViolation{
+			Code:    "too_few_character_classes",
+			Message: fmt.Sprintf("Password must mix at least %d of lowercase letters, uppercase letters, digits and symbols", p.MinCharacterClasses),
+		})
+	}
+	if p.MinEntropyBits > 0 && EstimateEntropy(password) < p.MinEntropyBits {
+		violations = append(violations, PasswordViolation{
+			Code:    "too_predictable",
+			Message: "Password is too predictable, avoid repeated characters and sequences",
+		})
+	}
+	if p.RejectCommon {
+		if _, ok := commonPasswords[strings.ToLower(password)]; ok {
+			violations = append(violations, PasswordViolation{
+				Code:    "common_password",
+				Message: "Password is too common",
+			})
+		}
+	}
+	if p.RejectPersonalInfo && containsPersonalInfo(password, personal) {
+		violations = append(violations, PasswordViolation{
+			Code:    "contains_personal_info",
+			Message: "Password must not contain your name or email address",
+		})
+	}
+
+	return violations
+}
+
+// EstimateEntropy estimates the strength of a password in bits from the size of the
+// character pool it draws from. Characters that repeat or continue a sequence from the
+// previous character (such as "aaa" or "1234") add almost nothing.
+func EstimateEntropy(password string) float64 {
+	pool := 0
+	if strings.IndexFunc(password, unicode.IsLower) >= 0 {
+		pool += 26
+	}
+	if strings.IndexFunc(password, unicode.IsUpper) >= 0 {
+		pool += 26
+	}
+	if strings.IndexFunc(password, unicode.IsDigit) >= 0 {
+		pool += 10
+	}
+	if strings.IndexFunc(password, isSymbol) >= 0 {
+		pool += 33
+	}
+	if pool == 0 {
+		return 0
+	}
+
+	perChar := math.Log2(float64(pool))
+	bits := 0.0
+	var prev rune
+	for i, c := range []rune(password) {
+		if i > 0 && (c == prev || c == prev+1 || c == prev-1) {
+			bits += 1
+		} else {
+			bits += perChar
+		}
+		prev = c
+	}
+	return bits
+}
+
+func characterClasses(password string) int {
+	classes := 0
+	for _, class := range []func(rune) bool{unicode.IsLower, unicode.IsUpper, unicode.IsDigit, isSymbol} {
+		if strings.IndexFunc(password, class) >= 0 {
+			classes++
+		}
+	}
+	return classes
+}
+
+func isSymbol(c rune) bool {
+	return !unicode.IsLetter(c) && !unicode.IsDigit(c)
+}
+
+// containsPersonalInfo reports whether the password contains the user's name, any part
+// of it, or the local part of their email address.
+func containsPersonalInfo(password string, personal []string) bool {
+	lower := strings.ToLower(password)
+	for _, info := range personal {
+		info = strings.ToLower(info)
+		if at := strings.LastIndex(info, "@"); at >= 0 {
+			info = info[:at]
+		}
+
+		for _, part := range strings.FieldsFunc(info, func(c rune) bool { return !unicode.IsLetter(c) && !unicode.IsDigit(c) }) {
+			if len(part) >= 3 && strings.Contains(lower, part) {
+				return true
+			}
+		}
+	}
+	return false
+}
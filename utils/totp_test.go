@@ -0,0 +1,115 @@
+package utils
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// rfc6238Secret is the SHA-1 key of the RFC 6238 test vectors
+var rfc6238Secret = totpEncoding.EncodeToString([]byte("12345678901234567890"))
+
+func TestValidateTOTPVectors(t *testing.T) {
+	// The RFC's 8-digit values truncated to the 6 digits authenticator apps show
+	tests := []struct {
+		unix int64
+		code string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+	}
+	for _, tt := range tests {
+		step, ok := ValidateTOTP(rfc6238Secret, tt.code, time.Unix(tt.unix, 0), 0)
+		if !ok || step != tt.unix/totpPeriod {
+			t.Errorf("ValidateTOTP(%s at %d) = %d, %v, want step %d", tt.code, tt.unix, step, ok, tt.unix/totpPeriod)
+		}
+	}
+}
+
+func TestValidateTOTPWindow(t *testing.T) {
+	now := time.Unix(1234567890, 0)
+	current := now.Unix() / totpPeriod
+	key, _ := totpEncoding.DecodeString(rfc6238Secret)
+
+	tests := []struct {
+		name     string
+		code     string
+		lastStep int64
+		want     bool
+	}{
+		{"current step", totpCode(key, current), 0, true},
+		{"previous step", totpCode(key, current-1), 0, true},
+		{"next step", totpCode(key, current+1), 0, true},
+		{"two steps old", totpCode(key, current-2), 0, false},
+		{"two steps ahead", totpCode(key, current+2), 0, false},
+		{"replayed code", totpCode(key, current), current, false},
+		{"code older than the last one used", totpCode(key, current-1), current, false},
+		{"too short", totpCode(key, current)[:5], 0, false},
+		{"not a number", "abcdef", 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, ok := ValidateTOTP(rfc6238Secret, tt.code, now, tt.lastStep); ok != tt.want {
+				t.Errorf("ValidateTOTP() = %v, want %v", ok, tt.want)
+			}
+		})
+	}
+
+	// Secrets typed in by hand may be lower case
+	if _, ok := ValidateTOTP(strings.ToLower(rfc6238Secret), "005924", now, 0); !ok {
+		t.Error("lower-case secret rejected")
+	}
+	if _, ok := ValidateTOTP("not base32!", "005924", now, 0); ok {
+		t.Error("invalid secret accepted")
+	}
+}
+
+func TestGenerateTOTPSecret(t *testing.T) {
+	secret, err := GenerateTOTPSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := totpEncoding.DecodeString(secret)
+	if err != nil || len(key) != 20 {
+		t.Errorf("secret %q decodes to %d bytes (%v), want 20", secret, len(key), err)
+	}
+
+	uri, err := url.Parse(TOTPProvisioningURI(secret, "user@example.com"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	params := uri.Query()
+	if uri.Scheme != "otpauth" || uri.Host != "totp" || params.Get("secret") != secret ||
+		params.Get("issuer") != TOTPIssuer || params.Get("digits") != "6" || params.Get("period") != "30" {
+		t.Errorf("unexpected provisioning URI %s", uri)
+	}
+}
+
+func TestRecoveryCodes(t *testing.T) {
+	codes, err := GenerateRecoveryCodes(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	seen := map[string]bool{}
+	for _, code := range codes {
+		if len(code) != 11 || code[5] != '-' {
+			t.Errorf("recovery code %q is not formatted as xxxxx-xxxxx", code)
+		}
+		seen[code] = true
+	}
+	if len(seen) != len(codes) {
+		t.Error("recovery codes repeat")
+	}
+
+	// Codes are matched however the user types them
+	if HashRecoveryCode(" "+strings.ToUpper(codes[0])+" ") != HashRecoveryCode(codes[0]) {
+		t.Error("hash depends on case or surrounding spaces")
+	}
+	if HashRecoveryCode(codes[0]) == HashRecoveryCode(codes[1]) {
+		t.Error("different codes hash the same")
+	}
+}
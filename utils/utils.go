@@ -4,42 +4,80 @@ Package utils provides utility functions for JWT handling and validation.
 package utils
 
 import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
 	"errors"
+	"net"
+	"net/http"
+	"net/netip"
 	"strings"
 	"time"
 
 	"github.com/dgrijalva/jwt-go"
 )
 
-var jwtKey = []byte("jwt-key")
+// SigningKey signs and verifies tokens. It is set from JWT_SECRET at startup; tokens are
+// neither issued nor accepted while it is empty.
+var SigningKey []byte
+
+var errNoSigningKey = errors.New("no token signing key configured")
+
+// signingKey returns the key for a token, refusing algorithms other than HMAC so that a
+// token can't choose how it is verified.
+func signingKey(token *jwt.Token) (interface{}, error) {
+	if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+		return nil, errors.New("unexpected signing method")
+	}
+	if len(SigningKey) == 0 {
+		return nil, errNoSigningKey
+	}
+	return SigningKey, nil
+}
+
+// sign signs claims with SigningKey.
+func sign(claims *Claims) (string, error) {
+	if len(SigningKey) == 0 {
+		return "", errNoSigningKey
+	}
+	return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(SigningKey)
+}
 
 type Claims struct {
-	Name  string `json:"name"`
-	Email string `json:"email"`
+	UserID string `json:"user_id"`
+	Name   string `json:"name"`
+	Email  string `json:"email"`
+	// Purpose is empty for access tokens and names the single action an action token is good for
+	Purpose string `json:"purpose,omitempty"`
+	// SessionID is the login session an access token belongs to
+	SessionID string `json:"sid,omitempty"`
 
 	jwt.StandardClaims
 }
 
+// AccessTokenTTL is how long access tokens, and the sessions they belong to, are valid
+const AccessTokenTTL = time.Hour
 
-func GenerateJWT(email, name string) (string, error) {
-	expirationTime := time.Now().Add(1 * time.Hour)
+func GenerateJWT(userID, email, name, sessionID string) (string, error) {
+	expirationTime := time.Now().Add(AccessTokenTTL)
 	claims := &Claims{
-		Name:  name,
-		Email: email,
+		UserID:    userID,
+		Name:      name,
+		Email:     email,
+		SessionID: sessionID,
 		StandardClaims: jwt.StandardClaims{
 			ExpiresAt: expirationTime.Unix(),
 		},
 	}
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString(jwtKey)
+	return sign(claims)
 }
 
 
 func ValidateJWT(tokenString string) (*Claims, error) {
 	claims := &Claims{}
-	token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
-		return jwtKey, nil
-	})
+	token, err := jwt.ParseWithClaims(tokenString, claims, signingKey)
 
 	if err != nil {
 		return nil, err
@@ -49,15 +87,163 @@ func ValidateJWT(tokenString string) (*Claims, error) {
 		return nil, errors.New("invalid token")
 	}
 
+	// Action tokens must never be usable as access tokens
+	if claims.Purpose != "" {
+		return nil, errors.New("invalid token")
+	}
+
+	return claims, nil
+}
+
+
+// GenerateCSRFToken returns a new random CSRF token for a cookie session, along with the hash
+// that is stored with the session.
+func GenerateCSRFToken() (token, hash string, err error) {
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", "", err
+	}
+	token = base64.RawURLEncoding.EncodeToString(raw)
+	return token, HashCSRFToken(token), nil
+}
+
+// HashCSRFToken hashes a CSRF token for comparison with the hash stored with its session.
+func HashCSRFToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// GenerateActionToken creates a short-lived token that only authorises the given purpose for email.
+func GenerateActionToken(purpose, email string, ttl time.Duration) (string, error) {
+	claims := &Claims{
+		Email:   email,
+		Purpose: purpose,
+		StandardClaims: jwt.StandardClaims{
+			ExpiresAt: time.Now().Add(ttl).Unix(),
+		},
+	}
+	return sign(claims)
+}
+
+
+// ValidateActionToken validates a token created by GenerateActionToken for purpose and returns its claims.
+func ValidateActionToken(tokenString, purpose string) (*Claims, error) {
+	claims := &Claims{}
+	token, err := jwt.ParseWithClaims(tokenString, claims, signingKey)
+	if err != nil {
+		return nil, err
+	}
+
+	if !token.Valid || claims.Purpose != purpose {
+		return nil, errors.New("invalid token")
+	}
+
 	return claims, nil
 }
 
 
-func StrongPassword(password string) bool {
-	return len(password) >= 8
+// PublicURL is the externally visible base URL of the API, used to build links in emails.
+var PublicURL = "http://localhost:5000"
+
+
+// TrustedProxies are the reverse proxies in front of the API. Requests arriving through them
+// are attributed to the address they forwarded for, read from X-Forwarded-For.
+var TrustedProxies []netip.Prefix
+
+
+// ClientIP returns the IP address of the client that sent the request. Proxies append the
+// address they received a request from to X-Forwarded-For, and anything left of that is
+// whatever the client sent, so the header is read from the right and the first address
+// that isn't a trusted proxy wins.
+func ClientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	if !trustedProxy(host) {
+		return host
+	}
+
+	var hops []string
+	for _, header := range r.Header.Values("X-Forwarded-For") {
+		hops = append(hops, strings.Split(header, ",")...)
+	}
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := strings.TrimSpace(hops[i])
+		if _, err := netip.ParseAddr(hop); err != nil {
+			// Stopping at garbage, which no trusted proxy would have written
+			break
+		}
+		host = hop
+		if !trustedProxy(hop) {
+			break
+		}
+	}
+	return host
+}
+
+// trustedProxy reports whether addr belongs to one of TrustedProxies.
+func trustedProxy(addr string) bool {
+	ip, err := netip.ParseAddr(addr)
+	if err != nil {
+		return false
+	}
+	ip = ip.Unmap()
+	for _, prefix := range TrustedProxies {
+		if prefix.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseProxies reads a comma-separated list of IP addresses and CIDR ranges, as used in configuration.
+func ParseProxies(list string) ([]netip.Prefix, error) {
+	var prefixes []netip.Prefix
+	for _, entry := range strings.Split(list, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if !strings.Contains(entry, "/") {
+			ip, err := netip.ParseAddr(entry)
+			if err != nil {
+				return nil, err
+			}
+			prefixes = append(prefixes, netip.PrefixFrom(ip.Unmap(), ip.Unmap().BitLen()))
+			continue
+		}
+		prefix, err := netip.ParsePrefix(entry)
+		if err != nil {
+			return nil, err
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+	return prefixes, nil
+}
+
+// RequestIDHeader carries the ID a client or proxy assigned to a request.
+const RequestIDHeader = "X-Request-ID"
+
+type requestIDKey struct{}
+
+// WithRequestID returns a copy of ctx carrying the ID of the request being served.
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// RequestID returns the ID the request-ID middleware assigned to r, or the ID the client
+// sent when the middleware didn't run.
+func RequestID(r *http.Request) string {
+	if id, ok := r.Context().Value(requestIDKey{}).(string); ok {
+		return id
+	}
+	return r.Header.Get(RequestIDHeader)
 }
 
 
-func IsValidGmail(email string) bool {
-	return strings.HasSuffix(email, "@gmail.com")
+// NormalizeEmail lowercases and trims an email address so that lookups and the
+// unique email index treat differently-cased addresses as the same account.
+func NormalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
 }
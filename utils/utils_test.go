@@ -0,0 +1,50 @@
+package utils
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestClientIP(t *testing.T) {
+	proxies, err := ParseProxies("10.0.0.0/8, 192.0.2.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	TrustedProxies = proxies
+	defer func() { TrustedProxies = nil }()
+
+	tests := []struct {
+		name      string
+		remote    string
+		forwarded []string
+		want      string
+	}{
+		{"direct client", "203.0.113.5:1234", nil, "203.0.113.5"},
+		{"untrusted peer can't forward", "203.0.113.5:1234", []string{"198.51.100.1"}, "203.0.113.5"},
+		{"one proxy", "10.0.0.1:1234", []string{"198.51.100.1"}, "198.51.100.1"},
+		{"spoofed leftmost entry", "10.0.0.1:1234", []string{"1.2.3.4, 198.51.100.1"}, "198.51.100.1"},
+		{"chain of proxies", "10.0.0.1:1234", []string{"1.2.3.4, 198.51.100.1, 192.0.2.1, 10.1.1.1"}, "198.51.100.1"},
+		{"repeated headers", "10.0.0.1:1234", []string{"1.2.3.4", "198.51.100.1"}, "198.51.100.1"},
+		{"garbage from client", "10.0.0.1:1234", []string{"not-an-ip, 198.51.100.1"}, "198.51.100.1"},
+		{"only proxies", "10.0.0.1:1234", []string{"10.2.2.2"}, "10.2.2.2"},
+		{"proxy without header", "10.0.0.1:1234", nil, "10.0.0.1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/", nil)
+			r.RemoteAddr = tt.remote
+			for _, value := range tt.forwarded {
+				r.Header.Add("X-Forwarded-For", value)
+			}
+			if got := ClientIP(r); got != tt.want {
+				t.Errorf("ClientIP() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseProxiesRejectsInvalidEntries(t *testing.T) {
+	if _, err := ParseProxies("10.0.0.0/8, proxy.local"); err == nil {
+		t.Error("ParseProxies accepted a hostname")
+	}
+}
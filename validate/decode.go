@@ -0,0 +1,89 @@
+package validate
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/Aman913k/problem"
+)
+
+// MaxBodyBytes limits the size of JSON request bodies.
+var MaxBodyBytes int64 = 1 << 20
+
+var errTrailingData = errors.New("trailing data after JSON value")
+
+// DecodeJSON decodes the JSON body of r into dst and validates it. Unknown fields, trailing
+// data and bodies over MaxBodyBytes are rejected. When the body is refused it responds with
+// a problem and returns false.
+func DecodeJSON(w http.ResponseWriter, r *http.Request, dst interface{}) bool {
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, MaxBodyBytes))
+	decoder.DisallowUnknownFields()
+
+	err := decoder.Decode(dst)
+	if err == nil {
+		// Refusing anything after the value, such as a second object
+		if _, extra := decoder.Token(); extra != io.EOF {
+			err = errTrailingData
+		}
+	}
+	if err != nil {
+		writeDecodeError(w, r, err)
+		return false
+	}
+
+	if violations := Struct(dst); len(violations) > 0 {
+		problem.Invalid(w, r, "Request failed validation", violations...)
+		return false
+	}
+	return true
+}
+
+// writeDecodeError explains why a request body couldn't be decoded.
+func writeDecodeError(w http.ResponseWriter, r *http.Request, err error) {
+	var (
+		maxBytesErr *http.MaxBytesError
+		syntaxErr   *json.SyntaxError
+		typeErr     *json.UnmarshalTypeError
+	)
+	switch {
+	case errors.As(err, &maxBytesErr):
+		problem.Error(w, r, http.StatusRequestEntityTooLarge, problem.CodeRequestTooLarge,
+			fmt.Sprintf("Request body is larger than %d bytes", maxBytesErr.Limit))
+	case errors.Is(err, io.EOF):
+		problem.Error(w, r, http.StatusBadRequest, problem.CodeInvalidRequest, "Request body is empty")
+	case errors.Is(err, errTrailingData):
+		problem.Error(w, r, http.StatusBadRequest, problem.CodeInvalidRequest, "Request body must hold a single JSON value")
+	case errors.As(err, &syntaxErr), errors.Is(err, io.ErrUnexpectedEOF):
+		problem.Error(w, r, http.StatusBadRequest, problem.CodeInvalidRequest, "Request body is not valid JSON")
+	case errors.As(err, &typeErr):
+		problem.Invalid(w, r, "Request failed validation", problem.FieldError{
+			Field: typeErr.Field, Code: "invalid_type", Message: "Must be a " + jsonType(typeErr.Type.Kind().String()),
+		})
+	case strings.HasPrefix(err.Error(), "json: unknown field "):
+		field := strings.Trim(strings.TrimPrefix(err.Error(), "json: unknown field "), `"`)
+		problem.Invalid(w, r, "Request failed validation", problem.FieldError{
+			Field: field, Code: "unknown_field", Message: "Field is not allowed",
+		})
+	default:
+		problem.Error(w, r, http.StatusBadRequest, problem.CodeInvalidRequest, "Request body is not valid JSON")
+	}
+}
+
+// jsonType names the JSON type a Go kind is decoded from.
+func jsonType(kind string) string {
+	switch kind {
+	case "string":
+		return "string"
+	case "bool":
+		return "boolean"
+	case "slice", "array":
+		return "list"
+	case "struct", "map":
+		return "object"
+	}
+	return "number"
+}
@@ -0,0 +1,144 @@
+/*
+Package validate checks request payloads against rules declared in `validate` struct tags
+and decodes request bodies strictly.
+
+Rules are separated by commas and use the same syntax the Swagger generator understands:
+
+	required   the field must not be empty
+	min=N      strings have at least N characters, slices at least N elements
+	max=N      strings have at most N characters, slices at most N elements
+	email      the field is a bare email address
+	oneof=a b  the field is one of the space-separated values
+	dive       the rules after it apply to each element of a slice
+
+Empty fields that aren't required are not checked further.
+*/
+package validate
+
+import (
+	"fmt"
+	"net/mail"
+	"reflect"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/Aman913k/problem"
+)
+
+// Struct checks the fields of the struct v points to, returning every violation.
+func Struct(v interface{}) []problem.FieldError {
+	value := reflect.Indirect(reflect.ValueOf(v))
+	if value.Kind() != reflect.Struct {
+		return nil
+	}
+
+	var violations []problem.FieldError
+	for i := 0; i < value.NumField(); i++ {
+		field := value.Type().Field(i)
+		rules := field.Tag.Get("validate")
+		if rules == "" || !field.IsExported() {
+			continue
+		}
+		violations = append(violations, checkField(fieldName(field), value.Field(i), strings.Split(rules, ","))...)
+	}
+	return violations
+}
+
+// fieldName returns the name a field has in JSON.
+func fieldName(field reflect.StructField) string {
+	name := strings.Split(field.Tag.Get("json"), ",")[0]
+	if name == "" || name == "-" {
+		return field.Name
+	}
+	return name
+}
+
+// checkField applies rules to one field, stopping at the first rule it breaks.
+func checkField(name string, value reflect.Value, rules []string) []problem.FieldError {
+	if isEmpty(value) {
+		for _, rule := range rules {
+			if rule == "dive" {
+				break
+			}
+			if rule == "required" {
+				return []problem.FieldError{{Field: name, Code: "required", Message: "Field is required"}}
+			}
+		}
+		return nil
+	}
+
+	for i, rule := range rules {
+		if rule == "dive" {
+			var violations []problem.FieldError
+			for j := 0; j < value.Len(); j++ {
+				violations = append(violations, checkField(fmt.Sprintf("%s[%d]", name, j), value.Index(j), rules[i+1:])...)
+			}
+			return violations
+		}
+		if violation := checkRule(name, value, rule); violation != nil {
+			return []problem.FieldError{*violation}
+		}
+	}
+	return nil
+}
+
+// checkRule applies a single rule to a non-empty field.
+func checkRule(name string, value reflect.Value, rule string) *problem.FieldError {
+	key, param, _ := strings.Cut(rule, "=")
+	switch key {
+	case "required":
+		return nil
+
+	case "min", "max":
+		limit, err := strconv.Atoi(param)
+		if err != nil {
+			panic(fmt.Sprintf("validate: invalid %s rule on %s", rule, name))
+		}
+		length, unit := size(value)
+		if key == "min" && length < limit {
+			return &problem.FieldError{Field: name, Code: "too_short", Message: fmt.Sprintf("Must have at least %d %s", limit, unit)}
+		}
+		if key == "max" && length > limit {
+			return &problem.FieldError{Field: name, Code: "too_long", Message: fmt.Sprintf("Must have at most %d %s", limit, unit)}
+		}
+
+	case "email":
+		email := strings.TrimSpace(value.String())
+		if addr, err := mail.ParseAddress(email); err != nil || addr.Name != "" || addr.Address != email {
+			return &problem.FieldError{Field: name, Code: "invalid_email", Message: "Must be an email address"}
+		}
+
+	case "oneof":
+		allowed := strings.Fields(param)
+		for _, option := range allowed {
+			if value.String() == option {
+				return nil
+			}
+		}
+		return &problem.FieldError{Field: name, Code: "not_allowed", Message: "Must be one of: " + strings.Join(allowed, ", ")}
+
+	default:
+		panic(fmt.Sprintf("validate: unknown rule %q on %s", rule, name))
+	}
+	return nil
+}
+
+// size returns the length of a string in characters, or of a slice in elements.
+func size(value reflect.Value) (int, string) {
+	if value.Kind() == reflect.String {
+		return utf8.RuneCountInString(value.String()), "characters"
+	}
+	return value.Len(), "elements"
+}
+
+// isEmpty reports whether a field holds its zero value, counting blank strings as empty.
+func isEmpty(value reflect.Value) bool {
+	switch value.Kind() {
+	case reflect.String:
+		return strings.TrimSpace(value.String()) == ""
+	case reflect.Slice, reflect.Map:
+		return value.Len() == 0
+	}
+	return value.IsZero()
+}
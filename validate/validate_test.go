@@ -0,0 +1,144 @@
+package validate
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/Aman913k/problem"
+)
+
+type signUp struct {
+	Name   string   `json:"name" validate:"required,min=2,max=5"`
+	Email  string   `json:"email" validate:"required,email"`
+	Role   string   `json:"role,omitempty" validate:"oneof=user admin"`
+	Scopes []string `json:"scopes" validate:"max=2,dive,oneof=read write"`
+	Tags   []string `validate:"required,min=1"`
+	Note   string   `json:"note"`
+}
+
+func valid() signUp {
+	return signUp{Name: "Ana", Email: "ana@example.com", Role: "user", Scopes: []string{"read"}, Tags: []string{"a"}}
+}
+
+func TestStruct(t *testing.T) {
+	tests := []struct {
+		name   string
+		change func(*signUp)
+		want   []problem.FieldError
+	}{
+		{"valid", func(*signUp) {}, nil},
+		{"missing", func(s *signUp) { s.Name = "" }, []problem.FieldError{{Field: "name", Code: "required"}}},
+		{"blank counts as missing", func(s *signUp) { s.Name = "   " }, []problem.FieldError{{Field: "name", Code: "required"}}},
+		{"too short", func(s *signUp) { s.Name = "A" }, []problem.FieldError{{Field: "name", Code: "too_short"}}},
+		{"too long", func(s *signUp) { s.Name = "Anastasia" }, []problem.FieldError{{Field: "name", Code: "too_long"}}},
+		{"length in characters", func(s *signUp) { s.Name = "Ådélé" }, nil},
+		{"not an email", func(s *signUp) { s.Email = "ana" }, []problem.FieldError{{Field: "email", Code: "invalid_email"}}},
+		{"email with a display name", func(s *signUp) { s.Email = "Ana <ana@example.com>" }, []problem.FieldError{{Field: "email", Code: "invalid_email"}}},
+		{"not one of", func(s *signUp) { s.Role = "root" }, []problem.FieldError{{Field: "role", Code: "not_allowed"}}},
+		{"optional and empty", func(s *signUp) { s.Role, s.Scopes = "", nil }, nil},
+		{"too many elements", func(s *signUp) { s.Scopes = []string{"read", "write", "read"} }, []problem.FieldError{{Field: "scopes", Code: "too_long"}}},
+		{"dive into elements", func(s *signUp) { s.Scopes = []string{"read", "delete"} }, []problem.FieldError{{Field: "scopes[1]", Code: "not_allowed"}}},
+		{"no JSON name", func(s *signUp) { s.Tags = nil }, []problem.FieldError{{Field: "Tags", Code: "required"}}},
+		{
+			"every violation",
+			func(s *signUp) { s.Name, s.Email = "", "ana" },
+			[]problem.FieldError{{Field: "name", Code: "required"}, {Field: "email", Code: "invalid_email"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			payload := valid()
+			tt.change(&payload)
+			if got := fieldCodes(Struct(&payload)); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Struct() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestStructPanicsOnUnknownRule(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("unknown rule didn't panic")
+		}
+	}()
+	Struct(&struct {
+		Name string `validate:"uppercase"`
+	}{Name: "x"})
+}
+
+func TestDecodeJSON(t *testing.T) {
+	tests := []struct {
+		name   string
+		body   string
+		status int
+		code   string
+		fields []problem.FieldError
+	}{
+		{"valid", `{"name":"Ana","email":"ana@example.com","Tags":["a"]}`, http.StatusOK, "", nil},
+		{"empty body", ``, http.StatusBadRequest, problem.CodeInvalidRequest, nil},
+		{"not JSON", `name=Ana`, http.StatusBadRequest, problem.CodeInvalidRequest, nil},
+		{"cut short", `{"name":"Ana"`, http.StatusBadRequest, problem.CodeInvalidRequest, nil},
+		{"trailing object", `{"name":"Ana","email":"ana@example.com","Tags":["a"]}{}`, http.StatusBadRequest, problem.CodeInvalidRequest, nil},
+		{"trailing garbage", `{"name":"Ana","email":"ana@example.com","Tags":["a"]} x`, http.StatusBadRequest, problem.CodeInvalidRequest, nil},
+		{
+			"unknown field", `{"name":"Ana","email":"ana@example.com","Tags":["a"],"admin":true}`,
+			http.StatusBadRequest, problem.CodeValidationFailed, []problem.FieldError{{Field: "admin", Code: "unknown_field"}},
+		},
+		{
+			"wrong type", `{"name":7}`,
+			http.StatusBadRequest, problem.CodeValidationFailed, []problem.FieldError{{Field: "name", Code: "invalid_type"}},
+		},
+		{
+			"failed rules", `{"name":"A","email":"ana@example.com","Tags":["a"]}`,
+			http.StatusBadRequest, problem.CodeValidationFailed, []problem.FieldError{{Field: "name", Code: "too_short"}},
+		},
+		{
+			"too large", `{"name":"` + strings.Repeat("a", 100) + `"}`,
+			http.StatusRequestEntityTooLarge, problem.CodeRequestTooLarge, nil,
+		},
+	}
+
+	defer func(limit int64) { MaxBodyBytes = limit }(MaxBodyBytes)
+	MaxBodyBytes = 80
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodPost, "/register", strings.NewReader(tt.body))
+
+			var payload signUp
+			ok := DecodeJSON(w, r, &payload)
+			if ok != (tt.status == http.StatusOK) {
+				t.Fatalf("DecodeJSON() = %v, response %d: %s", ok, w.Code, w.Body)
+			}
+			if ok {
+				return
+			}
+
+			var p problem.Problem
+			if err := json.Unmarshal(w.Body.Bytes(), &p); err != nil {
+				t.Fatal(err)
+			}
+			if w.Code != tt.status || p.Code != tt.code {
+				t.Errorf("response %d %s, want %d %s", w.Code, p.Code, tt.status, tt.code)
+			}
+			if got := fieldCodes(p.Errors); !reflect.DeepEqual(got, tt.fields) {
+				t.Errorf("field errors = %v, want %v", got, tt.fields)
+			}
+		})
+	}
+}
+
+// fieldCodes drops the messages of field errors, which are meant for people.
+func fieldCodes(violations []problem.FieldError) []problem.FieldError {
+	var codes []problem.FieldError
+	for _, v := range violations {
+		codes = append(codes, problem.FieldError{Field: v.Field, Code: v.Code})
+	}
+	return codes
+}